@@ -0,0 +1,256 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+// registerAPIHandlers adds the JSON REST API for books to r, under /api/v1.
+func registerAPIHandlers(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	api.Methods("GET").Path("/books").
+		Handler(apiHandler(apiListHandler))
+	api.Methods("POST").Path("/books").
+		Handler(apiHandler(apiCreateHandler))
+	api.Methods("GET").Path("/books/{id:[0-9]+}").
+		Handler(apiHandler(apiGetHandler))
+	api.Methods("PUT").Path("/books/{id:[0-9]+}").
+		Handler(apiHandler(apiUpdateHandler))
+	api.Methods("DELETE").Path("/books/{id:[0-9]+}").
+		Handler(apiHandler(apiDeleteHandler))
+}
+
+// apiBookList is the response body of apiListHandler.
+type apiBookList struct {
+	Books []*bookshelf.Book `json:"books"`
+	// NextCursor is the cursor of the next page, if there is one.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// apiListHandler responds with a page of books, ordered by title. The page
+// is selected with the optional "pageSize" and "cursor" query parameters.
+func apiListHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var size int
+	if s := r.FormValue("pageSize"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size <= 0 {
+			return apiErrorf(http.StatusBadRequest, err, "pageSize must be a positive integer")
+		}
+	}
+
+	books, next, err := bookshelf.DB.ListBooksPage(r.Context(), size, r.FormValue("cursor"))
+	if err != nil {
+		return appErrorf(err, "could not list books: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, &apiBookList{Books: books, NextCursor: next})
+}
+
+// apiBookFromRequest retrieves the book whose ID is in the URL's path.
+func apiBookFromRequest(r *http.Request) (*bookshelf.Book, *appError) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, err, "bad book id: %v", err)
+	}
+	book, err := bookshelf.DB.GetBook(r.Context(), id)
+	if err == bookshelf.ErrNoSuchBook {
+		return nil, apiErrorf(http.StatusNotFound, err, "no book with id %d", id)
+	}
+	if err != nil {
+		return nil, appErrorf(err, "could not get book: %v", err)
+	}
+	return book, nil
+}
+
+// apiGetHandler responds with a single book and its ETag.
+func apiGetHandler(w http.ResponseWriter, r *http.Request) *appError {
+	book, appErr := apiBookFromRequest(r)
+	if appErr != nil {
+		return appErr
+	}
+
+	tag := etag(book)
+	w.Header().Set("ETag", tag)
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return writeJSON(w, http.StatusOK, book)
+}
+
+// apiCreateHandler adds the book in the request body to the database, and
+// responds with the stored book.
+func apiCreateHandler(w http.ResponseWriter, r *http.Request) *appError {
+	book, appErr := bookFromJSON(r)
+	if appErr != nil {
+		return appErr
+	}
+	if book.ID != 0 {
+		return apiErrorf(http.StatusBadRequest, nil, "id must not be set")
+	}
+	setCreator(r, book)
+
+	id, err := bookshelf.DB.AddBook(r.Context(), book)
+	if err != nil {
+		return appErrorf(err, "could not save book: %v", err)
+	}
+	book.ID = id
+	go publishUpdate(r.Context(), id)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/books/%d", id))
+	w.Header().Set("ETag", etag(book))
+	return writeJSON(w, http.StatusCreated, book)
+}
+
+// apiUpdateHandler replaces the details of a given book with those in the
+// request body. The request must say which state of the book it replaces,
+// with the version in the body or an If-Match header with its ETag, or both;
+// if the book has been modified since, it responds with a conflict or a
+// failed precondition, and without either with 428 Precondition Required.
+func apiUpdateHandler(w http.ResponseWriter, r *http.Request) *appError {
+	old, appErr := apiBookFromRequest(r)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr := authorize(r, "update", old); appErr != nil {
+		return appErr
+	}
+	if m := r.Header.Get("If-Match"); m != "" && m != etag(old) {
+		return apiErrorf(http.StatusPreconditionFailed, nil, "book %d has been modified", old.ID)
+	}
+
+	book, appErr := bookFromJSON(r)
+	if appErr != nil {
+		return appErr
+	}
+	if book.ID != 0 && book.ID != old.ID {
+		return apiErrorf(http.StatusBadRequest, nil, "id %d does not match the URL", book.ID)
+	}
+	book.ID = old.ID
+	// The creator of a book cannot be changed.
+	book.CreatedBy = old.CreatedBy
+	book.CreatedByID = old.CreatedByID
+	if book.Version == 0 {
+		if r.Header.Get("If-Match") == "" {
+			return apiErrorf(http.StatusPreconditionRequired, nil, "the version of book %d or an If-Match header is required", old.ID)
+		}
+		// old is the state matched, so the update must replace it.
+		book.Version = old.Version
+	}
+
+	if err := bookshelf.DB.UpdateBook(r.Context(), book); err != nil {
+		if _, ok := err.(*bookshelf.ConflictError); ok {
+			return apiErrorf(http.StatusConflict, err, "book %d has been modified since version %d; get it and try again", book.ID, book.Version)
+		}
+		return appErrorf(err, "could not save book: %v", err)
+	}
+	go publishUpdate(r.Context(), book.ID)
+
+	w.Header().Set("ETag", etag(book))
+	return writeJSON(w, http.StatusOK, book)
+}
+
+// apiDeleteHandler deletes a given book.
+func apiDeleteHandler(w http.ResponseWriter, r *http.Request) *appError {
+	book, appErr := apiBookFromRequest(r)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr := authorize(r, "delete", book); appErr != nil {
+		return appErr
+	}
+	if m := r.Header.Get("If-Match"); m != "" && m != etag(book) {
+		return apiErrorf(http.StatusPreconditionFailed, nil, "book %d has been modified", book.ID)
+	}
+
+	if err := bookshelf.DB.DeleteBook(r.Context(), book.ID); err != nil {
+		return appErrorf(err, "could not delete book: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// bookFromJSON decodes and validates the book in the request body.
+//
+// The API is authenticated by the session cookie, but has no CSRF token, so
+// the body must be of type application/json: cross-site forms cannot send
+// it, and cross-site scripts cannot without a CORS preflight.
+func bookFromJSON(r *http.Request) (*bookshelf.Book, *appError) {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		return nil, apiErrorf(http.StatusUnsupportedMediaType, err, "the request body must be of type application/json")
+	}
+	book := &bookshelf.Book{}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(book); err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, err, "invalid book: %v", err)
+	}
+	if book.Title == "" {
+		return nil, apiErrorf(http.StatusBadRequest, nil, "title is required")
+	}
+	return book, nil
+}
+
+// etag returns a strong entity tag for the current state of a book.
+func etag(b *bookshelf.Book) string {
+	j, err := json.Marshal(b)
+	if err != nil {
+		// A Book only has string and integer fields.
+		panic(err)
+	}
+	return fmt.Sprintf(`"%x"`, sha1.Sum(j))
+}
+
+// writeJSON responds with the given status code and v encoded as JSON.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) *appError {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return appErrorf(err, "could not encode response: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(j)
+	return nil
+}
+
+// apiHandler is like appHandler, but reports errors as JSON.
+type apiHandler func(http.ResponseWriter, *http.Request) *appError
+
+func (fn apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := fn(w, r); e != nil {
+		log.Printf("API handler error: status code: %d, message: %s, underlying err: %#v",
+			e.Code, e.Message, e.Error)
+		e.record(r)
+
+		writeJSON(w, e.Code, struct {
+			Error string `json:"error"`
+		}{e.Message})
+	}
+}
+
+// apiErrorf returns an appError with the given HTTP status code, and the
+// kind of error it stands for. err may be nil if the error was detected by
+// the handler itself.
+func apiErrorf(code int, err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	if e.Error == nil {
+		e.Error = errors.New(e.Message)
+	}
+	e.Code = code
+	e.Kind = kindOfStatus(code)
+	return e
+}
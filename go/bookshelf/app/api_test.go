@@ -0,0 +1,167 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+// doJSON is like do, with a JSON request body.
+func doJSON(method, target, body string, header http.Header) *http.Response {
+	return do(method, target, "application/json", strings.NewReader(body), header)
+}
+
+// decodeBook decodes the book in the body of resp.
+func decodeBook(t *testing.T, resp *http.Response) *bookshelf.Book {
+	t.Helper()
+	defer resp.Body.Close()
+	b := &bookshelf.Book{}
+	if err := json.NewDecoder(resp.Body).Decode(b); err != nil {
+		t.Fatalf("could not decode book: %v", err)
+	}
+	return b
+}
+
+func TestAPICreate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"form", "application/x-www-form-urlencoded", "title=Title", http.StatusUnsupportedMediaType},
+		{"no content type", "", `{"title": "Title"}`, http.StatusUnsupportedMediaType},
+		{"bad json", "application/json", `{"title": `, http.StatusBadRequest},
+		{"unknown field", "application/json", `{"title": "Title", "pages": 100}`, http.StatusBadRequest},
+		{"no title", "application/json", `{"author": "Author"}`, http.StatusBadRequest},
+		{"id", "application/json", `{"id": 5, "title": "Title"}`, http.StatusBadRequest},
+	} {
+		resp := do("POST", "/api/v1/books", tc.contentType, strings.NewReader(tc.body), nil)
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+		var e struct{ Error string }
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			t.Errorf("%s: got no JSON error: %v", tc.name, err)
+		}
+	}
+
+	resp := doJSON("POST", "/api/v1/books", `{"title": "Title", "author": "Author", "createdById": "someone"}`, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	book := decodeBook(t, resp)
+	if want := fmt.Sprintf("/api/v1/books/%d", book.ID); resp.Header.Get("Location") != want {
+		t.Errorf("got Location %q, want %q", resp.Header.Get("Location"), want)
+	}
+	if got := resp.Header.Get("ETag"); got != etag(book) {
+		t.Errorf("got ETag %q, want %q", got, etag(book))
+	}
+	if book.Title != "Title" || book.Version != 1 || book.CreatedByID != "anonymous" {
+		t.Errorf("got book %+v, want version 1 of Title by anonymous", book)
+	}
+
+	resp = do("GET", fmt.Sprintf("/api/v1/books/%d", book.ID), "", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := decodeBook(t, resp); *got != *book {
+		t.Errorf("got book %+v, want %+v", got, book)
+	}
+}
+
+func TestAPIGet(t *testing.T) {
+	resp := doJSON("POST", "/api/v1/books", `{"title": "Title"}`, nil)
+	book := decodeBook(t, resp)
+	url := fmt.Sprintf("/api/v1/books/%d", book.ID)
+
+	resp = do("GET", url, "", nil, nil)
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("got status %d and ETag %q, want %d and an ETag", resp.StatusCode, tag, http.StatusOK)
+	}
+	if resp = do("GET", url, "", nil, http.Header{"If-None-Match": {tag}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match of the current ETag: got status %d, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+	if resp = do("GET", url, "", nil, http.Header{"If-None-Match": {`"other"`}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("If-None-Match of another ETag: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if resp = do("GET", "/api/v1/books/999999999", "", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing book: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp = do("GET", "/api/v1/books/99999999999999999999", "", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad ID: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestAPIUpdate(t *testing.T) {
+	resp := doJSON("POST", "/api/v1/books", `{"title": "Title"}`, nil)
+	book := decodeBook(t, resp)
+	url := fmt.Sprintf("/api/v1/books/%d", book.ID)
+	tag := resp.Header.Get("ETag")
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		header http.Header
+		want   int
+	}{
+		{"no version", `{"title": "Changed"}`, nil, http.StatusPreconditionRequired},
+		{"stale ETag", `{"title": "Changed"}`, http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		{"stale version", `{"title": "Changed", "version": 7}`, nil, http.StatusConflict},
+		{"other id", fmt.Sprintf(`{"id": %d, "title": "Changed", "version": 1}`, book.ID+1), nil, http.StatusBadRequest},
+	} {
+		if resp := doJSON("PUT", url, tc.body, tc.header); resp.StatusCode != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+	if resp := doJSON("PUT", "/api/v1/books/999999999", `{"title": "Changed", "version": 1}`, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing book: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	// The ETag stands for the version it was returned with.
+	resp = doJSON("PUT", url, `{"title": "Changed"}`, http.Header{"If-Match": {tag}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("If-Match of the current ETag: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	book = decodeBook(t, resp)
+	if book.Title != "Changed" || book.Version != 2 || resp.Header.Get("ETag") != etag(book) {
+		t.Errorf("got book %+v with ETag %q, want version 2 of Changed with ETag %q", book, resp.Header.Get("ETag"), etag(book))
+	}
+	if resp := doJSON("PUT", url, `{"title": "Again"}`, http.Header{"If-Match": {tag}}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("If-Match of a replaced ETag: got status %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	if resp := doJSON("PUT", url, `{"title": "Again", "version": 1}`, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("replaced version: got status %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	if resp := doJSON("PUT", url, `{"title": "Again", "version": 2}`, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("current version: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestAPIDelete(t *testing.T) {
+	book := decodeBook(t, doJSON("POST", "/api/v1/books", `{"title": "Title"}`, nil))
+	url := fmt.Sprintf("/api/v1/books/%d", book.ID)
+
+	if resp := do("DELETE", url, "", nil, http.Header{"If-Match": {`"stale"`}}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale ETag: got status %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	if resp := do("DELETE", url, "", nil, http.Header{"If-Match": {etag(book)}}); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := do("GET", url, "", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted book: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp := do("DELETE", url, "", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted again: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -7,18 +7,16 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
 	"strconv"
-
-	"cloud.google.com/go/pubsub"
-	"cloud.google.com/go/storage"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -29,28 +27,107 @@ import (
 	"google.golang.org/appengine"
 
 	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
-	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 	"go.opencensus.io/zpages"
 )
 
 var (
 	// See template.go
-	listTmpl   = parseTemplate("list.html")
-	editTmpl   = parseTemplate("edit.html")
-	detailTmpl = parseTemplate("detail.html")
+	listTmpl    = parseTemplate("list.html")
+	editTmpl    = parseTemplate("edit.html")
+	detailTmpl  = parseTemplate("detail.html")
+	searchTmpl  = parseTemplate("search.html")
+	historyTmpl = parseTemplate("history.html")
+	trashTmpl   = parseTemplate("trash.html")
+	importTmpl  = parseTemplate("import.html")
 )
 
-var booksPerPage = stats.Int64("books_per_page", "number of books rendered on a page", stats.UnitNone)
+var (
+	booksPerPage  = stats.Int64("books_per_page", "number of books rendered on a page", stats.UnitNone)
+	searchLatency = stats.Float64("search_latency", "latency of book searches", stats.UnitMilliseconds)
+	searchResults = stats.Int64("search_results", "number of books found by a search", stats.UnitNone)
+	handlerErrors = stats.Int64("handler_errors", "number of requests that failed", stats.UnitNone)
+
+	// keyErrorClass tags failed requests with the kind of their error, e.g.
+	// "not_found".
+	keyErrorClass, _ = tag.NewKey("error_class")
+)
 
 func main() {
+	standalone := flag.Bool("standalone", false, "serve on $PORT (default 8080) rather than with appengine.Main, and drain in-flight requests on SIGTERM")
+	cfg, err := bookshelf.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// The background work stops when a standalone server shuts down.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// With the in-process queue, the worker must run in this binary.
+	if cfg.Queue == "memory" {
+		worker, err := bookshelf.NewWorker(bookshelf.DB, bookshelf.Updates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := worker.Run(ctx); ctx.Err() == nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	go bookshelf.NewTrashPurger(bookshelf.DB, bookshelf.TrashRetention).Run(ctx)
+
+	// Drop the cached books changed by the worker and the other instances.
+	if bookshelf.Cache != nil && bookshelf.Changes != nil {
+		go func() {
+			if err := bookshelf.Cache.Follow(ctx, bookshelf.Changes); ctx.Err() == nil {
+				log.Printf("Stopped following book changes; other processes' changes show once cached books expire: %v", err)
+			}
+		}()
+	}
+
 	registerHandlers()
 	view.Register(&view.View{
 		Aggregation: view.Distribution(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 20, 30, 100, 200, 300, 500, 1000),
 		Measure:     booksPerPage,
 	})
-	appengine.Main()
+	view.Register(&view.View{
+		Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000),
+		Measure:     searchLatency,
+	}, &view.View{
+		Aggregation: view.Distribution(0, 1, 2, 3, 4, 5, 10, 20, 50, 100),
+		Measure:     searchResults,
+	}, &view.View{
+		Aggregation: view.Count(),
+		Measure:     csrfRejected,
+		TagKeys:     []tag.Key{keyCSRFReason},
+	}, &view.View{
+		Aggregation: view.Count(),
+		Measure:     handlerErrors,
+		TagKeys:     []tag.Key{keyRoute, keyErrorClass},
+	}, serverLatencyByRouteView, serverResponsesByRouteView)
+
+	if !*standalone {
+		appengine.Main()
+		return
+	}
+	serve(func() {
+		cancel()
+		if bookshelf.Updates != nil {
+			bookshelf.Updates.Close()
+		}
+		if bookshelf.Changes != nil {
+			bookshelf.Changes.Close()
+		}
+		bookshelf.DB.Close(context.Background())
+	})
 }
 
 func registerHandlers() {
@@ -64,57 +141,157 @@ func registerHandlers() {
 		Handler(appHandler(listHandler))
 	r.Methods("GET").Path("/books/mine").
 		Handler(appHandler(listMineHandler))
+	r.Methods("GET").Path("/books/search").
+		Handler(appHandler(searchHandler))
+	r.Methods("GET").Path("/books/trash").
+		Handler(appHandler(trashHandler))
+	r.Methods("GET").Path("/books/export").
+		Handler(appHandler(exportHandler))
+	r.Methods("GET").Path("/books/import").
+		Handler(appHandler(importFormHandler))
 	r.Methods("GET").Path("/books/{id:[0-9]+}").
 		Handler(appHandler(detailHandler))
 	r.Methods("GET").Path("/books/add").
 		Handler(appHandler(addFormHandler))
+	r.Methods("GET").Path("/books/{id:[0-9]+}/history").
+		Handler(appHandler(historyHandler))
 	r.Methods("GET").Path("/books/{id:[0-9]+}/edit").
-		Handler(appHandler(editFormHandler))
+		Handler(requireOwner("edit", editFormHandler))
 
 	r.Methods("POST").Path("/books").
-		Handler(appHandler(createHandler))
+		Handler(requireCSRF(appHandler(createHandler)))
+	r.Methods("POST").Path("/books/import").
+		Handler(limitBody(maxImportSize, requireCSRF(appHandler(importHandler))))
 	r.Methods("POST", "PUT").Path("/books/{id:[0-9]+}").
-		Handler(appHandler(updateHandler))
+		Handler(requireCSRF(requireOwner("update", updateHandler)))
 	r.Methods("POST").Path("/books/{id:[0-9]+}:delete").
-		Handler(appHandler(deleteHandler)).Name("delete")
+		Handler(requireCSRF(requireOwner("delete", deleteHandler))).Name("delete")
+	r.Methods("POST").Path("/books/{id:[0-9]+}:restore").
+		Handler(requireCSRF(appHandler(restoreHandler)))
+
+	// Serve images stored on the local disk.
+	if bookshelf.ImageHandler != nil {
+		r.Methods("GET").PathPrefix(bookshelf.LocalImagePath).
+			Handler(bookshelf.ImageHandler)
+	}
+
+	// The JSON REST API is defined in api.go.
+	registerAPIHandlers(r)
 
 	// The following handlers are defined in auth.go and used in the
 	// "Authenticating Users" part of the Getting Started guide.
 	r.Methods("GET").Path("/login").
 		Handler(appHandler(loginHandler))
 	r.Methods("POST").Path("/logout").
-		Handler(appHandler(logoutHandler))
+		Handler(requireCSRF(appHandler(logoutHandler)))
 	r.Methods("GET").Path("/oauth2callback").
 		Handler(appHandler(oauthCallbackHandler))
 
-	// Respond to App Engine and Compute Engine health checks.
-	// Indicate the server is healthy.
-	r.Methods("GET").Path("/_ah/health").HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("ok"))
-		})
+	// Serve the fake OpenID Connect provider, for -oidc-issuer=fake.
+	if bookshelf.FakeOIDC != nil {
+		r.PathPrefix(bookshelf.FakeOIDCPath).Handler(bookshelf.FakeOIDC)
+	}
 
 	// [START request_logging]
 	// Delegate all of the HTTP routing and serving to the gorilla/mux router.
-	// Log all requests using the standard Apache format.
-	http.Handle("/", &ochttp.Handler{Handler: handlers.CombinedLoggingHandler(os.Stderr, r)})
+	// Log all requests using the standard Apache format, and trace and
+	// measure them by route.
+	http.Handle("/", routeHandler(r, handlers.CombinedLoggingHandler(os.Stderr, withActor(r))))
 	// [END request_logging]
 
 	http.Handle("/debug/zpages/", http.StripPrefix("/debug/zpages", zpages.Handler))
+	http.HandleFunc("/debug/cache", cacheStatusHandler)
+	registerHealthHandlers(http.DefaultServeMux)
+}
+
+// booksPageSize is the number of books listed on each page.
+const booksPageSize = 20
+
+// maxBackCursors is the number of cursors of earlier pages carried in the
+// "back" query parameter, which bounds the length of the page URLs.
+const maxBackCursors = 5
+
+// droppedCursors marks a "back" query parameter from which the cursors of
+// the earliest pages have been dropped. It is not a valid cursor.
+const droppedCursors = "."
+
+// bookList is the data rendered by list.html.
+type bookList struct {
+	Books []*bookshelf.Book
+	// PrevURL and NextURL link to the neighbouring pages, if any, and
+	// FirstURL to the first page, if this is not the first or second.
+	FirstURL, PrevURL, NextURL string
+}
+
+// newBookList returns a bookList for a page of books listed at r's path.
+//
+// Cursors only page forward, so the cursors of the pages before the current
+// one are carried in the "back" query parameter to link to the previous page.
+// Only the last maxBackCursors are kept; paging back past them leads to the
+// first page instead.
+func newBookList(r *http.Request, books []*bookshelf.Book, next string) *bookList {
+	cursor := r.FormValue("cursor")
+	var back []string
+	if b := r.FormValue("back"); b != "" {
+		back = strings.Split(b, ",")
+	}
+	dropped := len(back) > 0 && back[0] == droppedCursors
+	if dropped {
+		back = back[1:]
+	}
+
+	pageURL := func(cursor string, back []string, dropped bool) string {
+		v := url.Values{}
+		if cursor != "" {
+			v.Set("cursor", cursor)
+		}
+		if dropped {
+			back = append([]string{droppedCursors}, back...)
+		}
+		if len(back) > 0 {
+			v.Set("back", strings.Join(back, ","))
+		}
+		if len(v) == 0 {
+			return r.URL.Path
+		}
+		return r.URL.Path + "?" + v.Encode()
+	}
 
+	l := &bookList{Books: books}
+	if cursor != "" {
+		switch {
+		case len(back) > 0:
+			l.PrevURL = pageURL(back[len(back)-1], back[:len(back)-1], dropped)
+		case !dropped:
+			l.PrevURL = pageURL("", nil, false)
+		}
+		if l.PrevURL != r.URL.Path {
+			l.FirstURL = r.URL.Path
+		}
+	}
+	if next != "" {
+		if cursor != "" {
+			back = append(back, cursor)
+		}
+		if len(back) > maxBackCursors {
+			back, dropped = back[len(back)-maxBackCursors:], true
+		}
+		l.NextURL = pageURL(next, back, dropped)
+	}
+	return l
 }
 
-// listHandler displays a list with summaries of books in the database.
+// listHandler displays a page with summaries of books in the database.
 func listHandler(w http.ResponseWriter, r *http.Request) *appError {
-	books, err := bookshelf.DB.ListBooks(r.Context())
+	books, next, err := bookshelf.DB.ListBooksPage(r.Context(), booksPageSize, r.FormValue("cursor"))
 	if err != nil {
 		return appErrorf(err, "could not list books: %v", err)
 	}
 	stats.Record(r.Context(), booksPerPage.M(int64(len(books))))
-	return listTmpl.Execute(w, r, books)
+	return listTmpl.Execute(w, r, newBookList(r, books, next))
 }
 
-// listMineHandler displays a list of books created by the currently
+// listMineHandler displays a page of books created by the currently
 // authenticated user.
 func listMineHandler(w http.ResponseWriter, r *http.Request) *appError {
 	user := profileFromSession(r)
@@ -123,12 +300,34 @@ func listMineHandler(w http.ResponseWriter, r *http.Request) *appError {
 		return nil
 	}
 
-	books, err := bookshelf.DB.ListBooksCreatedBy(r.Context(), user.ID)
+	books, next, err := bookshelf.DB.ListBooksCreatedByPage(r.Context(), user.ID, booksPageSize, r.FormValue("cursor"))
 	if err != nil {
 		return appErrorf(err, "could not list books: %v", err)
 	}
+	stats.Record(r.Context(), booksPerPage.M(int64(len(books))))
+	return listTmpl.Execute(w, r, newBookList(r, books, next))
+}
 
-	return listTmpl.Execute(w, r, books)
+// searchHandler displays the books matching the query in the "q" parameter.
+func searchHandler(w http.ResponseWriter, r *http.Request) *appError {
+	query := strings.TrimSpace(r.FormValue("q"))
+	books := make([]*bookshelf.Book, 0)
+	if query != "" {
+		start := time.Now()
+		var err error
+		books, err = bookshelf.DB.SearchBooks(r.Context(), query)
+		if err != nil {
+			return appErrorf(err, "could not search books: %v", err)
+		}
+		stats.Record(r.Context(),
+			searchLatency.M(float64(time.Since(start))/float64(time.Millisecond)),
+			searchResults.M(int64(len(books))))
+	}
+
+	return searchTmpl.Execute(w, r, struct {
+		Query string
+		Books []*bookshelf.Book
+	}{query, books})
 }
 
 // bookFromRequest retrieves a book from the database given a book ID in the
@@ -136,11 +335,11 @@ func listMineHandler(w http.ResponseWriter, r *http.Request) *appError {
 func bookFromRequest(r *http.Request) (*bookshelf.Book, error) {
 	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("bad book id: %v", err)
+		return nil, bookshelf.Errorf(bookshelf.KindInvalidArgument, "bad book id: %v", err)
 	}
 	book, err := bookshelf.DB.GetBook(r.Context(), id)
 	if err != nil {
-		return nil, fmt.Errorf("could not find book: %v", err)
+		return nil, bookshelf.WrapErrorf(err, "could not find book: %v", err)
 	}
 	return book, nil
 }
@@ -155,29 +354,108 @@ func detailHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return detailTmpl.Execute(w, r, book)
 }
 
+// historyHandler displays the changes made to a given book, newest first.
+// The history of deleted books remains available.
+func historyHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, err, "bad book id: %v", err)
+	}
+	records, err := bookshelf.Audit.History(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not get history: %v", err)
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return historyTmpl.Execute(w, r, struct {
+		ID      int64
+		Records []*bookshelf.AuditRecord
+	}{id, records})
+}
+
+// trashedBook is a book in the trash, as rendered by trash.html.
+type trashedBook struct {
+	*bookshelf.Book
+	// PurgeAt is when the book will be permanently deleted.
+	PurgeAt time.Time
+}
+
+// trashHandler displays the books in the trash that the signed-in user may
+// restore: their own, or every book for the admins. Without sign-in, every
+// book in the trash is shown.
+func trashHandler(w http.ResponseWriter, r *http.Request) *appError {
+	userID := ""
+	if bookshelf.OAuthConfig != nil {
+		user := profileFromSession(r)
+		if user == nil {
+			http.Redirect(w, r, "/login?redirect=/books/trash", http.StatusFound)
+			return nil
+		}
+		if !user.user().IsAdmin() {
+			userID = user.ID
+		}
+	}
+
+	books, err := bookshelf.DB.ListTrash(r.Context(), userID)
+	if err != nil {
+		return appErrorf(err, "could not list the trash: %v", err)
+	}
+	trashed := make([]trashedBook, len(books))
+	for i, b := range books {
+		trashed[i] = trashedBook{b, b.TrashedAt.Add(bookshelf.TrashRetention)}
+	}
+	return trashTmpl.Execute(w, r, trashed)
+}
+
+// restoreHandler moves a book out of the trash, if the signed-in user may
+// modify it.
+func restoreHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, err, "bad book id: %v", err)
+	}
+	book, err := bookshelf.DB.GetTrashedBook(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not find book in the trash: %v", err)
+	}
+	if appErr := authorize(r, "restore", book); appErr != nil {
+		return appErr
+	}
+	if err := bookshelf.DB.RestoreBook(r.Context(), id); err != nil {
+		return appErrorf(err, "could not restore book: %v", err)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", id), http.StatusFound)
+	return nil
+}
+
 // addFormHandler displays a form that captures details of a new book to add to
 // the database.
 func addFormHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return editTmpl.Execute(w, r, nil)
 }
 
+// editForm is the data rendered by edit.html when editing a book.
+type editForm struct {
+	*bookshelf.Book
+	// Conflict is set if the user's changes were not saved because the book
+	// was modified while they were editing it.
+	Conflict bool
+}
+
 // editFormHandler displays a form that allows the user to edit the details of
 // a given book.
-func editFormHandler(w http.ResponseWriter, r *http.Request) *appError {
-	book, err := bookFromRequest(r)
-	if err != nil {
-		return appErrorf(err, "%v", err)
-	}
-
-	return editTmpl.Execute(w, r, book)
+func editFormHandler(w http.ResponseWriter, r *http.Request, book *bookshelf.Book) *appError {
+	return editTmpl.Execute(w, r, &editForm{Book: book})
 }
 
 // bookFromForm populates the fields of a Book from form values
-// (see templates/edit.html).
+// (see templates/edit.html). The creator is never taken from the form; it is
+// set by the caller.
 func bookFromForm(r *http.Request) (*bookshelf.Book, error) {
 	imageURL, err := uploadFileFromForm(r)
 	if err != nil {
-		return nil, fmt.Errorf("could not upload file: %v", err)
+		return nil, bookshelf.WrapErrorf(err, "could not upload file: %v", err)
 	}
 	if imageURL == "" {
 		imageURL = r.FormValue("imageURL")
@@ -189,30 +467,28 @@ func bookFromForm(r *http.Request) (*bookshelf.Book, error) {
 		PublishedDate: r.FormValue("publishedDate"),
 		ImageURL:      imageURL,
 		Description:   r.FormValue("description"),
-		CreatedBy:     r.FormValue("createdBy"),
-		CreatedByID:   r.FormValue("createdByID"),
+		ISBN:          strings.TrimSpace(r.FormValue("isbn")),
 	}
+	return book, nil
+}
 
-	// If the form didn't carry the user information for the creator, populate it
-	// from the currently logged in user (or mark as anonymous).
-	if book.CreatedByID == "" {
-		user := profileFromSession(r)
-		if user != nil {
-			// Logged in.
-			book.CreatedBy = user.DisplayName
-			book.CreatedByID = user.ID
-		} else {
-			// Not logged in.
-			book.SetCreatorAnonymous()
-		}
+// setCreator sets the creator of a book to the currently logged in user, or
+// marks it as anonymous.
+func setCreator(r *http.Request, book *bookshelf.Book) {
+	user := profileFromSession(r)
+	if user != nil {
+		// Logged in.
+		book.CreatedBy = user.DisplayName
+		book.CreatedByID = user.ID
+	} else {
+		// Not logged in.
+		book.SetCreatorAnonymous()
 	}
-
-	return book, nil
 }
 
 // uploadFileFromForm uploads a file if it's present in the "image" form field.
 func uploadFileFromForm(r *http.Request) (url string, err error) {
-	f, fh, err := r.FormFile("image")
+	f, _, err := r.FormFile("image")
 	if err == http.ErrMissingFile {
 		return "", nil
 	}
@@ -220,30 +496,20 @@ func uploadFileFromForm(r *http.Request) (url string, err error) {
 		return "", err
 	}
 
-	if bookshelf.StorageBucket == nil {
-		return "", errors.New("storage bucket is missing - check config.go")
+	if bookshelf.Images == nil {
+		return "", errors.New("image storage is not configured - set -storage")
 	}
 
-	// random filename, retaining existing extension.
-	name := uuid.NewV4().String() + path.Ext(fh.Filename)
-
-	ctx := context.Background()
-	w := bookshelf.StorageBucket.Object(name).NewWriter(ctx)
-	w.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
-	w.ContentType = fh.Header.Get("Content-Type")
-
-	// Entries are immutable, be aggressive about caching (1 day).
-	w.CacheControl = "public, max-age=86400"
-
-	if _, err := io.Copy(w, f); err != nil {
-		return "", err
-	}
-	if err := w.Close(); err != nil {
+	// The type is taken from the contents, not the uploader's file name or
+	// Content-Type, and only images are accepted.
+	contentType, ext, image, err := bookshelf.SniffImage(f)
+	if err != nil {
 		return "", err
 	}
+	// random filename, with the extension of the detected type.
+	name := uuid.NewV4().String() + ext
 
-	const publicURL = "https://storage.googleapis.com/%s/%s"
-	return fmt.Sprintf(publicURL, bookshelf.StorageBucketName, name), nil
+	return bookshelf.Images.PutImage(r.Context(), name, contentType, image)
 }
 
 // createHandler adds a book to the database.
@@ -252,44 +518,53 @@ func createHandler(w http.ResponseWriter, r *http.Request) *appError {
 	if err != nil {
 		return appErrorf(err, "could not parse book from form: %v", err)
 	}
+	setCreator(r, book)
 	id, err := bookshelf.DB.AddBook(r.Context(), book)
 	if err != nil {
 		return appErrorf(err, "could not save book: %v", err)
 	}
-	go publishUpdate(id)
+	go publishUpdate(r.Context(), id)
 	http.Redirect(w, r, fmt.Sprintf("/books/%d", id), http.StatusFound)
 	return nil
 }
 
-// updateHandler updates the details of a given book.
-func updateHandler(w http.ResponseWriter, r *http.Request) *appError {
-	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
-	if err != nil {
-		return appErrorf(err, "bad book id: %v", err)
-	}
-
+// updateHandler updates the details of a given book. If the book has been
+// modified since the version the form was filled in for, the changes are not
+// saved and the form is shown again with the current version.
+func updateHandler(w http.ResponseWriter, r *http.Request, old *bookshelf.Book) *appError {
 	book, err := bookFromForm(r)
 	if err != nil {
 		return appErrorf(err, "could not parse book from form: %v", err)
 	}
-	book.ID = id
+	book.ID = old.ID
+	// The creator of a book cannot be changed.
+	book.CreatedBy = old.CreatedBy
+	book.CreatedByID = old.CreatedByID
+	// The form always carries the version it was filled in for; without
+	// it, the update would overwrite any changes made since.
+	if book.Version, err = strconv.ParseInt(r.FormValue("version"), 10, 64); err != nil || book.Version <= 0 {
+		return apiErrorf(http.StatusBadRequest, err, "bad version %q", r.FormValue("version"))
+	}
 
 	err = bookshelf.DB.UpdateBook(r.Context(), book)
+	if _, ok := err.(*bookshelf.ConflictError); ok {
+		current, err := bookshelf.DB.GetBook(r.Context(), book.ID)
+		if err != nil {
+			return appErrorf(err, "could not get book: %v", err)
+		}
+		return editTmpl.ExecuteStatus(w, r, http.StatusConflict, &editForm{Book: current, Conflict: true})
+	}
 	if err != nil {
 		return appErrorf(err, "could not save book: %v", err)
 	}
-	go publishUpdate(book.ID)
+	go publishUpdate(r.Context(), book.ID)
 	http.Redirect(w, r, fmt.Sprintf("/books/%d", book.ID), http.StatusFound)
 	return nil
 }
 
-// deleteHandler deletes a given book.
-func deleteHandler(w http.ResponseWriter, r *http.Request) *appError {
-	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
-	if err != nil {
-		return appErrorf(err, "bad book id: %v", err)
-	}
-	err = bookshelf.DB.DeleteBook(r.Context(), id)
+// deleteHandler moves a given book to the trash.
+func deleteHandler(w http.ResponseWriter, r *http.Request, book *bookshelf.Book) *appError {
+	err := bookshelf.DB.DeleteBook(r.Context(), book.ID)
 	if err != nil {
 		return appErrorf(err, "could not delete book: %v", err)
 	}
@@ -297,22 +572,20 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
-// publishUpdate notifies Pub/Sub subscribers that the book identified with
-// the given ID has been added/modified.
-func publishUpdate(bookID int64) {
-	if bookshelf.PubsubClient == nil {
+// publishUpdate notifies the worker that the book identified with the given
+// ID has been added/modified. The update continues the trace of reqCtx.
+func publishUpdate(reqCtx context.Context, bookID int64) {
+	if bookshelf.Updates == nil {
 		return
 	}
 
-	ctx := context.Background()
+	// The request's context is cancelled when its handler returns, so only
+	// keep its span and tags.
+	ctx := tag.NewContext(context.Background(), tag.FromContext(reqCtx))
+	ctx = trace.NewContext(ctx, trace.FromContext(reqCtx))
 
-	b, err := json.Marshal(bookID)
-	if err != nil {
-		return
-	}
-	topic := bookshelf.PubsubClient.Topic(bookshelf.PubsubTopicID)
-	_, err = topic.Publish(ctx, &pubsub.Message{Data: b}).Get(ctx)
-	log.Printf("Published update to Pub/Sub for Book ID %d: %v", bookID, err)
+	err := bookshelf.Updates.Publish(ctx, bookID)
+	log.Printf("Published update for Book ID %d: %v", bookID, err)
 }
 
 // http://blog.golang.org/error-handling-and-go
@@ -322,21 +595,75 @@ type appError struct {
 	Error   error
 	Message string
 	Code    int
+	Kind    bookshelf.ErrorKind
 }
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if e := fn(w, r); e != nil { // e is *appError, not os.Error.
 		log.Printf("Handler error: status code: %d, message: %s, underlying err: %#v",
 			e.Code, e.Message, e.Error)
+		e.record(r)
 
 		http.Error(w, e.Message, e.Code)
 	}
 }
 
+// record sets the status of the request's span from e, and counts e by its
+// kind.
+func (e *appError) record(r *http.Request) {
+	span := trace.FromContext(r.Context())
+	span.AddAttributes(trace.StringAttribute("error_class", e.Kind.String()))
+	span.SetStatus(trace.Status{Code: e.Kind.TraceCode(), Message: e.Message})
+
+	ctx, _ := tag.New(r.Context(), tag.Upsert(keyErrorClass, e.Kind.String()))
+	stats.Record(ctx, handlerErrors.M(1))
+}
+
+// appErrorf returns an appError with the HTTP status code of the kind of
+// err, e.g. 404 for a book that does not exist.
 func appErrorf(err error, format string, v ...interface{}) *appError {
+	kind := bookshelf.KindOf(err)
 	return &appError{
 		Error:   err,
 		Message: fmt.Sprintf(format, v...),
-		Code:    500,
+		Code:    statusOfKind(kind),
+		Kind:    kind,
+	}
+}
+
+// statusOfKind returns the HTTP status code of errors of the given kind.
+func statusOfKind(kind bookshelf.ErrorKind) int {
+	switch kind {
+	case bookshelf.KindNotFound:
+		return http.StatusNotFound
+	case bookshelf.KindInvalidArgument:
+		return http.StatusBadRequest
+	case bookshelf.KindUnauthorized:
+		return http.StatusForbidden
+	case bookshelf.KindConflict:
+		return http.StatusConflict
+	case bookshelf.KindUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// kindOfStatus returns the kind of errors responded to with the given HTTP
+// status code.
+func kindOfStatus(code int) bookshelf.ErrorKind {
+	switch code {
+	case http.StatusNotFound:
+		return bookshelf.KindNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return bookshelf.KindUnauthorized
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return bookshelf.KindConflict
+	case http.StatusServiceUnavailable:
+		return bookshelf.KindUnavailable
+	}
+	if code >= 400 && code < 500 {
+		return bookshelf.KindInvalidArgument
 	}
+	return bookshelf.KindUnknown
 }
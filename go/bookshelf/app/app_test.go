@@ -0,0 +1,102 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+func TestMain(m *testing.M) {
+	cfg := &bookshelf.Config{DB: "memory", Storage: "none", Queue: "none", CacheSize: "0"}
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+	registerHandlers()
+	os.Exit(m.Run())
+}
+
+// do serves a request with the handlers of the app, and returns the
+// response.
+func do(method, target, contentType string, body io.Reader, header http.Header) *http.Response {
+	r := httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// TestBookListPaging pages forward through many pages, checking that the
+// page URLs stay short and link back to the previous page while they can.
+func TestBookListPaging(t *testing.T) {
+	cursorOf := func(page int) string {
+		return base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("a cursor of a realistic length for page %d", page)))
+	}
+
+	// cursorIn returns the cursor in a page URL, or "" for the first page.
+	cursorIn := func(u string) string {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Query().Get("cursor")
+	}
+
+	var cursors []string // of each page, from the first.
+	u := "/books"
+	for page := 0; page < 100; page++ {
+		cursors = append(cursors, cursorIn(u))
+		l := newBookList(httptest.NewRequest("GET", u, nil), nil, cursorOf(page+1))
+
+		if max := 2 * (maxBackCursors + 1) * len(cursorOf(page+1)); len(l.NextURL) > max {
+			t.Fatalf("page %d: next page URL is %d bytes long, want at most %d", page, len(l.NextURL), max)
+		}
+		switch {
+		case page == 0:
+			if l.PrevURL != "" || l.FirstURL != "" {
+				t.Errorf("first page: got previous page %q and first page %q, want neither", l.PrevURL, l.FirstURL)
+			}
+		case page <= maxBackCursors+1:
+			if l.PrevURL == "" || cursorIn(l.PrevURL) != cursors[page-1] {
+				t.Fatalf("page %d: got previous page %q, want the page of cursor %q", page, l.PrevURL, cursors[page-1])
+			}
+		default:
+			// The first page is always linked.
+			if l.FirstURL != "/books" {
+				t.Errorf("page %d: got first page %q, want /books", page, l.FirstURL)
+			}
+		}
+		u = l.NextURL
+	}
+
+	// The cursors kept link back through the last pages, and then there is
+	// only the link to the first page.
+	cursors = append(cursors, cursorIn(u))
+	page := len(cursors) - 1
+	for last := page; page > last-maxBackCursors; page-- {
+		l := newBookList(httptest.NewRequest("GET", u, nil), nil, cursorOf(page+1))
+		if l.PrevURL == "" || cursorIn(l.PrevURL) != cursors[page-1] {
+			t.Fatalf("page %d: got previous page %q, want the page of cursor %q", page, l.PrevURL, cursors[page-1])
+		}
+		u = l.PrevURL
+	}
+	if l := newBookList(httptest.NewRequest("GET", u, nil), nil, cursorOf(page+1)); l.PrevURL != "" || l.FirstURL != "/books" {
+		t.Errorf("page %d: got previous page %q and first page %q, want only the first page", page, l.PrevURL, l.FirstURL)
+	}
+}
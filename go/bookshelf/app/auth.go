@@ -10,9 +10,6 @@ import (
 	"net/http"
 	"net/url"
 
-	plus "google.golang.org/api/plus/v1"
-
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 
 	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
@@ -24,8 +21,8 @@ const (
 	// The following keys are used for the default session. For example:
 	//  session, _ := bookshelf.SessionStore.New(r, defaultSessionID)
 	//  session.Values[oauthTokenSessionKey]
-	googleProfileSessionKey = "google_profile"
-	oauthTokenSessionKey    = "oauth_token"
+	profileSessionKey    = "profile"
+	oauthTokenSessionKey = "oauth_token"
 
 	// This key is used in the OAuth flow session to store the URL to redirect the
 	// user to after the OAuth flow is complete.
@@ -87,8 +84,9 @@ func validateRedirectURL(path string) (string, error) {
 	return path, nil
 }
 
-// oauthCallbackHandler completes the OAuth flow, retreives the user's profile
-// information and stores it in a session.
+// oauthCallbackHandler completes the OAuth flow, verifies the ID token
+// returned with the access token and stores the user's profile from its
+// claims in a session.
 func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) *appError {
 	oauthFlowSession, err := bookshelf.SessionStore.Get(r, r.FormValue("state"))
 	if err != nil {
@@ -102,7 +100,7 @@ func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) *appError {
 	}
 
 	code := r.FormValue("code")
-	tok, err := bookshelf.OAuthConfig.Exchange(context.Background(), code)
+	tok, err := bookshelf.OAuthConfig.Exchange(r.Context(), code)
 	if err != nil {
 		return appErrorf(err, "could not get auth token: %v", err)
 	}
@@ -112,15 +110,13 @@ func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) *appError {
 		return appErrorf(err, "could not get default session: %v", err)
 	}
 
-	ctx := context.Background()
-	profile, err := fetchProfile(ctx, tok)
+	profile, err := verifyProfile(r, tok)
 	if err != nil {
-		return appErrorf(err, "could not fetch Google profile: %v", err)
+		return appErrorf(err, "could not verify ID token: %v", err)
 	}
 
 	session.Values[oauthTokenSessionKey] = tok
-	// Strip the profile to only the fields we need. Otherwise the struct is too big.
-	session.Values[googleProfileSessionKey] = stripProfile(profile)
+	session.Values[profileSessionKey] = profile
 	if err := session.Save(r, w); err != nil {
 		return appErrorf(err, "could not save session: %v", err)
 	}
@@ -129,15 +125,29 @@ func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
-// fetchProfile retrieves the Google+ profile of the user associated with the
-// provided OAuth token.
-func fetchProfile(ctx context.Context, tok *oauth2.Token) (*plus.Person, error) {
-	client := oauth2.NewClient(ctx, bookshelf.OAuthConfig.TokenSource(ctx, tok))
-	plusService, err := plus.New(client)
+// verifyProfile verifies the ID token returned with tok, and returns the
+// profile of the user it identifies.
+func verifyProfile(r *http.Request, tok *oauth2.Token) (*Profile, error) {
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := bookshelf.OIDCVerifier.Verify(r.Context(), rawIDToken)
 	if err != nil {
 		return nil, err
 	}
-	return plusService.People.Get("me").Do()
+	var claims struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	p := profileFromClaims(idToken.Subject, claims.Name, claims.Email, claims.Picture)
+	p.EmailVerified = claims.EmailVerified
+	return p, nil
 }
 
 // logoutHandler clears the default session.
@@ -158,7 +168,7 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
-// profileFromSession retreives the Google+ profile from the default session.
+// profileFromSession retreives the user's profile from the default session.
 // Returns nil if the profile cannot be retreived (e.g. user is logged out).
 func profileFromSession(r *http.Request) *Profile {
 	session, err := bookshelf.SessionStore.Get(r, defaultSessionID)
@@ -169,22 +179,89 @@ func profileFromSession(r *http.Request) *Profile {
 	if !ok || !tok.Valid() {
 		return nil
 	}
-	profile, ok := session.Values[googleProfileSessionKey].(*Profile)
+	profile, ok := session.Values[profileSessionKey].(*Profile)
 	if !ok {
 		return nil
 	}
 	return profile
 }
 
+// Profile is the signed-in user, as identified by the OpenID Connect
+// provider.
 type Profile struct {
-	ID, DisplayName, ImageURL string
+	ID, DisplayName, ImageURL, Email string
+	// EmailVerified is whether the provider verified Email. Only verified
+	// addresses identify admins.
+	EmailVerified bool
 }
 
-// stripProfile returns a subset of a plus.Person.
-func stripProfile(p *plus.Person) *Profile {
+// profileFromClaims returns the profile for the ID token claims of a user.
+// Providers need not return a name, so the email address stands in for it.
+func profileFromClaims(subject, name, email, picture string) *Profile {
+	if name == "" {
+		name = email
+	}
 	return &Profile{
-		ID:          p.Id,
-		DisplayName: p.DisplayName,
-		ImageURL:    p.Image.Url,
+		ID:          subject,
+		DisplayName: name,
+		ImageURL:    picture,
+		Email:       email,
+	}
+}
+
+// user returns the bookshelf user p identifies, or nil if p is nil.
+func (p *Profile) user() *bookshelf.User {
+	if p == nil {
+		return nil
+	}
+	return &bookshelf.User{ID: p.ID, Email: p.Email, EmailVerified: p.EmailVerified}
+}
+
+// authorize returns a 403 error if the signed-in user may not perform action
+// on book. Without sign-in there are no users to tell apart, so anyone may
+// modify any book.
+func authorize(r *http.Request, action string, book *bookshelf.Book) *appError {
+	if bookshelf.OAuthConfig == nil {
+		return nil
+	}
+	user := profileFromSession(r)
+	err := bookshelf.Authorize(r.Context(), action, user.user(), book)
+	if err == bookshelf.ErrNotAuthorized {
+		if user == nil {
+			return apiErrorf(http.StatusForbidden, err, "log in to %s book %d", action, book.ID)
+		}
+		return apiErrorf(http.StatusForbidden, err, "only the creator of book %d can %s it", book.ID, action)
+	}
+	if err != nil {
+		return appErrorf(err, "could not authorize: %v", err)
 	}
+	return nil
+}
+
+// requireOwner returns a handler that calls fn with the book whose ID is in
+// the URL's path, if the signed-in user is authorized to perform action on
+// it.
+func requireOwner(action string, fn func(http.ResponseWriter, *http.Request, *bookshelf.Book) *appError) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		book, err := bookFromRequest(r)
+		if err != nil {
+			return appErrorf(err, "%v", err)
+		}
+		if appErr := authorize(r, action, book); appErr != nil {
+			return appErr
+		}
+		return fn(w, r, book)
+	}
+}
+
+// withActor returns a handler that attributes the changes to books made by h
+// to the signed-in user, or to an anonymous actor, in the audit log.
+func withActor(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := bookshelf.Actor{ID: "anonymous", Name: "Anonymous"}
+		if p := profileFromSession(r); p != nil {
+			actor = bookshelf.Actor{ID: p.ID, Name: p.DisplayName}
+		}
+		h.ServeHTTP(w, r.WithContext(bookshelf.WithActor(r.Context(), actor)))
+	})
 }
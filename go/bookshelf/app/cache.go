@@ -0,0 +1,60 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+// maxCacheStatusEntries is the number of cache entries listed by the cache
+// status page.
+const maxCacheStatusEntries = 100
+
+var cacheStatusTmpl = template.Must(template.New("cache").Funcs(template.FuncMap{
+	"percent": func(f float64) float64 { return 100 * f },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Bookshelf cache</title></head>
+<body>
+<h1>Bookshelf cache</h1>
+{{if .}}
+<p>{{.Size}} of {{.Capacity}} entries, cached for {{.TTL}}.</p>
+<table>
+<tr><td>Hits</td><td>{{.Hits}}</td></tr>
+<tr><td>Misses</td><td>{{.Misses}}</td></tr>
+<tr><td>Hit ratio</td><td>{{printf "%.1f" (percent .HitRatio)}}%</td></tr>
+<tr><td>Mean hit latency</td><td>{{.MeanHitLatency}}</td></tr>
+<tr><td>Mean miss latency</td><td>{{.MeanMissLatency}}</td></tr>
+{{range $reason, $n := .Evictions}}<tr><td>Evicted ({{$reason}})</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<h2>Entries</h2>
+{{if lt (len .Entries) .Size}}<p>The {{len .Entries}} most recently used of {{.Size}} entries.</p>{{end}}
+<table>
+<tr><th>Key</th><th>Hits</th><th>Age</th><th>Expires in</th></tr>
+{{range .Entries}}<tr><td>{{.Key}}</td><td>{{.Hits}}</td><td>{{.Age}}</td><td>{{.ExpiresIn}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>Caching is disabled.</p>
+{{end}}
+<p><a href="/debug/zpages/tracez">Traces</a> &middot; <a href="/debug/zpages/rpcz">RPCs</a></p>
+</body>
+</html>
+`))
+
+// cacheStatusHandler renders the state of bookshelf.Cache.
+func cacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var status *bookshelf.CacheStatus
+	if bookshelf.Cache != nil {
+		status = bookshelf.Cache.Status(maxCacheStatusEntries)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := cacheStatusTmpl.Execute(w, status); err != nil {
+		log.Printf("could not render cache status: %v", err)
+	}
+}
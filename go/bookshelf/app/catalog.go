@@ -0,0 +1,133 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+const (
+	// maxImportSize is the largest catalog that can be uploaded.
+	maxImportSize = 32 << 20
+	// importPreviewRows is the number of valid books shown after an import.
+	importPreviewRows = 50
+)
+
+// exportHandler streams every book as a CSV or JSON attachment, as selected
+// by the "format" query parameter (default "csv").
+func exportHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format := r.FormValue("format")
+	var contentType string
+	switch format {
+	case "", bookshelf.FormatCSV:
+		format, contentType = bookshelf.FormatCSV, "text/csv; charset=utf-8"
+	case bookshelf.FormatJSON:
+		contentType = "application/json"
+	default:
+		return apiErrorf(http.StatusBadRequest, nil, "unknown format %q: want csv or json", format)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=books.%s", format))
+	n, err := bookshelf.ExportBooks(r.Context(), bookshelf.DB, w, format)
+	if err != nil && n == 0 {
+		w.Header().Del("Content-Disposition")
+		return appErrorf(err, "could not export books: %v", err)
+	}
+	if err != nil {
+		// The response has started, so the client sees a truncated
+		// catalog.
+		log.Printf("Export failed after %d books: %v", n, err)
+	}
+	return nil
+}
+
+// importPage is the data rendered by import.html.
+type importPage struct {
+	// Report is the outcome of the upload, if there was one.
+	Report *bookshelf.ImportReport
+	// Preview are the first valid books of the report.
+	Preview []*bookshelf.Book
+	// Error is why the upload could not be imported at all.
+	Error string
+}
+
+// importFormHandler displays a form to upload a catalog of books.
+func importFormHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return importTmpl.Execute(w, r, &importPage{})
+}
+
+// importHandler adds the books of an uploaded catalog, or previews the
+// import if the "dryRun" form field is set, and reports the rows that were
+// skipped. The catalog's format is given by the "format" form field, or the
+// extension of the uploaded file.
+func importHandler(w http.ResponseWriter, r *http.Request) *appError {
+	f, fh, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		return importTmpl.ExecuteStatus(w, r, http.StatusBadRequest, &importPage{Error: "Choose a CSV or JSON file to import."})
+	}
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, err, "could not read upload: %v", err)
+	}
+	defer f.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(path.Ext(fh.Filename), "."))
+	}
+	opts := bookshelf.ImportOptions{
+		DryRun:     r.FormValue("dryRun") != "",
+		SetCreator: importCreator(r),
+		Added: func(ctx context.Context, id int64) {
+			go publishUpdate(ctx, id)
+		},
+	}
+
+	report, err := bookshelf.ImportBooks(r.Context(), bookshelf.DB, f, format, opts)
+	page := &importPage{Report: report}
+	if err != nil {
+		log.Printf("Import of %s failed after %d rows: %v", fh.Filename, report.Rows, err)
+		page.Error = err.Error()
+	}
+	page.Preview = report.Books
+	if len(page.Preview) > importPreviewRows {
+		page.Preview = page.Preview[:importPreviewRows]
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadRequest
+	}
+	return importTmpl.ExecuteStatus(w, r, status, page)
+}
+
+// importCreator returns a function that attributes imported books to the
+// signed-in user. Admins may import books on behalf of their creators, so
+// the creator in the catalog is kept for them, if there is one.
+func importCreator(r *http.Request) func(*bookshelf.Book) {
+	admin := profileFromSession(r).user().IsAdmin()
+	return func(b *bookshelf.Book) {
+		if admin && b.CreatedByID != "" {
+			return
+		}
+		setCreator(r, b)
+	}
+}
+
+// limitBody returns a handler that fails requests to h whose body is larger
+// than n bytes.
+func limitBody(n int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		h.ServeHTTP(w, r)
+	})
+}
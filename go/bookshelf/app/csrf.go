@@ -0,0 +1,112 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+const (
+	// csrfSessionID is the session holding the CSRF token of a visitor. It
+	// is separate from the default session, so that visitors who are not
+	// logged in have a token too, and logging out does not invalidate
+	// their open forms.
+	csrfSessionID       = "csrf"
+	csrfTokenSessionKey = "token"
+
+	// csrfFormField is the form field carrying the CSRF token.
+	csrfFormField = "csrf_token"
+)
+
+var (
+	csrfRejected = stats.Int64("csrf_rejected", "number of form posts rejected for a missing or wrong CSRF token", stats.UnitNone)
+
+	keyCSRFReason, _ = tag.NewKey("csrf_reason")
+)
+
+// csrfToken returns the CSRF token of r's visitor, creating and saving it in
+// the CSRF session if they don't have one yet. It must be called before the
+// response body is written.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := bookshelf.SessionStore.Get(r, csrfSessionID)
+	if err != nil {
+		// The cookie could not be decoded, e.g. because the session secret
+		// changed; start a new session.
+		session, err = bookshelf.SessionStore.New(r, csrfSessionID)
+		if session == nil {
+			return "", err
+		}
+	}
+	if tok, ok := session.Values[csrfTokenSessionKey].(string); ok && tok != "" {
+		return tok, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfTokenSessionKey] = tok
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return tok, nil
+}
+
+// csrfField returns a hidden form input carrying tok.
+func csrfField(tok string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` +
+		template.HTMLEscapeString(tok) + `">`)
+}
+
+// requireCSRF returns a handler that rejects requests to h with a 403 unless
+// their csrf_token form field matches the token of the visitor's session.
+// Tokens are added to forms with {{csrfField}}; see appTemplate.Execute.
+func requireCSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := checkCSRF(r); reason != "" {
+			log.Printf("Rejected %s %s: CSRF token %s", r.Method, r.URL.Path, reason)
+			trace.FromContext(r.Context()).Annotate([]trace.Attribute{
+				trace.StringAttribute("reason", reason),
+			}, "CSRF check failed")
+			ctx, _ := tag.New(r.Context(), tag.Upsert(keyCSRFReason, reason))
+			stats.Record(ctx, csrfRejected.M(1))
+			http.Error(w, "invalid or missing CSRF token; reload the page and try again", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkCSRF returns why r's CSRF token is not valid, or "" if it is.
+func checkCSRF(r *http.Request) string {
+	got := r.FormValue(csrfFormField)
+	if got == "" {
+		return "missing"
+	}
+	session, err := bookshelf.SessionStore.Get(r, csrfSessionID)
+	if err != nil {
+		return "no_session"
+	}
+	want, ok := session.Values[csrfTokenSessionKey].(string)
+	if !ok || want == "" {
+		return "no_session"
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return "mismatch"
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// csrfFieldRE matches the field added to forms by csrfField.
+var csrfFieldRE = regexp.MustCompile(`name="` + csrfFormField + `" value="([^"]+)"`)
+
+func TestRequireCSRF(t *testing.T) {
+	v := &view.View{
+		Name:        "test/csrf_rejected",
+		Measure:     csrfRejected,
+		TagKeys:     []tag.Key{keyCSRFReason},
+		Aggregation: view.Count(),
+	}
+	if err := view.Register(v); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(v)
+
+	// The form to add a book carries the visitor's token, which is saved in
+	// the session cookie.
+	resp := do("GET", "/books/add", "", nil, nil)
+	body, _ := ioutil.ReadAll(resp.Body)
+	m := csrfFieldRE.FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("the form has no CSRF token:\n%s", body)
+	}
+	tok := string(m[1])
+	var cookies []string
+	for _, c := range resp.Cookies() {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	cookie := http.Header{"Cookie": {strings.Join(cookies, "; ")}}
+
+	// post posts form as the add form does.
+	post := func(form url.Values, header http.Header) *http.Response {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k := range form {
+			mw.WriteField(k, form.Get(k))
+		}
+		mw.Close()
+		return do("POST", "/books", mw.FormDataContentType(), &body, header)
+	}
+	for _, tc := range []struct {
+		reason string
+		form   url.Values
+		header http.Header
+	}{
+		{"missing", url.Values{"title": {"Title"}}, cookie},
+		{"no_session", url.Values{"title": {"Title"}, csrfFormField: {tok}}, nil},
+		{"mismatch", url.Values{"title": {"Title"}, csrfFormField: {tok + "x"}}, cookie},
+	} {
+		if resp := post(tc.form, tc.header); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s token: got status %d, want %d", tc.reason, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	if resp := post(url.Values{"title": {"Title"}, csrfFormField: {tok}}, cookie); resp.StatusCode != http.StatusFound {
+		t.Errorf("valid token: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	// Each rejection is counted by its reason.
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, row := range rows {
+		got[row.Tags[0].Value] = row.Data.(*view.CountData).Value
+	}
+	for _, reason := range []string{"missing", "no_session", "mismatch"} {
+		if got[reason] != 1 {
+			t.Errorf("got %d rejections for a %s token, want 1", got[reason], reason)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("got rejections %v, want one of each reason", got)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+const (
+	// probeTimeout bounds the probe of each dependency by the health
+	// checks.
+	probeTimeout = 2 * time.Second
+
+	// readyCacheTTL is how long the readiness check reuses the outcome of
+	// probing the dependencies, so that frequent or concurrent checks by
+	// several load balancers do not each probe them.
+	readyCacheTTL = 2 * time.Second
+
+	// unreadyDelay is how long the app keeps serving after SIGTERM with
+	// /readyz failing, for load balancers to stop sending it requests.
+	unreadyDelay = 5 * time.Second
+
+	// drainTimeout is how long the app waits for in-flight requests to
+	// finish once it stops accepting new ones.
+	drainTimeout = 20 * time.Second
+)
+
+// draining is set to 1 once the app is shutting down.
+var draining int32
+
+// lastHealth is the outcome of the last probe of the dependencies by the
+// readiness check.
+var lastHealth struct {
+	sync.Mutex
+	h  *bookshelf.Health
+	at time.Time
+}
+
+// healthResponse is the response body of the health checks.
+type healthResponse struct {
+	*bookshelf.Health
+	Draining bool `json:"draining,omitempty"`
+}
+
+// registerHealthHandlers adds the health checks to mux. They are not logged
+// or traced like the app's requests, since they are made every few seconds.
+func registerHealthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	// Respond to App Engine and Compute Engine health checks.
+	mux.HandleFunc("/_ah/health", healthzHandler)
+}
+
+// healthzHandler is the liveness check. It succeeds as long as the app is
+// serving, without probing the dependencies: restarting the app would not
+// fix them, and the check must stay cheap.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, nil)
+}
+
+// readyzHandler is the readiness check. It fails while a dependency is
+// unavailable, or the app is shutting down.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	h := checkHealth(r.Context())
+	code := http.StatusOK
+	if !h.OK || atomic.LoadInt32(&draining) != 0 {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, h)
+}
+
+// checkHealth probes the dependencies, unless they were probed less than
+// readyCacheTTL ago. Concurrent checks wait for a single probe.
+func checkHealth(ctx context.Context) *bookshelf.Health {
+	lastHealth.Lock()
+	defer lastHealth.Unlock()
+	if lastHealth.h != nil && time.Since(lastHealth.at) < readyCacheTTL {
+		return lastHealth.h
+	}
+	h := bookshelf.CheckHealth(ctx, probeTimeout)
+	// A probe cut short by the check's request going away says nothing
+	// about the dependencies.
+	if ctx.Err() == nil {
+		lastHealth.h, lastHealth.at = h, time.Now()
+	}
+	return h
+}
+
+// writeHealth responds with the given status code and h, which is nil for
+// the liveness check.
+func writeHealth(w http.ResponseWriter, code int, h *bookshelf.Health) {
+	j, err := json.Marshal(&healthResponse{Health: h, Draining: atomic.LoadInt32(&draining) != 0})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(j)
+}
+
+// serve serves http.DefaultServeMux on $PORT (default 8080) until SIGTERM or
+// SIGINT. On SIGTERM, /readyz fails for unreadyDelay before the server stops
+// accepting requests; then the in-flight requests have drainTimeout to
+// finish. stop is called once the server is down.
+func serve(stop func()) {
+	port := "8080"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+	srv := &http.Server{Addr: ":" + port}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		s := <-sig
+
+		atomic.StoreInt32(&draining, 1)
+		if s == syscall.SIGTERM {
+			log.Printf("Received %v; failing readiness checks for %v.", s, unreadyDelay)
+			time.Sleep(unreadyDelay)
+		}
+		log.Printf("Draining in-flight requests.")
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Could not drain in-flight requests: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on port %s", port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	stop()
+	log.Printf("Shut down.")
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+// countingDB is a BookDatabase counting the pages of books listed, which is
+// how the database is probed.
+type countingDB struct {
+	bookshelf.BookDatabase
+	lists int32
+}
+
+func (db *countingDB) ListBooksPage(ctx context.Context, size int, cursor string) ([]*bookshelf.Book, string, error) {
+	atomic.AddInt32(&db.lists, 1)
+	return db.BookDatabase.ListBooksPage(ctx, size, cursor)
+}
+
+func TestHealthChecks(t *testing.T) {
+	db := &countingDB{BookDatabase: bookshelf.DB}
+	bookshelf.DB = db
+	defer func() { bookshelf.DB = db.BookDatabase }()
+	expireHealth := func() {
+		lastHealth.Lock()
+		lastHealth.at = time.Time{}
+		lastHealth.Unlock()
+	}
+	expireHealth()
+
+	// The liveness check does not probe the database.
+	for _, path := range []string{"/healthz", "/_ah/health", "/healthz"} {
+		if resp := do("GET", path, "", nil, nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+	if n := atomic.LoadInt32(&db.lists); n != 0 {
+		t.Errorf("liveness checks probed the database %d times, want 0", n)
+	}
+
+	// The readiness check probes it once per readyCacheTTL.
+	for i := 0; i < 3; i++ {
+		if resp := do("GET", "/readyz", "", nil, nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("/readyz: got status %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+	if n := atomic.LoadInt32(&db.lists); n != 1 {
+		t.Errorf("readiness checks probed the database %d times, want 1", n)
+	}
+	expireHealth()
+	do("GET", "/readyz", "", nil, nil)
+	if n := atomic.LoadInt32(&db.lists); n != 2 {
+		t.Errorf("readiness check after the cached outcome expired probed the database %d times in all, want 2", n)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// unmatchedRoute is the route of requests that match no route of the router,
+// so that they are counted without adding a tag value per URL.
+const unmatchedRoute = "unmatched"
+
+// keyRoute tags the server measurements of ochttp with the path template of
+// the route that matched the request, e.g. "/books/{id:[0-9]+}".
+var keyRoute, _ = tag.NewKey("http_route")
+
+var (
+	// serverLatencyByRouteView is the latency of requests, by route and
+	// method.
+	serverLatencyByRouteView = &view.View{
+		Name:        "bookshelf/http/server/latency_by_route",
+		Description: "Latency distribution of HTTP requests, by route and method",
+		Measure:     ochttp.ServerLatency,
+		TagKeys:     []tag.Key{keyRoute, ochttp.Method},
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+
+	// serverResponsesByRouteView counts the responses by route and status
+	// code, from which the error rate of each route is derived.
+	serverResponsesByRouteView = &view.View{
+		Name:        "bookshelf/http/server/response_count_by_route",
+		Description: "Count of HTTP responses, by route and status code",
+		Measure:     ochttp.ServerLatency,
+		TagKeys:     []tag.Key{keyRoute, ochttp.StatusCode},
+		Aggregation: view.Count(),
+	}
+)
+
+// requestURLKey is the context key of the URL of a request whose path has
+// been replaced by its route.
+type requestURLKey struct{}
+
+// routeHandler traces and measures the requests to h with ochttp, by the
+// route of router that matches them rather than their URL path: the server
+// span is named after the route's path template, and the measurements are
+// tagged with it in keyRoute. h is usually router itself, possibly wrapped
+// in other middleware.
+func routeHandler(router *mux.Router, h http.Handler) http.Handler {
+	// ochttp.Handler names the span and sets the http.path tag after the
+	// URL path, so it is given a request whose path is the route. The
+	// original URL is restored before h is called.
+	traced := &ochttp.Handler{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := r.Context().Value(requestURLKey{}).(*url.URL)
+		trace.FromContext(r.Context()).AddAttributes(trace.StringAttribute("http.url", u.String()))
+		r.URL = u
+		h.ServeHTTP(w, r)
+	})}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(router, r)
+		ctx, _ := tag.New(r.Context(), tag.Upsert(keyRoute, route))
+		ctx = context.WithValue(ctx, requestURLKey{}, r.URL)
+
+		u := *r.URL
+		u.Path, u.RawPath = route, ""
+		r = r.WithContext(ctx)
+		r.URL = &u
+		traced.ServeHTTP(w, r)
+	})
+}
+
+// routeTemplate returns the path template of the route of router that
+// matches r, or unmatchedRoute.
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+		return unmatchedRoute
+	}
+	tpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return unmatchedRoute
+	}
+	return tpl
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// spanRecorder is a trace.Exporter keeping the spans exported to it.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *spanRecorder) ExportSpan(s *trace.SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+func TestRouteHandler(t *testing.T) {
+	spans := &spanRecorder{}
+	trace.RegisterExporter(spans)
+	defer trace.UnregisterExporter(spans)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+
+	v := &view.View{
+		Name:        "test/responses_by_route",
+		Measure:     serverResponsesByRouteView.Measure,
+		TagKeys:     serverResponsesByRouteView.TagKeys,
+		Aggregation: view.Count(),
+	}
+	if err := view.Register(v); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(v)
+
+	// The handler sees the original URL, tagged with the route.
+	var gotPath, gotRoute string
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/books/{id:[0-9]+}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotRoute, _ = tag.FromContext(r.Context()).Value(keyRoute)
+	})
+	h := routeHandler(router, router)
+
+	for _, tc := range []struct {
+		method, path string
+		route        string
+		code         int
+	}{
+		{"GET", "/books/42", "/books/{id:[0-9]+}", http.StatusOK},
+		{"GET", "/books/43", "/books/{id:[0-9]+}", http.StatusOK},
+		{"GET", "/books/42/nowhere", unmatchedRoute, http.StatusNotFound},
+		{"POST", "/books/42", unmatchedRoute, http.StatusMethodNotAllowed},
+	} {
+		gotPath, gotRoute = "", ""
+		spans.spans = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+		if w.Code != tc.code {
+			t.Errorf("%s %s: got status %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+		if tc.code == http.StatusOK && (gotPath != tc.path || gotRoute != tc.route) {
+			t.Errorf("%s %s: handler got path %q and route %q, want %q and %q", tc.method, tc.path, gotPath, gotRoute, tc.path, tc.route)
+		}
+		if len(spans.spans) != 1 {
+			t.Errorf("%s %s: got %d spans, want 1", tc.method, tc.path, len(spans.spans))
+			continue
+		}
+		s := spans.spans[0]
+		if s.Name != tc.route || s.Attributes["http.url"] != tc.path {
+			t.Errorf("%s %s: got span %q with http.url %v, want %q with %q", tc.method, tc.path, s.Name, s.Attributes["http.url"], tc.route, tc.path)
+		}
+	}
+
+	// The responses are counted by route, rather than by path.
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, row := range rows {
+		for _, tg := range row.Tags {
+			if tg.Key == keyRoute {
+				got[tg.Value] += row.Data.(*view.CountData).Value
+			}
+		}
+	}
+	want := map[string]int64{"/books/{id:[0-9]+}": 2, unmatchedRoute: 2}
+	if len(got) != len(want) || got["/books/{id:[0-9]+}"] != 2 || got[unmatchedRoute] != 2 {
+		t.Errorf("got responses by route %v, want %v", got, want)
+	}
+}
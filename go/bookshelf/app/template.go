@@ -16,7 +16,11 @@ import (
 
 // parseTemplate applies a given file to the body of the base template.
 func parseTemplate(filename string) *appTemplate {
-	tmpl := template.Must(template.ParseFiles("templates/base.html"))
+	// csrfField is replaced with one returning the request's token by
+	// appTemplate.Execute.
+	tmpl := template.Must(template.New("base.html").Funcs(template.FuncMap{
+		"csrfField": func() template.HTML { return "" },
+	}).ParseFiles("templates/base.html"))
 
 	// Put the named file into a template called "body"
 	path := filepath.Join("templates", filename)
@@ -35,8 +39,15 @@ type appTemplate struct {
 }
 
 // Execute writes the template using the provided data, adding login and user
-// information to the base template.
+// information to the base template. Forms in the templates must include
+// {{csrfField}}, the hidden field carrying the visitor's CSRF token which is
+// checked by requireCSRF.
 func (tmpl *appTemplate) Execute(w http.ResponseWriter, r *http.Request, data interface{}) *appError {
+	return tmpl.ExecuteStatus(w, r, http.StatusOK, data)
+}
+
+// ExecuteStatus is like Execute, but responds with the given status code.
+func (tmpl *appTemplate) ExecuteStatus(w http.ResponseWriter, r *http.Request, code int, data interface{}) *appError {
 	d := struct {
 		Data        interface{}
 		AuthEnabled bool
@@ -55,7 +66,22 @@ func (tmpl *appTemplate) Execute(w http.ResponseWriter, r *http.Request, data in
 		d.Profile = profileFromSession(r)
 	}
 
-	if err := tmpl.t.Execute(w, d); err != nil {
+	tok, err := csrfToken(w, r)
+	if err != nil {
+		return appErrorf(err, "could not create CSRF token: %v", err)
+	}
+	// The parsed template is never executed itself, so that it can be
+	// cloned to bind the token.
+	t, err := tmpl.t.Clone()
+	if err != nil {
+		return appErrorf(err, "could not clone template: %v", err)
+	}
+	t.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML { return csrfField(tok) },
+	})
+
+	w.WriteHeader(code)
+	if err := t.Execute(w, d); err != nil {
 		return appErrorf(err, "could not write template: %v", err)
 	}
 	return nil
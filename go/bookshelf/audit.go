@@ -0,0 +1,224 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// Audited actions.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// AuditRecord is a change made to a book.
+type AuditRecord struct {
+	BookID int64     `json:"bookId"`
+	Action string    `json:"action"` // AuditCreate, AuditUpdate, etc.
+	Actor  Actor     `json:"actor"`
+	Time   time.Time `json:"time"`
+	// Changes are the fields that changed, with their values before and
+	// after the change. Before is empty for created and restored books, and
+	// After is empty for deleted ones. Purges change no fields.
+	Changes []FieldChange `json:"changes"`
+	// TraceID is the trace of the request that made the change, if any.
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// FieldChange is a change to a field of a book.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditLog stores the change history of books.
+type AuditLog interface {
+	// Append adds a record to the history of its book.
+	Append(ctx context.Context, r *AuditRecord) error
+
+	// History returns the records of a book, oldest first.
+	History(ctx context.Context, bookID int64) ([]*AuditRecord, error)
+}
+
+// Actor is the user, or part of the system, that changes a book.
+type Actor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SystemActor is the actor of the changes the worker makes to books.
+var SystemActor = Actor{ID: "system", Name: "Book details worker"}
+
+// unknownActor is the actor of changes made without an actor in their
+// context.
+var unknownActor = Actor{ID: "unknown", Name: "Unknown"}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx in which changes to books are made by a.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// actorFromContext returns the actor set by WithActor.
+func actorFromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return unknownActor
+}
+
+// diffBooks returns the changed fields between two versions of a book,
+// either of which may be nil.
+func diffBooks(before, after *Book) []FieldChange {
+	if before == nil {
+		before = &Book{}
+	}
+	if after == nil {
+		after = &Book{}
+	}
+	var changes []FieldChange
+	for _, f := range []struct {
+		name          string
+		before, after string
+	}{
+		{"title", before.Title, after.Title},
+		{"author", before.Author, after.Author},
+		{"publishedDate", before.PublishedDate, after.PublishedDate},
+		{"imageUrl", before.ImageURL, after.ImageURL},
+		{"description", before.Description, after.Description},
+		{"isbn", before.ISBN, after.ISBN},
+		{"createdBy", before.CreatedBy, after.CreatedBy},
+		{"createdById", before.CreatedByID, after.CreatedByID},
+	} {
+		if f.before != f.after {
+			changes = append(changes, FieldChange{Field: f.name, Before: f.before, After: f.after})
+		}
+	}
+	return changes
+}
+
+// newAuditLog returns the AuditLog stored alongside the books of db.
+func newAuditLog(db BookDatabase) (AuditLog, error) {
+	switch db := db.(type) {
+	case *memoryDB:
+		return newMemoryAuditLog(), nil
+	case *sqlDB:
+		return &sqlAuditLog{db: db}, nil
+	case *datastoreDB:
+		return &datastoreAuditLog{client: db.client}, nil
+	case *mongoDB:
+		return newMongoAuditLog(db.session)
+	default:
+		return nil, fmt.Errorf("audit: no audit log for %T", db)
+	}
+}
+
+// auditedDB decorates a BookDatabase by appending a record to an AuditLog
+// for each book created, updated or deleted through it.
+type auditedDB struct {
+	BookDatabase
+	log AuditLog
+}
+
+// AuditedDB returns a BookDatabase that records every change made through it
+// to log, attributed to the actor set in the change's context with
+// WithActor.
+func AuditedDB(db BookDatabase, log AuditLog) BookDatabase {
+	return &auditedDB{BookDatabase: db, log: log}
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *auditedDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	id, err := db.BookDatabase.AddBook(ctx, b)
+	if err == nil {
+		db.append(ctx, id, AuditCreate, nil, b)
+	}
+	return id, err
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *auditedDB) UpdateBook(ctx context.Context, b *Book) error {
+	// Since the update only succeeds if the book is still at b.Version,
+	// before is exactly the version it replaces whenever it succeeds.
+	before, err := db.BookDatabase.GetBook(ctx, b.ID)
+	if err != nil {
+		return err
+	}
+	if err := db.BookDatabase.UpdateBook(ctx, b); err != nil {
+		return err
+	}
+	db.append(ctx, b.ID, AuditUpdate, before, b)
+	return nil
+}
+
+// DeleteBook moves a given book to the trash.
+func (db *auditedDB) DeleteBook(ctx context.Context, id int64) error {
+	before, err := db.BookDatabase.GetBook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := db.BookDatabase.DeleteBook(ctx, id); err != nil {
+		return err
+	}
+	db.append(ctx, id, AuditDelete, before, nil)
+	return nil
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *auditedDB) RestoreBook(ctx context.Context, id int64) error {
+	if err := db.BookDatabase.RestoreBook(ctx, id); err != nil {
+		return err
+	}
+	// If the restored book can't be read, its fields are left out.
+	after, _ := db.BookDatabase.GetBook(ctx, id)
+	db.append(ctx, id, AuditRestore, nil, after)
+	return nil
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *auditedDB) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	ids, err := db.BookDatabase.PurgeTrash(ctx, before)
+	for _, id := range ids {
+		db.append(ctx, id, AuditPurge, nil, nil)
+	}
+	return ids, err
+}
+
+// append records a change. The change has been made by then, so failing to
+// record it is logged rather than returned.
+func (db *auditedDB) append(ctx context.Context, id int64, action string, before, after *Book) {
+	r := &AuditRecord{
+		BookID:  id,
+		Action:  action,
+		Actor:   actorFromContext(ctx),
+		Time:    time.Now(),
+		Changes: diffBooks(before, after),
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		r.TraceID = span.SpanContext().TraceID.String()
+	}
+
+	ctx, span := trace.StartSpan(ctx, "bookshelf/audit.Append")
+	defer span.End()
+	span.AddAttributes(
+		trace.Int64Attribute("book_id", id),
+		trace.StringAttribute("action", action),
+		trace.StringAttribute("actor", r.Actor.ID))
+	if err := db.log.Append(ctx, r); err != nil {
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		log.Printf("Could not record the %s of book %d by %s: %v", action, id, r.Actor.ID, err)
+	}
+}
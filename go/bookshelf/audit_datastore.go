@@ -0,0 +1,84 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+)
+
+// datastoreAuditLog stores the AuditLog in Cloud Datastore, as BookAudit
+// entities.
+type datastoreAuditLog struct {
+	client *datastore.Client
+}
+
+// Ensure datastoreAuditLog conforms to the AuditLog interface.
+var _ AuditLog = &datastoreAuditLog{}
+
+// datastoreAuditRecord is the entity an AuditRecord is stored as.
+type datastoreAuditRecord struct {
+	BookID    int64
+	Action    string `datastore:",noindex"`
+	ActorID   string `datastore:",noindex"`
+	ActorName string `datastore:",noindex"`
+	Time      time.Time
+	Changes   string `datastore:",noindex"` // JSON-encoded []FieldChange.
+	TraceID   string `datastore:",noindex"`
+}
+
+// Append adds a record to the history of its book.
+func (l *datastoreAuditLog) Append(ctx context.Context, r *AuditRecord) error {
+	changes, err := json.Marshal(r.Changes)
+	if err != nil {
+		return WrapErrorf(err, "datastoredb: could not encode changes: %v", err)
+	}
+	k := datastore.IncompleteKey("BookAudit", nil)
+	if _, err := l.client.Put(ctx, k, &datastoreAuditRecord{
+		BookID:    r.BookID,
+		Action:    r.Action,
+		ActorID:   r.Actor.ID,
+		ActorName: r.Actor.Name,
+		Time:      r.Time,
+		Changes:   string(changes),
+		TraceID:   r.TraceID,
+	}); err != nil {
+		return WrapErrorf(err, "datastoredb: could not put BookAudit: %v", err)
+	}
+	return nil
+}
+
+// History returns the records of a book, oldest first.
+func (l *datastoreAuditLog) History(ctx context.Context, bookID int64) ([]*AuditRecord, error) {
+	// Ordering by time in the query would need a composite index; a book's
+	// history is small enough to sort here.
+	var entities []*datastoreAuditRecord
+	q := datastore.NewQuery("BookAudit").Filter("BookID =", bookID)
+	if _, err := l.client.GetAll(ctx, q, &entities); err != nil {
+		return nil, WrapErrorf(err, "datastoredb: could not get history: %v", err)
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entities[i].Time.Before(entities[j].Time)
+	})
+
+	records := make([]*AuditRecord, len(entities))
+	for i, e := range entities {
+		records[i] = &AuditRecord{
+			BookID:  e.BookID,
+			Action:  e.Action,
+			Actor:   Actor{ID: e.ActorID, Name: e.ActorName},
+			Time:    e.Time,
+			TraceID: e.TraceID,
+		}
+		if err := json.Unmarshal([]byte(e.Changes), &records[i].Changes); err != nil {
+			return nil, WrapErrorf(err, "datastoredb: could not decode changes: %v", err)
+		}
+	}
+	return records, nil
+}
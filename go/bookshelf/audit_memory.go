@@ -0,0 +1,47 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// memoryAuditLog is an AuditLog held in memory, alongside memoryDB.
+type memoryAuditLog struct {
+	mu      sync.Mutex
+	records map[int64][]*AuditRecord // by book ID, oldest first.
+}
+
+// Ensure memoryAuditLog conforms to the AuditLog interface.
+var _ AuditLog = &memoryAuditLog{}
+
+func newMemoryAuditLog() *memoryAuditLog {
+	return &memoryAuditLog{records: make(map[int64][]*AuditRecord)}
+}
+
+// Append adds a record to the history of its book.
+func (l *memoryAuditLog) Append(_ context.Context, r *AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec := *r
+	l.records[r.BookID] = append(l.records[r.BookID], &rec)
+	return nil
+}
+
+// History returns the records of a book, oldest first.
+func (l *memoryAuditLog) History(_ context.Context, bookID int64) ([]*AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := make([]*AuditRecord, len(l.records[bookID]))
+	for i, r := range l.records[bookID] {
+		rec := *r
+		records[i] = &rec
+	}
+	return records, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"golang.org/x/net/context"
+)
+
+const mongoAudit = "audit"
+
+// mongoAuditLog stores the AuditLog in the audit collection of a MongoDB
+// server, with mgo's default field names.
+type mongoAuditLog struct {
+	session *mgo.Session
+}
+
+// Ensure mongoAuditLog conforms to the AuditLog interface.
+var _ AuditLog = &mongoAuditLog{}
+
+func newMongoAuditLog(session *mgo.Session) (*mongoAuditLog, error) {
+	index := mgo.Index{Key: []string{"bookid", "time"}}
+	if err := session.DB(mongoDatabase).C(mongoAudit).EnsureIndex(index); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not create index %v: %v", index.Key, err)
+	}
+	return &mongoAuditLog{session: session}, nil
+}
+
+// Append adds a record to the history of its book.
+func (l *mongoAuditLog) Append(_ context.Context, r *AuditRecord) error {
+	s := l.session.Copy()
+	defer s.Close()
+	if err := s.DB(mongoDatabase).C(mongoAudit).Insert(r); err != nil {
+		return WrapErrorf(err, "mongodb: could not add audit record: %v", err)
+	}
+	return nil
+}
+
+// History returns the records of a book, oldest first.
+func (l *mongoAuditLog) History(_ context.Context, bookID int64) ([]*AuditRecord, error) {
+	s := l.session.Copy()
+	defer s.Close()
+	records := make([]*AuditRecord, 0)
+	if err := s.DB(mongoDatabase).C(mongoAudit).Find(bson.M{"bookid": bookID}).Sort("time", "_id").All(&records); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not get history: %v", err)
+	}
+	return records, nil
+}
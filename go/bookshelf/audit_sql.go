@@ -0,0 +1,76 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/dbtrace"
+	"golang.org/x/net/context"
+)
+
+// sqlAuditLog stores the AuditLog in the audit_log table of a sqlDB. See
+// sqlMigrations for its schema. Times are stored as microseconds since the
+// Unix epoch, which both dialects handle alike.
+type sqlAuditLog struct {
+	db *sqlDB
+}
+
+// Ensure sqlAuditLog conforms to the AuditLog interface.
+var _ AuditLog = &sqlAuditLog{}
+
+const auditInsertStatement = `
+  INSERT INTO audit_log (
+    bookId, action, actorId, actorName, time, changes, traceId
+  ) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+// Append adds a record to the history of its book.
+func (l *sqlAuditLog) Append(ctx context.Context, r *AuditRecord) error {
+	changes, err := json.Marshal(r.Changes)
+	if err != nil {
+		return WrapErrorf(err, "sqldb: could not encode changes: %v", err)
+	}
+	_, err = l.db.execAffectingOneRow(ctx, auditInsertStatement, r.BookID, r.Action,
+		r.Actor.ID, r.Actor.Name, unixMicros(r.Time), string(changes), r.TraceID)
+	return err
+}
+
+const auditHistoryStatement = `
+  SELECT bookId, action, actorId, actorName, time, changes, traceId
+  FROM audit_log WHERE bookId = ? ORDER BY id`
+
+// History returns the records of a book, oldest first.
+func (l *sqlAuditLog) History(ctx context.Context, bookID int64) ([]*AuditRecord, error) {
+	q := dbtrace.StartQuery(ctx, auditHistoryStatement)
+	defer q.End(ctx)
+
+	q.Rows, q.Err = l.db.conn.QueryContext(ctx, auditHistoryStatement, bookID)
+	if q.Err != nil {
+		return nil, WrapErrorf(q.Err, "sqldb: could not get history: %v", q.Err)
+	}
+	defer q.Rows.Close()
+
+	records := make([]*AuditRecord, 0)
+	for q.NextRow() {
+		var (
+			r       AuditRecord
+			micros  int64
+			changes string
+		)
+		q.Err = q.Rows.Scan(&r.BookID, &r.Action, &r.Actor.ID, &r.Actor.Name,
+			&micros, &changes, &r.TraceID)
+		if q.Err == nil {
+			q.Err = json.Unmarshal([]byte(changes), &r.Changes)
+		}
+		if q.Err != nil {
+			return nil, WrapErrorf(q.Err, "sqldb: could not read history: %v", q.Err)
+		}
+		r.Time = time.Unix(0, micros*int64(time.Microsecond))
+		records = append(records, &r)
+	}
+	q.Err = q.Rows.Err()
+	return records, q.Err
+}
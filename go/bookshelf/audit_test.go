@@ -0,0 +1,112 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// testAuditLog checks that the changes made through an AuditedDB over db
+// are recorded in db's audit log, and closes db.
+func testAuditLog(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	defer db.Close(ctx)
+	log, err := newAuditLog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = AuditedDB(db, log)
+
+	alice := Actor{ID: "u1", Name: "Alice"}
+	ctx = WithActor(ctx, alice)
+	ctx, span := trace.StartSpan(ctx, "test")
+	defer span.End()
+
+	b := &Book{Title: "Dune", Author: "Frank Herbert"}
+	id, err := db.AddBook(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ID = id
+	b.Title = "Dune Messiah"
+	if err := db.UpdateBook(WithActor(ctx, SystemActor), b); err != nil {
+		t.Fatal(err)
+	}
+	// Failed changes are not recorded.
+	stale := *b
+	stale.Version = 1
+	if err := db.UpdateBook(ctx, &stale); err == nil {
+		t.Fatal("UpdateBook of a stale version: got no error, want a conflict")
+	}
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := log.History(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		action  string
+		actor   Actor
+		changes string
+	}{
+		{AuditCreate, alice, "[{title  Dune} {author  Frank Herbert}]"},
+		{AuditUpdate, SystemActor, "[{title Dune Dune Messiah}]"},
+		{AuditDelete, alice, "[{title Dune Messiah } {author Frank Herbert }]"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("History(%d) returned %d records, want %d", id, len(records), len(want))
+	}
+	traceID := span.SpanContext().TraceID.String()
+	for i, r := range records {
+		w := want[i]
+		if r.BookID != id || r.Action != w.action || r.Actor != w.actor || fmt.Sprint(r.Changes) != w.changes {
+			t.Errorf("record %d = %+v, want %s by %v of %s", i, r, w.action, w.actor, w.changes)
+		}
+		if r.TraceID != traceID {
+			t.Errorf("record %d: TraceID = %q, want %q", i, r.TraceID, traceID)
+		}
+		if i > 0 && r.Time.Before(records[i-1].Time) {
+			t.Errorf("record %d is older than record %d", i, i-1)
+		}
+	}
+}
+
+func TestMemoryAuditLog(t *testing.T) {
+	testAuditLog(t, newMemoryDB())
+}
+
+func TestSQLiteAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bookshelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := newSQLiteDB(filepath.Join(dir, "bookshelf.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAuditLog(t, db)
+}
+
+func TestMongoAuditLog(t *testing.T) {
+	addr, stop := mongoAddr(t)
+	defer stop()
+
+	db, err := newMongoDB(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAuditLog(t, db)
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"strings"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// ErrNotAuthorized is returned by Authorize when a user may not modify a
+// book. It is of KindUnauthorized.
+var ErrNotAuthorized error = &Error{Kind: KindUnauthorized, Message: "bookshelf: not authorized"}
+
+// Reasons an attempt to modify a book is denied.
+const (
+	deniedSignedOut = "signed_out"
+	deniedNotOwner  = "not_owner"
+)
+
+var (
+	authzDenied = stats.Int64("bookshelf/authz/denied", "Number of denied attempts to modify a book", stats.UnitNone)
+
+	keyAuthzAction = mustNewKey("bookshelf_authz_action")
+	keyAuthzReason = mustNewKey("bookshelf_authz_reason")
+)
+
+var (
+	// AuthzDeniedView counts denied attempts to modify a book, by action
+	// (e.g. "update" or "delete") and reason ("signed_out" or "not_owner").
+	AuthzDeniedView = &view.View{
+		Name:        "bookshelf/authz/denied",
+		Description: "Count of denied attempts to modify a book, by action and reason",
+		Measure:     authzDenied,
+		TagKeys:     []tag.Key{keyAuthzAction, keyAuthzReason},
+		Aggregation: view.Count(),
+	}
+
+	// DefaultAuthzViews are the views recorded by Authorize.
+	DefaultAuthzViews = []*view.View{AuthzDeniedView}
+)
+
+// User is a signed-in user, as identified by the sign-in provider.
+type User struct {
+	ID    string
+	Email string
+	// EmailVerified is whether the provider verified that the user owns
+	// Email. Anyone can claim an unverified address.
+	EmailVerified bool
+}
+
+// IsAdmin reports whether u is one of the Admins, by ID or verified email
+// address.
+func (u *User) IsAdmin() bool {
+	if u == nil {
+		return false
+	}
+	for _, a := range Admins {
+		if a == u.ID || (u.EmailVerified && u.Email != "" && strings.EqualFold(a, u.Email)) {
+			return true
+		}
+	}
+	return false
+}
+
+// canModify reports whether u may modify b, and if not, why. u is nil for a
+// signed-out visitor. Only the creator of a book and the admins may modify
+// it; books added anonymously can only be modified by the admins.
+func canModify(u *User, b *Book) (ok bool, reason string) {
+	switch {
+	case u == nil:
+		return false, deniedSignedOut
+	case u.IsAdmin():
+		return true, ""
+	case u.ID != "" && u.ID == b.CreatedByID:
+		return true, ""
+	default:
+		return false, deniedNotOwner
+	}
+}
+
+// Authorize returns ErrNotAuthorized if u may not perform action on b. The
+// decision is traced, and denied attempts are recorded in AuthzDeniedView.
+func Authorize(ctx context.Context, action string, u *User, b *Book) error {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/authz.Authorize")
+	defer span.End()
+
+	ok, reason := canModify(u, b)
+	userID := ""
+	if u != nil {
+		userID = u.ID
+	}
+	span.AddAttributes(
+		trace.StringAttribute("action", action),
+		trace.Int64Attribute("book_id", b.ID),
+		trace.StringAttribute("user_id", userID),
+		trace.BoolAttribute("allowed", ok))
+	if ok {
+		return nil
+	}
+
+	span.AddAttributes(trace.StringAttribute("reason", reason))
+	span.SetStatus(trace.Status{Code: 7, Message: reason}) // PERMISSION_DENIED
+	ctx, _ = tag.New(ctx,
+		tag.Upsert(keyAuthzAction, action),
+		tag.Upsert(keyAuthzReason, reason))
+	stats.Record(ctx, authzDenied.M(1))
+	return ErrNotAuthorized
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"golang.org/x/net/context"
+)
+
+func TestAuthorize(t *testing.T) {
+	if err := view.Register(DefaultAuthzViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(DefaultAuthzViews...)
+	Admins = []string{"Admin@example.com"}
+	defer func() { Admins = nil }()
+
+	ctx := context.Background()
+	owner := &User{ID: "u1", Email: "owner@example.com", EmailVerified: true}
+	other := &User{ID: "u2", Email: "other@example.com", EmailVerified: true}
+	admin := &User{ID: "u3", Email: "admin@example.com", EmailVerified: true}
+	// Anyone can claim the admin's address without verifying it.
+	impostor := &User{ID: "u4", Email: "admin@example.com"}
+	book := &Book{ID: 1, CreatedByID: owner.ID}
+	anon := &Book{ID: 2}
+	anon.SetCreatorAnonymous()
+
+	for _, tt := range []struct {
+		user *User
+		book *Book
+		want error
+	}{
+		{owner, book, nil},
+		{admin, book, nil},
+		{admin, anon, nil},
+		{other, book, ErrNotAuthorized},
+		{nil, book, ErrNotAuthorized},
+		{owner, anon, ErrNotAuthorized},
+		{impostor, book, ErrNotAuthorized},
+		{&User{}, &Book{}, ErrNotAuthorized},
+	} {
+		if got := Authorize(ctx, "update", tt.user, tt.book); got != tt.want {
+			t.Errorf("Authorize(%+v, book created by %q) = %v, want %v", tt.user, tt.book.CreatedByID, got, tt.want)
+		}
+	}
+
+	rows, err := view.RetrieveData(AuthzDeniedView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := make(map[string]int64)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == keyAuthzReason {
+				denied[tag.Value] += row.Data.(*view.CountData).Value
+			}
+		}
+	}
+	if denied[deniedNotOwner] != 4 || denied[deniedSignedOut] != 1 {
+		t.Errorf("denied attempts by reason = %v, want 4 not_owner and 1 signed_out", denied)
+	}
+}
@@ -4,18 +4,46 @@
 
 package bookshelf
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 // Book holds metadata about a book.
 type Book struct {
-	ID            int64
-	Title         string
-	Author        string
-	PublishedDate string
-	ImageURL      string
-	Description   string
-	CreatedBy     string
-	CreatedByID   string
+	ID            int64  `json:"id"`
+	Title         string `json:"title"`
+	Author        string `json:"author"`
+	PublishedDate string `json:"publishedDate"`
+	ImageURL      string `json:"imageUrl"`
+	Description   string `json:"description"`
+	ISBN          string `json:"isbn"`
+	CreatedBy     string `json:"createdBy"`
+	CreatedByID   string `json:"createdById"`
+	// Version is incremented by every update of the book, starting from 1.
+	Version int64 `json:"version"`
+	// TrashedAt is when the book was moved to the trash, for the books
+	// returned by BookDatabase.ListTrash and GetTrashedBook.
+	TrashedAt time.Time `json:"-"`
+}
+
+// ErrNoSuchBook is returned by BookDatabase.GetBook when no book has the
+// given ID. It is of KindNotFound.
+var ErrNoSuchBook error = &Error{Kind: KindNotFound, Message: "bookshelf: no such book"}
+
+// ConflictError is returned by BookDatabase.UpdateBook when the book has been
+// updated since the version being written was read.
+type ConflictError struct {
+	ID      int64
+	Version int64 // The stale version.
+}
+
+// ErrorKind returns KindConflict.
+func (e *ConflictError) ErrorKind() ErrorKind { return KindConflict }
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("bookshelf: book %d has been modified since version %d", e.ID, e.Version)
 }
 
 // CreatedByDisplayName returns a string appropriate for displaying the name of
@@ -42,16 +70,54 @@ type BookDatabase interface {
 	// the user who created the book entry.
 	ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error)
 
-	// GetBook retrieves a book by its ID.
+	// ListBooksPage returns up to pageSize books, ordered by title, starting
+	// at the given cursor ("" for the first page). next is the opaque cursor
+	// of the following page, or "" if there are no more books.
+	ListBooksPage(ctx context.Context, pageSize int, cursor string) (books []*Book, next string, err error)
+
+	// ListBooksCreatedByPage is like ListBooksPage, filtered by the user who
+	// created the book entry.
+	ListBooksCreatedByPage(ctx context.Context, userID string, pageSize int, cursor string) (books []*Book, next string, err error)
+
+	// SearchBooks returns up to MaxSearchResults books, ordered by title, in
+	// which every keyword of query starts a word of the title, author or
+	// description. Matching is case-insensitive.
+	SearchBooks(ctx context.Context, query string) ([]*Book, error)
+
+	// GetBook retrieves a book by its ID. It returns ErrNoSuchBook if there
+	// is no such book.
 	GetBook(ctx context.Context, id int64) (*Book, error)
 
-	// AddBook saves a given book, assigning it a new ID.
+	// AddBook saves a given book, assigning it a new ID. It sets b.Version
+	// to 1.
 	AddBook(ctx context.Context, b *Book) (id int64, err error)
 
-	// DeleteBook removes a given book by its ID.
+	// DeleteBook moves a given book to the trash, from which it can be
+	// restored until it is purged. Trashed books are left out of every other
+	// method, as if they were removed. It returns ErrNoSuchBook if there is
+	// no such book, or it is already in the trash.
 	DeleteBook(ctx context.Context, id int64) error
 
-	// UpdateBook updates the entry for a given book.
+	// ListTrash returns the books in the trash, most recently trashed first,
+	// filtered by the user who created the book entry unless userID is "".
+	ListTrash(ctx context.Context, userID string) ([]*Book, error)
+
+	// GetTrashedBook retrieves a book in the trash by its ID. It returns
+	// ErrNoSuchBook if there is no such book in the trash.
+	GetTrashedBook(ctx context.Context, id int64) (*Book, error)
+
+	// RestoreBook moves a given book out of the trash. It returns
+	// ErrNoSuchBook if there is no such book in the trash.
+	RestoreBook(ctx context.Context, id int64) error
+
+	// PurgeTrash permanently removes the books moved to the trash before a
+	// given time, and returns their IDs.
+	PurgeTrash(ctx context.Context, before time.Time) (ids []int64, err error)
+
+	// UpdateBook updates the entry for a given book, if it is still at
+	// b.Version, and sets b.Version to the new version. Otherwise, it returns
+	// a *ConflictError, or ErrNoSuchBook if there is no such book, or it is
+	// in the trash.
 	UpdateBook(ctx context.Context, b *Book) error
 
 	// Close closes the database, freeing up any available resources.
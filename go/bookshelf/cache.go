@@ -0,0 +1,507 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// The cache settings, unless configured otherwise.
+const (
+	DefaultCacheSize = 1000
+	DefaultCacheTTL  = 30 * time.Second
+)
+
+var (
+	cacheLookupLatency = stats.Float64("bookshelf/cache/lookup_latency", "Latency of BookCache lookups, including the database call of misses", stats.UnitMilliseconds)
+	cacheEvictions     = stats.Int64("bookshelf/cache/evictions", "Number of entries evicted from a BookCache", stats.UnitNone)
+
+	keyCacheMethod = mustNewKey("bookshelf_cache_method")
+	keyCacheResult = mustNewKey("bookshelf_cache_result")
+	keyCacheReason = mustNewKey("bookshelf_cache_eviction_reason")
+)
+
+// Results of cache lookups.
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// Reasons for evicting cache entries.
+const (
+	evictedCapacity    = "capacity"    // the cache was full.
+	evictedExpired     = "expired"     // the entry was older than the TTL.
+	evictedInvalidated = "invalidated" // the books were changed.
+)
+
+var (
+	// CacheLookupLatencyView is the distribution of BookCache lookup
+	// latencies, by method and result ("hit" or "miss"). Misses include the
+	// database call.
+	CacheLookupLatencyView = &view.View{
+		Name:        "bookshelf/cache/lookup_latency",
+		Description: "Latency distribution of BookCache lookups, by method and result",
+		Measure:     cacheLookupLatency,
+		TagKeys:     []tag.Key{keyCacheMethod, keyCacheResult},
+		Aggregation: view.Distribution(0, 0.1, 0.2, 0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000),
+	}
+
+	// CacheLookupsView counts BookCache lookups, by method and result, from
+	// which the hit ratio is derived.
+	CacheLookupsView = &view.View{
+		Name:        "bookshelf/cache/lookups",
+		Description: "Count of BookCache lookups, by method and result",
+		Measure:     cacheLookupLatency,
+		TagKeys:     []tag.Key{keyCacheMethod, keyCacheResult},
+		Aggregation: view.Count(),
+	}
+
+	// CacheEvictionsView counts the entries evicted from BookCaches, by
+	// reason ("capacity", "expired" or "invalidated").
+	CacheEvictionsView = &view.View{
+		Name:        "bookshelf/cache/evictions",
+		Description: "Count of entries evicted from BookCaches, by reason",
+		Measure:     cacheEvictions,
+		TagKeys:     []tag.Key{keyCacheReason},
+		Aggregation: view.Sum(),
+	}
+
+	// DefaultCacheViews are the views recorded by BookCache.
+	DefaultCacheViews = []*view.View{CacheLookupLatencyView, CacheLookupsView, CacheEvictionsView}
+)
+
+// BookCache decorates a BookDatabase with an in-memory read-through cache of
+// GetBook, ListBooks and ListBooksPage. It holds up to a given number of
+// entries, each a book or a list of books, evicting the least recently used
+// ones first, and for up to a given TTL.
+//
+// Every change made through the cache invalidates the changed books and all
+// the lists. Changes made by other processes, such as the Pub/Sub worker or
+// other instances of the app, are invalidated as they are received by
+// Follow; without it, or if they are lost, they are seen once the entries
+// expire.
+type BookCache struct {
+	db       BookDatabase
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first.
+	entries map[string]*list.Element
+	// gen is incremented by every invalidation, so that the results of the
+	// reads that started before it are not cached.
+	gen uint64
+
+	// The counts reported by Status, kept alongside DefaultCacheViews; see
+	// meanDuration.
+	hits, misses meanDuration // the latencies of lookups, by result.
+	evictions    map[string]int64
+}
+
+// Ensure BookCache conforms to the BookDatabase interface.
+var _ BookDatabase = &BookCache{}
+
+// cacheEntry is a cached result: a *Book, a []*Book or a *cachedPage.
+type cacheEntry struct {
+	key   string
+	value interface{}
+	// isList is set for the entries of list methods, which every change
+	// invalidates.
+	isList  bool
+	added   time.Time
+	expires time.Time
+	hits    int64
+}
+
+// cachedPage is the result of ListBooksPage.
+type cachedPage struct {
+	books []*Book
+	next  string
+}
+
+// NewBookCache returns a BookCache of db holding up to capacity entries for
+// up to ttl. The cache records its lookups in DefaultCacheViews, which must be
+// registered for their data to be exported.
+func NewBookCache(db BookDatabase, capacity int, ttl time.Duration) *BookCache {
+	return &BookCache{
+		db:        db,
+		capacity:  capacity,
+		ttl:       ttl,
+		now:       time.Now,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		evictions: make(map[string]int64),
+	}
+}
+
+// uncached returns the database that db caches, if it is a BookCache, and
+// otherwise db, for the reads that must see the latest changes.
+func uncached(db BookDatabase) BookDatabase {
+	if c, ok := db.(*BookCache); ok {
+		return c.db
+	}
+	return db
+}
+
+// bookKey is the cache key of the book with the given ID.
+func bookKey(id int64) string {
+	return "GetBook/" + strconv.FormatInt(id, 10)
+}
+
+// lookup returns the cached value of key, calling load and caching its
+// result on a miss. Cached values are cloned, so that callers may modify
+// them.
+func (c *BookCache) lookup(ctx context.Context, method, key string, isList bool, load func(context.Context) (interface{}, error)) (interface{}, error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/cache."+method)
+	defer span.End()
+	start := time.Now()
+
+	result := cacheHit
+	v, gen, ok := c.get(ctx, key)
+	var err error
+	if !ok {
+		result = cacheMiss
+		if v, err = load(ctx); err != nil {
+			span.SetStatus(trace.Status{Code: KindOf(err).TraceCode(), Message: err.Error()})
+		} else {
+			c.put(ctx, key, gen, cloneCached(v), isList)
+		}
+	}
+	span.AddAttributes(trace.StringAttribute("result", result))
+
+	latency := time.Since(start)
+	c.mu.Lock()
+	if result == cacheHit {
+		c.hits.add(latency)
+	} else {
+		c.misses.add(latency)
+	}
+	c.mu.Unlock()
+
+	ctx, _ = tag.New(ctx,
+		tag.Upsert(keyCacheMethod, method),
+		tag.Upsert(keyCacheResult, result))
+	stats.Record(ctx, cacheLookupLatency.M(float64(latency)/float64(time.Millisecond)))
+	return v, err
+}
+
+// get returns a clone of the cached value of key, evicting it if it has
+// expired. On a miss, it returns the generation to cache the loaded value
+// at.
+func (c *BookCache) get(ctx context.Context, key string) (v interface{}, gen uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, c.gen, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		c.recordEvictions(ctx, evictedExpired, 1)
+		return nil, c.gen, false
+	}
+	e.hits++
+	c.lru.MoveToFront(el)
+	return cloneCached(e.value), c.gen, true
+}
+
+// put caches v under key, unless the cache was invalidated since gen. It
+// evicts the least recently used entries beyond the capacity.
+func (c *BookCache) put(ctx context.Context, key string, gen uint64, v interface{}, isList bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	now := c.now()
+	e := &cacheEntry{key: key, value: v, isList: isList, added: now, expires: now.Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(e)
+
+	evicted := 0
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		evicted++
+	}
+	c.recordEvictions(ctx, evictedCapacity, evicted)
+}
+
+// invalidate evicts the books with the given IDs and all the lists.
+func (c *BookCache) invalidate(ctx context.Context, ids ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	evicted := 0
+	for _, id := range ids {
+		if el, ok := c.entries[bookKey(id)]; ok {
+			c.remove(el)
+			evicted++
+		}
+	}
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cacheEntry).isList {
+			c.remove(el)
+			evicted++
+		}
+		el = next
+	}
+	c.recordEvictions(ctx, evictedInvalidated, evicted)
+}
+
+// Follow invalidates the books changed by other processes, as received from
+// feed, until ctx is done or receiving fails.
+func (c *BookCache) Follow(ctx context.Context, feed ChangeFeed) error {
+	return feed.Receive(ctx, func(ctx context.Context, ids []int64) {
+		c.invalidate(ctx, ids...)
+	})
+}
+
+// remove removes an entry. c.mu must be held.
+func (c *BookCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// recordEvictions records n evictions for the given reason. c.mu must be
+// held.
+func (c *BookCache) recordEvictions(ctx context.Context, reason string, n int) {
+	if n == 0 {
+		return
+	}
+	c.evictions[reason] += int64(n)
+	ctx, _ = tag.New(ctx, tag.Upsert(keyCacheReason, reason))
+	stats.Record(ctx, cacheEvictions.M(int64(n)))
+}
+
+// cloneCached returns a deep copy of a cached value.
+func cloneCached(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Book:
+		b := *v
+		return &b
+	case []*Book:
+		return cloneBooks(v)
+	case *cachedPage:
+		return &cachedPage{books: cloneBooks(v.books), next: v.next}
+	}
+	return v
+}
+
+func cloneBooks(books []*Book) []*Book {
+	if books == nil {
+		return nil
+	}
+	clone := make([]*Book, len(books))
+	for i, b := range books {
+		c := *b
+		clone[i] = &c
+	}
+	return clone
+}
+
+// ListBooks returns a list of books, ordered by title.
+func (c *BookCache) ListBooks(ctx context.Context) ([]*Book, error) {
+	v, err := c.lookup(ctx, "ListBooks", "ListBooks", true, func(ctx context.Context) (interface{}, error) {
+		books, err := c.db.ListBooks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return books, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Book), nil
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry. It is not cached.
+func (c *BookCache) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	return c.db.ListBooksCreatedBy(ctx, userID)
+}
+
+// ListBooksPage returns up to pageSize books, ordered by title, starting at
+// the given cursor.
+func (c *BookCache) ListBooksPage(ctx context.Context, pageSize int, cursor string) ([]*Book, string, error) {
+	key := fmt.Sprintf("ListBooksPage/%d/%s", pageSize, cursor)
+	v, err := c.lookup(ctx, "ListBooksPage", key, true, func(ctx context.Context) (interface{}, error) {
+		books, next, err := c.db.ListBooksPage(ctx, pageSize, cursor)
+		if err != nil {
+			return nil, err
+		}
+		return &cachedPage{books: books, next: next}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	page := v.(*cachedPage)
+	return page.books, page.next, nil
+}
+
+// ListBooksCreatedByPage is like ListBooksPage, filtered by the user who
+// created the book entry. It is not cached.
+func (c *BookCache) ListBooksCreatedByPage(ctx context.Context, userID string, pageSize int, cursor string) ([]*Book, string, error) {
+	return c.db.ListBooksCreatedByPage(ctx, userID, pageSize, cursor)
+}
+
+// SearchBooks returns the books matching query. It is not cached.
+func (c *BookCache) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return c.db.SearchBooks(ctx, query)
+}
+
+// GetBook retrieves a book by its ID. Missing books are not cached.
+func (c *BookCache) GetBook(ctx context.Context, id int64) (*Book, error) {
+	v, err := c.lookup(ctx, "GetBook", bookKey(id), false, func(ctx context.Context) (interface{}, error) {
+		book, err := c.db.GetBook(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return book, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Book), nil
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (c *BookCache) AddBook(ctx context.Context, b *Book) (int64, error) {
+	id, err := c.db.AddBook(ctx, b)
+	c.invalidate(ctx)
+	return id, err
+}
+
+// DeleteBook moves a given book to the trash.
+func (c *BookCache) DeleteBook(ctx context.Context, id int64) error {
+	err := c.db.DeleteBook(ctx, id)
+	c.invalidate(ctx, id)
+	return err
+}
+
+// ListTrash returns the books in the trash, most recently trashed first. It
+// is not cached.
+func (c *BookCache) ListTrash(ctx context.Context, userID string) ([]*Book, error) {
+	return c.db.ListTrash(ctx, userID)
+}
+
+// GetTrashedBook retrieves a book in the trash by its ID. It is not cached.
+func (c *BookCache) GetTrashedBook(ctx context.Context, id int64) (*Book, error) {
+	return c.db.GetTrashedBook(ctx, id)
+}
+
+// RestoreBook moves a given book out of the trash.
+func (c *BookCache) RestoreBook(ctx context.Context, id int64) error {
+	err := c.db.RestoreBook(ctx, id)
+	c.invalidate(ctx, id)
+	return err
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (c *BookCache) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	ids, err := c.db.PurgeTrash(ctx, before)
+	c.invalidate(ctx, ids...)
+	return ids, err
+}
+
+// UpdateBook updates the entry for a given book. The book is invalidated
+// even if the update fails, since a conflict shows that it is stale.
+func (c *BookCache) UpdateBook(ctx context.Context, b *Book) error {
+	err := c.db.UpdateBook(ctx, b)
+	c.invalidate(ctx, b.ID)
+	return err
+}
+
+// Close empties the cache and closes the database.
+func (c *BookCache) Close(ctx context.Context) {
+	c.mu.Lock()
+	c.gen++
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.mu.Unlock()
+	c.db.Close(ctx)
+}
+
+// CacheEntryStatus describes a cache entry.
+type CacheEntryStatus struct {
+	Key  string
+	Hits int64
+	// Age is the time since the entry was cached.
+	Age time.Duration
+	// ExpiresIn is the time until the entry expires.
+	ExpiresIn time.Duration
+}
+
+// CacheStatus summarizes the state and the lookups of a BookCache.
+type CacheStatus struct {
+	Capacity int
+	TTL      time.Duration
+	// Size is the number of entries.
+	Size int
+	// Entries are the entries, most recently used first.
+	Entries []*CacheEntryStatus
+
+	Hits, Misses int64
+	// Evictions counts the evicted entries, by reason.
+	Evictions map[string]int64
+	// MeanHitLatency and MeanMissLatency are the mean latencies of the
+	// lookups that hit and missed.
+	MeanHitLatency, MeanMissLatency time.Duration
+}
+
+// HitRatio returns the fraction of lookups that hit, or 0 if there were
+// none.
+func (s *CacheStatus) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Status returns the current CacheStatus of c, with up to maxEntries of its
+// entries.
+func (c *BookCache) Status(maxEntries int) *CacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &CacheStatus{
+		Capacity:        c.capacity,
+		TTL:             c.ttl,
+		Size:            c.lru.Len(),
+		Hits:            c.hits.count,
+		Misses:          c.misses.count,
+		Evictions:       make(map[string]int64),
+		MeanHitLatency:  c.hits.mean(),
+		MeanMissLatency: c.misses.mean(),
+	}
+	for reason, n := range c.evictions {
+		s.Evictions[reason] = n
+	}
+	now := c.now()
+	for el := c.lru.Front(); el != nil && len(s.Entries) < maxEntries; el = el.Next() {
+		e := el.Value.(*cacheEntry)
+		s.Entries = append(s.Entries, &CacheEntryStatus{
+			Key:       e.key,
+			Hits:      e.hits,
+			Age:       now.Sub(e.added),
+			ExpiresIn: e.expires.Sub(now),
+		})
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBookCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewBookCache(newMemoryDB(), 2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	id, err := c.AddBook(ctx, &Book{Title: "Title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := c.GetBook(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changing the returned book must not change the cached one.
+	book.Title = "Changed"
+	if got, _ := c.GetBook(ctx, id); got.Title != "Title" {
+		t.Errorf("GetBook after changing the returned book: got title %q, want %q", got.Title, "Title")
+	}
+	if _, err := c.GetBook(ctx, id+1); err != ErrNoSuchBook {
+		t.Errorf("GetBook of a missing book: got err %v, want ErrNoSuchBook", err)
+	}
+
+	// Writes invalidate the book and the lists.
+	if books, _, _ := c.ListBooksPage(ctx, 10, ""); len(books) != 1 {
+		t.Fatalf("ListBooksPage: got %d books, want 1", len(books))
+	}
+	if err := c.UpdateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetBook(ctx, id); got.Title != "Changed" {
+		t.Errorf("GetBook after UpdateBook: got title %q, want %q", got.Title, "Changed")
+	}
+	if _, err := c.AddBook(ctx, &Book{Title: "Another"}); err != nil {
+		t.Fatal(err)
+	}
+	if books, _, _ := c.ListBooksPage(ctx, 10, ""); len(books) != 2 {
+		t.Errorf("ListBooksPage after AddBook: got %d books, want 2", len(books))
+	}
+	if err := c.DeleteBook(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBook(ctx, id); err != ErrNoSuchBook {
+		t.Errorf("GetBook after DeleteBook: got err %v, want ErrNoSuchBook", err)
+	}
+
+	// The least recently used entries are evicted beyond the capacity, and
+	// the others once they expire.
+	c.ListBooks(ctx)
+	c.ListBooksPage(ctx, 1, "")
+	c.ListBooksPage(ctx, 2, "")
+	now = now.Add(time.Minute)
+	c.ListBooksPage(ctx, 1, "")
+
+	after := c.Status(10)
+	if after.Size != 2 || len(after.Entries) != 2 || after.Entries[0].Key != "ListBooksPage/1/" || after.Entries[1].Key != "ListBooksPage/2/" {
+		t.Errorf("Status().Entries = %v, want the pages of 1 and 2 books", after.Entries)
+	}
+	if after.Hits != 1 || after.Misses != 10 {
+		t.Errorf("got %d hits and %d misses, want 1 and 10", after.Hits, after.Misses)
+	}
+	for reason, want := range map[string]int64{
+		evictedCapacity:    1,
+		evictedExpired:     1,
+		evictedInvalidated: 4,
+	} {
+		if got := after.Evictions[reason]; got != want {
+			t.Errorf("%s evictions: got %d, want %d", reason, got, want)
+		}
+	}
+}
+
+// fakeChangeFeed delivers every published change to its receiver, and then
+// to handled.
+type fakeChangeFeed struct {
+	changes chan []int64
+	handled chan []int64
+}
+
+func (f *fakeChangeFeed) Publish(ctx context.Context, ids []int64) error {
+	f.changes <- ids
+	return nil
+}
+
+func (f *fakeChangeFeed) Receive(ctx context.Context, fn func(context.Context, []int64)) error {
+	for {
+		select {
+		case ids := <-f.changes:
+			fn(ctx, ids)
+			f.handled <- ids
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *fakeChangeFeed) Close() error { return nil }
+
+func TestBookCacheFollow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newMemoryDB()
+	feed := &fakeChangeFeed{changes: make(chan []int64, 1), handled: make(chan []int64, 1)}
+	// The worker changes the books shared with the app, which caches them.
+	worker := NotifyingDB(db, feed)
+	c := NewBookCache(db, 10, time.Hour)
+	go c.Follow(ctx, feed)
+
+	id, err := worker.AddBook(ctx, &Book{Title: "Title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-feed.handled
+	book, err := c.GetBook(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Title = "Changed"
+	if err := worker.UpdateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if ids := <-feed.handled; len(ids) != 1 || ids[0] != id {
+		t.Fatalf("got change of books %v, want [%d]", ids, id)
+	}
+	if got, _ := c.GetBook(ctx, id); got.Title != "Changed" {
+		t.Errorf("GetBook after another process's UpdateBook: got title %q, want %q", got.Title, "Changed")
+	}
+
+	// Failed changes are not published.
+	if err := worker.DeleteBook(ctx, id+1); err == nil {
+		t.Fatal("DeleteBook of a missing book: got nil error")
+	}
+	select {
+	case ids := <-feed.changes:
+		t.Errorf("DeleteBook of a missing book published change of books %v", ids)
+	case ids := <-feed.handled:
+		t.Errorf("DeleteBook of a missing book published change of books %v", ids)
+	default:
+	}
+}
@@ -0,0 +1,497 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// Formats of the catalog for ExportBooks and ImportBooks.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+const (
+	// exportPageSize is the number of books read from the database at a
+	// time by ExportBooks.
+	exportPageSize = 100
+	// importBatchSize is the number of rows traced by each batch span of
+	// ImportBooks.
+	importBatchSize = 100
+)
+
+// catalogColumns are the columns of a CSV catalog, named like the JSON
+// fields of a Book.
+var catalogColumns = []string{"id", "title", "author", "publishedDate", "imageUrl", "description", "isbn", "createdBy", "createdById", "version"}
+
+// bookRecord returns the CSV record of b, in the order of catalogColumns.
+func bookRecord(b *Book) []string {
+	return []string{
+		strconv.FormatInt(b.ID, 10), b.Title, b.Author, b.PublishedDate, b.ImageURL,
+		b.Description, b.ISBN, b.CreatedBy, b.CreatedByID, strconv.FormatInt(b.Version, 10),
+	}
+}
+
+// catalogWriter writes a catalog of books in one of the formats.
+type catalogWriter interface {
+	begin() error
+	write(*Book) error
+	end() error
+}
+
+type csvCatalogWriter struct{ w *csv.Writer }
+
+func (c *csvCatalogWriter) begin() error        { return c.w.Write(catalogColumns) }
+func (c *csvCatalogWriter) write(b *Book) error { return c.w.Write(bookRecord(b)) }
+func (c *csvCatalogWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonCatalogWriter writes a JSON array of books, one per line.
+type jsonCatalogWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	first bool
+}
+
+func (j *jsonCatalogWriter) begin() error {
+	j.first = true
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonCatalogWriter) write(b *Book) error {
+	sep := ","
+	if j.first {
+		sep, j.first = "", false
+	}
+	if _, err := io.WriteString(j.w, sep+"\n"); err != nil {
+		return err
+	}
+	return j.enc.Encode(b)
+}
+
+func (j *jsonCatalogWriter) end() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
+
+func newCatalogWriter(w io.Writer, format string) (catalogWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvCatalogWriter{csv.NewWriter(w)}, nil
+	case FormatJSON:
+		return &jsonCatalogWriter{w: w, enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("bookshelf: unknown catalog format %q", format)
+	}
+}
+
+// ExportBooks writes every book in db to w, ordered by title, as CSV with a
+// header row or as a JSON array of books. The books are read a page at a
+// time, so the catalog is streamed rather than held in memory. Nothing is
+// written if the first page cannot be read. It returns the number of books
+// written.
+func ExportBooks(ctx context.Context, db BookDatabase, w io.Writer, format string) (n int, err error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/catalog.Export")
+	defer span.End()
+	defer func() {
+		span.AddAttributes(
+			trace.StringAttribute("format", format),
+			trace.Int64Attribute("books", int64(n)))
+		if err != nil {
+			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		}
+	}()
+
+	cw, err := newCatalogWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	books, next, err := db.ListBooksPage(ctx, exportPageSize, "")
+	if err != nil {
+		return 0, fmt.Errorf("bookshelf: could not list books: %v", err)
+	}
+	if err := cw.begin(); err != nil {
+		return 0, fmt.Errorf("bookshelf: could not write catalog: %v", err)
+	}
+	for {
+		for _, b := range books {
+			if err := cw.write(b); err != nil {
+				return n, fmt.Errorf("bookshelf: could not write catalog: %v", err)
+			}
+			n++
+		}
+		if next == "" {
+			break
+		}
+		if books, next, err = db.ListBooksPage(ctx, exportPageSize, next); err != nil {
+			return n, fmt.Errorf("bookshelf: could not list books: %v", err)
+		}
+	}
+	if err := cw.end(); err != nil {
+		return n, fmt.Errorf("bookshelf: could not write catalog: %v", err)
+	}
+	return n, nil
+}
+
+// ImportOptions control ImportBooks.
+type ImportOptions struct {
+	// DryRun validates the catalog without adding any books.
+	DryRun bool
+
+	// SetCreator, if set, is called with each valid book before it is
+	// added, e.g. to attribute it to the user importing the catalog rather
+	// than the creator in the catalog.
+	SetCreator func(*Book)
+
+	// Added, if set, is called with the ID of each added book, and a context
+	// carrying the span of its batch.
+	Added func(ctx context.Context, id int64)
+}
+
+// ImportReport is the outcome of ImportBooks.
+type ImportReport struct {
+	DryRun bool
+	// Rows is the number of books read from the catalog.
+	Rows int
+	// Books are the valid books, with their IDs unless DryRun is set. They
+	// are added, or would be added by a dry run, in the order of the
+	// catalog.
+	Books []*Book
+	// Errors are the rows that were not imported, in the order of the
+	// catalog.
+	Errors []*RowError
+}
+
+// RowError describes why a row of a catalog was not imported.
+type RowError struct {
+	// Row is the 1-based number of the book in the catalog, not counting
+	// the header row of a CSV catalog.
+	Row int
+	// Field is the invalid field, if the error is about a single field.
+	Field   string
+	Message string
+}
+
+func (e *RowError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("row %d: %s: %s", e.Row, e.Field, e.Message)
+	}
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// catalogReader reads the books of a catalog in one of the formats. next
+// returns io.EOF at the end of the catalog, and a *RowError, after which
+// reading may continue, if a single row cannot be read.
+type catalogReader interface {
+	next() (*Book, error)
+}
+
+// csvCatalogReader reads a CSV catalog whose header names a subset of
+// catalogColumns, in any order.
+type csvCatalogReader struct {
+	r       *csv.Reader
+	columns []string
+	row     int
+}
+
+func newCSVCatalogReader(r io.Reader) (*csvCatalogReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("bookshelf: the catalog is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bookshelf: could not read the header row: %v", err)
+	}
+
+	known := make(map[string]string)
+	for _, c := range catalogColumns {
+		known[strings.ToLower(c)] = c
+	}
+	seen := make(map[string]bool)
+	for i, h := range header {
+		c, ok := known[strings.ToLower(strings.TrimSpace(h))]
+		if !ok {
+			return nil, fmt.Errorf("bookshelf: unknown column %q in the header row", h)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("bookshelf: duplicate column %q in the header row", h)
+		}
+		seen[c] = true
+		header[i] = c
+	}
+	if !seen["title"] {
+		return nil, fmt.Errorf("bookshelf: the header row has no title column")
+	}
+	return &csvCatalogReader{r: cr, columns: header}, nil
+}
+
+func (c *csvCatalogReader) next() (*Book, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	c.row++
+	if err != nil {
+		if perr, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{Row: c.row, Message: perr.Err.Error()}
+		}
+		return nil, fmt.Errorf("bookshelf: could not read row %d: %v", c.row, err)
+	}
+
+	b := &Book{}
+	for i, v := range record {
+		switch c.columns[i] {
+		case "id":
+			if v != "" {
+				if b.ID, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return nil, &RowError{Row: c.row, Field: "id", Message: "not an integer"}
+				}
+			}
+		case "title":
+			b.Title = v
+		case "author":
+			b.Author = v
+		case "publishedDate":
+			b.PublishedDate = v
+		case "imageUrl":
+			b.ImageURL = v
+		case "description":
+			b.Description = v
+		case "isbn":
+			b.ISBN = v
+		case "createdBy":
+			b.CreatedBy = v
+		case "createdById":
+			b.CreatedByID = v
+		case "version":
+			if v != "" {
+				if b.Version, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return nil, &RowError{Row: c.row, Field: "version", Message: "not an integer"}
+				}
+			}
+		}
+	}
+	return b, nil
+}
+
+// jsonCatalogReader reads a JSON array of books.
+type jsonCatalogReader struct {
+	dec *json.Decoder
+	row int
+}
+
+func newJSONCatalogReader(r io.Reader) (*jsonCatalogReader, error) {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err == io.EOF {
+		return nil, fmt.Errorf("bookshelf: the catalog is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bookshelf: could not parse the catalog: %v", err)
+	}
+	if t != json.Delim('[') {
+		return nil, fmt.Errorf("bookshelf: the catalog is not a JSON array of books")
+	}
+	return &jsonCatalogReader{dec: dec}, nil
+}
+
+func (j *jsonCatalogReader) next() (*Book, error) {
+	if !j.dec.More() {
+		if _, err := j.dec.Token(); err != nil {
+			return nil, fmt.Errorf("bookshelf: could not parse the end of the catalog: %v", err)
+		}
+		return nil, io.EOF
+	}
+	j.row++
+	b := &Book{}
+	if err := j.dec.Decode(b); err != nil {
+		// The decoder skips a value of the wrong type, but cannot recover
+		// from a syntax error.
+		if terr, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, &RowError{Row: j.row, Field: terr.Field, Message: "expected a " + terr.Type.String()}
+		}
+		return nil, fmt.Errorf("bookshelf: could not parse row %d: %v", j.row, err)
+	}
+	return b, nil
+}
+
+// validateBook returns the first problem with the imported book b, or nil.
+// It normalizes the ISBN of b.
+func validateBook(row int, b *Book) *RowError {
+	b.Title = strings.TrimSpace(b.Title)
+	if b.Title == "" {
+		return &RowError{Row: row, Field: "title", Message: "is required"}
+	}
+	if b.ISBN = strings.TrimSpace(b.ISBN); b.ISBN != "" && !validISBN(normalizeISBN(b.ISBN)) {
+		return &RowError{Row: row, Field: "isbn", Message: fmt.Sprintf("%q is not a valid ISBN-10 or ISBN-13", b.ISBN)}
+	}
+	return nil
+}
+
+// validISBN reports whether isbn, normalized by normalizeISBN, is an ISBN-10
+// or ISBN-13 with a correct check digit.
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			d := int(r - '0')
+			switch {
+			case r == 'X' && i == 9:
+				d = 10
+			case r < '0' || r > '9':
+				return false
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	default:
+		return false
+	}
+}
+
+// ImportBooks adds the books in the catalog read from r, in the format
+// written by ExportBooks, to db. Every imported book is added as a new book:
+// the id and version of an exported catalog are ignored. Rows that cannot be
+// read or are invalid are skipped and reported, and the valid ones are
+// added, unless opts.DryRun is set.
+//
+// The import is traced by a span with a child span for each batch of rows,
+// annotated with the rows that were skipped. The error is only non-nil if
+// the whole catalog cannot be read, or a book cannot be added; the report
+// then describes the rows read so far.
+func ImportBooks(ctx context.Context, db BookDatabase, r io.Reader, format string, opts ImportOptions) (report *ImportReport, err error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/catalog.Import")
+	defer span.End()
+
+	report = &ImportReport{DryRun: opts.DryRun}
+	defer func() {
+		span.AddAttributes(
+			trace.StringAttribute("format", format),
+			trace.BoolAttribute("dry_run", opts.DryRun),
+			trace.Int64Attribute("rows", int64(report.Rows)),
+			trace.Int64Attribute("valid", int64(len(report.Books))),
+			trace.Int64Attribute("invalid", int64(len(report.Errors))))
+		if err != nil {
+			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		}
+	}()
+
+	var cr catalogReader
+	switch format {
+	case FormatCSV:
+		cr, err = newCSVCatalogReader(r)
+	case FormatJSON:
+		cr, err = newJSONCatalogReader(r)
+	default:
+		err = fmt.Errorf("bookshelf: unknown catalog format %q", format)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	for done := false; !done; {
+		done, err = importBatch(ctx, db, cr, opts, report)
+		span.Annotate([]trace.Attribute{
+			trace.Int64Attribute("rows", int64(report.Rows)),
+			trace.Int64Attribute("invalid", int64(len(report.Errors))),
+		}, "Imported a batch of rows")
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// importBatch imports up to importBatchSize rows read from cr in a span of
+// its own, and adds them to report. done is set once the whole catalog has
+// been read.
+func importBatch(ctx context.Context, db BookDatabase, cr catalogReader, opts ImportOptions, report *ImportReport) (done bool, err error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/catalog.ImportBatch")
+	defer span.End()
+
+	first, invalid := report.Rows+1, len(report.Errors)
+	defer func() {
+		span.AddAttributes(
+			trace.Int64Attribute("first_row", int64(first)),
+			trace.Int64Attribute("last_row", int64(report.Rows)),
+			trace.Int64Attribute("invalid", int64(len(report.Errors)-invalid)))
+		if err != nil {
+			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		}
+	}()
+
+	for report.Rows < first-1+importBatchSize {
+		b, err := cr.next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if rerr, ok := err.(*RowError); ok {
+			report.Rows++
+			report.Errors = append(report.Errors, rerr)
+			span.Annotate([]trace.Attribute{trace.Int64Attribute("row", int64(rerr.Row))}, rerr.Error())
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+		report.Rows++
+
+		if rerr := validateBook(report.Rows, b); rerr != nil {
+			report.Errors = append(report.Errors, rerr)
+			span.Annotate([]trace.Attribute{trace.Int64Attribute("row", int64(rerr.Row))}, rerr.Error())
+			continue
+		}
+		b.ID, b.Version = 0, 0
+		if opts.SetCreator != nil {
+			opts.SetCreator(b)
+		}
+		if b.CreatedByID == "" {
+			b.SetCreatorAnonymous()
+		}
+		if opts.DryRun {
+			report.Books = append(report.Books, b)
+			continue
+		}
+
+		id, err := db.AddBook(ctx, b)
+		if err != nil {
+			return true, fmt.Errorf("bookshelf: could not add row %d: %v", report.Rows, err)
+		}
+		b.ID = id
+		report.Books = append(report.Books, b)
+		if opts.Added != nil {
+			opts.Added(ctx, id)
+		}
+	}
+	return false, nil
+}
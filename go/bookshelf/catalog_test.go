@@ -0,0 +1,118 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCatalogRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newMemoryDB()
+	for i := 0; i < exportPageSize+1; i++ {
+		if _, err := src.AddBook(ctx, &Book{
+			Title:       strings.Repeat("a", i+1),
+			Author:      "Author, with a comma",
+			Description: "line one\nline two",
+			ISBN:        "978-0-13-468599-1",
+			CreatedBy:   "Ann",
+			CreatedByID: "u-1",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		var buf bytes.Buffer
+		n, err := ExportBooks(ctx, src, &buf, format)
+		if err != nil || n != exportPageSize+1 {
+			t.Fatalf("ExportBooks(%s) = %d, %v; want %d books", format, n, err, exportPageSize+1)
+		}
+
+		dst := newMemoryDB()
+		report, err := ImportBooks(ctx, dst, &buf, format, ImportOptions{})
+		if err != nil {
+			t.Fatalf("ImportBooks(%s): %v", format, err)
+		}
+		if report.Rows != n || len(report.Books) != n || len(report.Errors) != 0 {
+			t.Errorf("ImportBooks(%s) read %d rows, added %d books, errors %v; want %d books", format, report.Rows, len(report.Books), report.Errors, n)
+		}
+
+		want, _ := src.ListBooks(ctx)
+		got, _ := dst.ListBooks(ctx)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ImportBooks(%s) of the export: got books %v, want %v", format, got, want)
+		}
+	}
+}
+
+func TestImportBooksErrors(t *testing.T) {
+	const catalog = `Title,isbn
+Valid,0-306-40615-2
+,978-0-13-468599-1
+Bad ISBN,978-0-13-468599-2
+Too,many,fields
+Also valid,
+`
+	db := newMemoryDB()
+	var added []int64
+	opts := ImportOptions{
+		DryRun: true,
+		Added:  func(_ context.Context, id int64) { added = append(added, id) },
+	}
+
+	report, err := ImportBooks(context.Background(), db, strings.NewReader(catalog), FormatCSV, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []int
+	for _, e := range report.Errors {
+		rows = append(rows, e.Row)
+	}
+	if report.Rows != 5 || len(report.Books) != 2 || !reflect.DeepEqual(rows, []int{2, 3, 4}) {
+		t.Errorf("ImportBooks() = %d rows, %d books, errors %v; want 5 rows, 2 books, errors in rows 2-4", report.Rows, len(report.Books), report.Errors)
+	}
+	if books, _ := db.ListBooks(context.Background()); len(books) != 0 || len(added) != 0 {
+		t.Errorf("dry run added books %v", books)
+	}
+
+	opts.DryRun = false
+	if _, err := ImportBooks(context.Background(), db, strings.NewReader(catalog), FormatCSV, opts); err != nil {
+		t.Fatal(err)
+	}
+	if books, _ := db.ListBooks(context.Background()); len(books) != 2 || len(added) != 2 {
+		t.Errorf("import added books %v, and called Added with %v; want 2 books", books, added)
+	}
+
+	for _, bad := range []string{"author\nno title column\n", "title,color\nx,red\n", ""} {
+		if _, err := ImportBooks(context.Background(), db, strings.NewReader(bad), FormatCSV, opts); err == nil {
+			t.Errorf("ImportBooks(%q): got no error", bad)
+		}
+	}
+	if _, err := ImportBooks(context.Background(), db, strings.NewReader(`{"title": "not an array"}`), FormatJSON, opts); err == nil {
+		t.Error("ImportBooks of a JSON object: got no error")
+	}
+}
+
+func TestValidISBN(t *testing.T) {
+	for isbn, want := range map[string]bool{
+		"0306406152":    true,
+		"080442957X":    true,
+		"0306406153":    false,
+		"9780134685991": true,
+		"9780134685992": false,
+		"978013468599":  false,
+		"97801346859X1": false,
+	} {
+		if got := validISBN(isbn); got != want {
+			t.Errorf("validISBN(%q) = %v, want %v", isbn, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Command catalog_tool exports and imports the catalog of books in the
+// bookshelf database, as CSV or JSON, without going through the app. It is
+// configured like the app, with flags, environment variables or a
+// configuration file:
+//
+//	catalog_tool -db=sqlite -sqlite-path=bookshelf.db export -format=json > books.json
+//	catalog_tool -db=sqlite -sqlite-path=bookshelf.db import -dry-run books.json
+//
+// Imported books are published to the update queue, for the worker to look
+// up their details, if the queue is Pub/Sub.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+)
+
+// actor is who the audit log attributes imported books to.
+var actor = bookshelf.Actor{ID: "catalog_tool", Name: "Catalog tool"}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  %[1]s [flags] export [-format=csv|json] [file]
+  %[1]s [flags] import [-format=csv|json] [-dry-run] file
+
+The file is "-" or omitted for the standard input or output. The format of
+an imported file defaults to its extension.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	cfg, err := bookshelf.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+	ctx := bookshelf.WithActor(context.Background(), actor)
+	defer bookshelf.DB.Close(ctx)
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "export":
+		err = export(ctx, args)
+	case "import":
+		err = importBooks(ctx, cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		bookshelf.DB.Close(ctx)
+		log.Fatal(err)
+	}
+}
+
+// export writes the catalog to the file named by args, or the standard
+// output.
+func export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", bookshelf.FormatCSV, "catalog format: csv or json")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := bookshelf.ExportBooks(ctx, bookshelf.DB, w, *format)
+	log.Printf("Exported %d books.", n)
+	return err
+}
+
+// importBooks adds the books of the catalog in the file named by args, or
+// the standard input, and prints the rows that were skipped.
+func importBooks(ctx context.Context, cfg *bookshelf.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "catalog format: csv or json (default: the file's extension)")
+	dryRun := fs.Bool("dry-run", false, "only validate the catalog, without adding any books")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	name := fs.Arg(0)
+	if name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if *format == "" {
+		*format = strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+		if *format == "" {
+			return fmt.Errorf("use -format to give the format of %s", name)
+		}
+	}
+
+	opts := bookshelf.ImportOptions{DryRun: *dryRun}
+	// With the in-process queue, there is no worker to process the updates.
+	if cfg.Queue == "pubsub" {
+		opts.Added = func(ctx context.Context, id int64) {
+			if err := bookshelf.Updates.Publish(ctx, id); err != nil {
+				log.Printf("Could not publish update for Book ID %d: %v", id, err)
+			}
+		}
+	}
+
+	report, err := bookshelf.ImportBooks(ctx, bookshelf.DB, r, *format, opts)
+	for _, e := range report.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Would import"
+	}
+	log.Printf("%s %d of %d rows; %d skipped.", verb, len(report.Books), report.Rows, len(report.Errors))
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ChangeFeed notifies the processes sharing a database, such as the app and
+// the Pub/Sub worker, of the books changed by each of them, so that they can
+// invalidate their caches.
+type ChangeFeed interface {
+	// Publish notifies the other processes that the books with the given
+	// IDs have been added, changed or removed.
+	Publish(ctx context.Context, ids []int64) error
+
+	// Receive calls f with the IDs of the books changed by the other
+	// processes, until ctx is done or receiving fails. Changes published
+	// before Receive is called may be missed.
+	Receive(ctx context.Context, f func(ctx context.Context, ids []int64)) error
+
+	// Close releases the resources of the feed.
+	Close() error
+}
+
+// notifyingDB decorates a BookDatabase by publishing the books changed
+// through it to a ChangeFeed.
+type notifyingDB struct {
+	BookDatabase
+	feed ChangeFeed
+}
+
+// NotifyingDB returns a BookDatabase that publishes the IDs of the books
+// added, changed or removed through it to feed. Failing to publish is
+// logged, but does not fail the change, since it has been made by then.
+func NotifyingDB(db BookDatabase, feed ChangeFeed) BookDatabase {
+	return &notifyingDB{BookDatabase: db, feed: feed}
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *notifyingDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	id, err := db.BookDatabase.AddBook(ctx, b)
+	if err == nil {
+		db.publish(ctx, id)
+	}
+	return id, err
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *notifyingDB) UpdateBook(ctx context.Context, b *Book) error {
+	err := db.BookDatabase.UpdateBook(ctx, b)
+	if err == nil {
+		db.publish(ctx, b.ID)
+	}
+	return err
+}
+
+// DeleteBook moves a given book to the trash.
+func (db *notifyingDB) DeleteBook(ctx context.Context, id int64) error {
+	err := db.BookDatabase.DeleteBook(ctx, id)
+	if err == nil {
+		db.publish(ctx, id)
+	}
+	return err
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *notifyingDB) RestoreBook(ctx context.Context, id int64) error {
+	err := db.BookDatabase.RestoreBook(ctx, id)
+	if err == nil {
+		db.publish(ctx, id)
+	}
+	return err
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *notifyingDB) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	ids, err := db.BookDatabase.PurgeTrash(ctx, before)
+	if len(ids) > 0 {
+		db.publish(ctx, ids...)
+	}
+	return ids, err
+}
+
+// publish publishes a change, logging failures.
+func (db *notifyingDB) publish(ctx context.Context, ids ...int64) {
+	if err := db.feed.Publish(ctx, ids); err != nil {
+		log.Printf("Could not publish the change of books %v; other processes may serve them stale: %v", ids, err)
+	}
+}
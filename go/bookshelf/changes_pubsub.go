@@ -0,0 +1,138 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// PubsubChangesTopicID is the topic the IDs of changed books are published
+// to. Each receiving process has its own subscription to it.
+const PubsubChangesTopicID = "book-changes"
+
+// originAttribute identifies the process that published a change, so that
+// it can ignore its own changes.
+const originAttribute = "origin"
+
+// pubsubChangeFeed is a ChangeFeed using Cloud Pub/Sub.
+type pubsubChangeFeed struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+	// origin identifies this process, and names its subscription.
+	origin string
+
+	mu  sync.Mutex
+	sub *pubsub.Subscription // created by Receive, and deleted by Close.
+}
+
+// Ensure pubsubChangeFeed conforms to the ChangeFeed interface.
+var _ ChangeFeed = &pubsubChangeFeed{}
+
+// newPubsubChangeFeed returns a ChangeFeed that publishes to
+// PubsubChangesTopicID in the given project, creating the topic if it
+// doesn't exist.
+func newPubsubChangeFeed(projectID string) (*pubsubChangeFeed, error) {
+	ctx := context.Background()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("pubsub: could not generate origin: %v", err)
+	}
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: could not create client: %v", err)
+	}
+	topic, err := ensureTopic(ctx, client, PubsubChangesTopicID)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &pubsubChangeFeed{client: client, topic: topic, origin: hex.EncodeToString(b)}, nil
+}
+
+// Publish publishes the IDs to the topic, and waits for them to be accepted.
+func (f *pubsubChangeFeed) Publish(ctx context.Context, ids []int64) (err error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/pubsub.PublishChange")
+	span.AddAttributes(trace.Int64Attribute("books", int64(len(ids))))
+	defer func() {
+		if err != nil {
+			span.SetStatus(trace.Status{Code: KindOf(err).TraceCode(), Message: err.Error()})
+		}
+		span.End()
+	}()
+
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("pubsub: could not encode change: %v", err)
+	}
+	msg := &pubsub.Message{Data: b, Attributes: map[string]string{originAttribute: f.origin}}
+	if _, err := f.topic.Publish(ctx, msg).Get(ctx); err != nil {
+		return WrapErrorf(err, "pubsub: could not publish change of books %v: %v", ids, err)
+	}
+	return nil
+}
+
+// Receive creates a subscription of this process to the topic, and receives
+// the changes published by the others from it. Changes are only useful
+// until the cached books expire, so the subscription keeps the undelivered
+// ones for the shortest time Pub/Sub allows.
+func (f *pubsubChangeFeed) Receive(ctx context.Context, fn func(context.Context, []int64)) error {
+	sub, err := f.client.CreateSubscription(ctx, PubsubChangesTopicID+"-"+f.origin, pubsub.SubscriptionConfig{
+		Topic:             f.topic,
+		RetentionDuration: 10 * time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("pubsub: could not create subscription: %v", err)
+	}
+	f.mu.Lock()
+	f.sub = sub
+	f.mu.Unlock()
+
+	err = sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		// Invalidating is idempotent, so changes are acknowledged even
+		// if they are redelivered.
+		msg.Ack()
+		if msg.Attributes[originAttribute] == f.origin {
+			return
+		}
+		var ids []int64
+		if err := json.Unmarshal(msg.Data, &ids); err != nil {
+			log.Printf("could not decode change data: %#v", msg)
+			return
+		}
+		fn(ctx, ids)
+	})
+	if err != nil {
+		return fmt.Errorf("pubsub: could not receive changes: %v", err)
+	}
+	return nil
+}
+
+// Close deletes the subscription of this process, if any, and closes the
+// Pub/Sub client.
+func (f *pubsubChangeFeed) Close() error {
+	f.topic.Stop()
+	f.mu.Lock()
+	sub := f.sub
+	f.mu.Unlock()
+	if sub != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := sub.Delete(ctx); err != nil {
+			log.Printf("Could not delete subscription %s: %v", sub.ID(), err)
+		}
+	}
+	return f.client.Close()
+}
@@ -5,223 +5,476 @@
 package bookshelf
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/datastore"
-	"cloud.google.com/go/pubsub"
-	"cloud.google.com/go/storage"
 
 	"gopkg.in/mgo.v2"
 
+	"github.com/coreos/go-oidc"
 	"github.com/gorilla/sessions"
 
 	"contrib.go.opencensus.io/exporter/stackdriver"
+	"github.com/census-ecosystem/opencensus-experiments/go/dbtrace"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
+// The following are set by Configure.
 var (
-	DB          BookDatabase
-	OAuthConfig *oauth2.Config
+	DB BookDatabase
+	// Cache is the cache DB reads books through, or nil if caching is
+	// disabled.
+	Cache *BookCache
+	// TrashRetention is how long deleted books stay in the trash.
+	TrashRetention time.Duration
+	// Audit holds the change history of the books in DB, which records
+	// every change made through it.
+	Audit AuditLog
 
-	StorageBucket     *storage.BucketHandle
-	StorageBucketName string
+	OAuthConfig *oauth2.Config
+	// OIDCVerifier verifies the ID tokens returned with OAuthConfig's
+	// tokens.
+	OIDCVerifier *oidc.IDTokenVerifier
+	// FakeOIDC serves the fake OpenID Connect provider at FakeOIDCPath, if
+	// it is selected.
+	FakeOIDC http.Handler
+	// Admins are the IDs or verified email addresses of the users who may
+	// modify any book.
+	Admins []string
+
+	Images ImageStore
+	// ImageHandler serves the stored images at LocalImagePath, if they are
+	// not served elsewhere.
+	ImageHandler http.Handler
 
 	SessionStore sessions.Store
 
-	PubsubClient *pubsub.Client
-
-	// Force import of mgo library.
-	_ mgo.Session
+	Updates UpdateQueue
+	// Changes notifies the processes sharing DB of the books changed by
+	// each of them, or is nil if DB is not shared.
+	Changes ChangeFeed
 )
 
-const PubsubTopicID = "fill-book-details"
-const projectID = "bookshelf-195421"
+// Config selects and configures the services used by bookshelf. See
+// LoadConfig for how it is populated, and the setting table in
+// Config.settings for the meaning of each field.
+type Config struct {
+	ProjectID string
+	Exporter  string
+
+	DB               string
+	MySQLAddr        string
+	MySQLUser        string
+	MySQLPassword    string
+	CloudSQLInstance string
+	SQLitePath       string
+	MongoAddr        string
+	MongoUser        string
+	MongoPassword    string
+	TrashRetention   string
+	CacheSize        string
+	CacheTTL         string
+
+	Storage       string
+	StorageBucket string
+	ImageDir      string
+
+	Queue string
+
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthRedirectURL  string
+	OIDCIssuer        string
+	Admins            string
+
+	SessionSecret string
+}
+
+// setting describes how a Config field is set from a flag, an environment
+// variable or a configuration file key (the flag name).
+type setting struct {
+	flag, env, usage string
+	value            *string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"project-id", "BOOKSHELF_PROJECT_ID", "Google Cloud project ID", &c.ProjectID},
+		{"exporter", "BOOKSHELF_EXPORTER", `where to export traces and stats: "stackdriver" or "none" (default "stackdriver" if -project-id is set)`, &c.Exporter},
+
+		{"db", "BOOKSHELF_DB", `book database: "datastore", "cloudsql", "mysql", "sqlite", "mongo" or "memory" (default "datastore" if -project-id is set, otherwise "memory")`, &c.DB},
+		{"mysql-addr", "BOOKSHELF_MYSQL_ADDR", "MySQL host:port, for -db=mysql", &c.MySQLAddr},
+		{"mysql-user", "BOOKSHELF_MYSQL_USER", "MySQL user name, for -db=mysql or cloudsql", &c.MySQLUser},
+		{"mysql-password", "BOOKSHELF_MYSQL_PASSWORD", "MySQL password, for -db=mysql or cloudsql", &c.MySQLPassword},
+		{"cloudsql-instance", "BOOKSHELF_CLOUDSQL_INSTANCE", `Cloud SQL v2 instance connection name, "project:region:instance-id", for -db=cloudsql`, &c.CloudSQLInstance},
+		{"sqlite-path", "BOOKSHELF_SQLITE_PATH", "SQLite database file, created if needed, for -db=sqlite", &c.SQLitePath},
+		{"mongo-addr", "BOOKSHELF_MONGO_ADDR", "MongoDB address, for -db=mongo", &c.MongoAddr},
+		{"mongo-user", "BOOKSHELF_MONGO_USER", "MongoDB user name, if authentication is needed", &c.MongoUser},
+		{"mongo-password", "BOOKSHELF_MONGO_PASSWORD", "MongoDB password, if authentication is needed", &c.MongoPassword},
+		{"trash-retention", "BOOKSHELF_TRASH_RETENTION", `how long deleted books can be restored before they are purged, e.g. "720h" (default 30 days)`, &c.TrashRetention},
+		{"cache-size", "BOOKSHELF_CACHE_SIZE", "number of books and lists of books cached in memory, or 0 to disable the cache (default 1000)", &c.CacheSize},
+		{"cache-ttl", "BOOKSHELF_CACHE_TTL", `how long books are cached, e.g. "1m"; changes made by other processes, such as the Pub/Sub worker, are invalidated as they are published, and show after at most this long if that fails (default 30s)`, &c.CacheTTL},
+
+		{"storage", "BOOKSHELF_STORAGE", `image storage: "gcs", "local" or "none" (default "gcs" if -storage-bucket is set, otherwise "local")`, &c.Storage},
+		{"storage-bucket", "BOOKSHELF_STORAGE_BUCKET", "Cloud Storage bucket for images, for -storage=gcs", &c.StorageBucket},
+		{"image-dir", "BOOKSHELF_IMAGE_DIR", `directory for images, created if needed, for -storage=local (default "images")`, &c.ImageDir},
+
+		{"queue", "BOOKSHELF_QUEUE", `book update queue: "pubsub", "memory" (the app runs the worker itself) or "none" (default "pubsub" if -project-id is set and -db is not "memory", otherwise "memory")`, &c.Queue},
+
+		{"oauth-client-id", "BOOKSHELF_OAUTH_CLIENT_ID", "OAuth client ID; enables user sign-in", &c.OAuthClientID},
+		{"oidc-issuer", "BOOKSHELF_OIDC_ISSUER", `OpenID Connect issuer URL users sign in with, or "fake" for a built-in provider that signs in anyone, for development; enables user sign-in (default "https://accounts.google.com" if -oauth-client-id is set)`, &c.OIDCIssuer},
+		{"oauth-client-secret", "BOOKSHELF_OAUTH_CLIENT_SECRET", "OAuth client secret", &c.OAuthClientSecret},
+		{"oauth-redirect-url", "OAUTH2_CALLBACK", "OAuth redirect URL", &c.OAuthRedirectURL},
+		{"admins", "BOOKSHELF_ADMINS", "comma-separated IDs or verified email addresses of users who may modify any book", &c.Admins},
+
+		{"session-secret", "BOOKSHELF_SESSION_SECRET", "key used to authenticate session cookies (default: random, so sessions do not survive restarts)", &c.SessionSecret},
+	}
+}
+
+// LoadConfig returns the configuration given by, in increasing order of
+// precedence: the defaults, the JSON file named by the -config flag or the
+// BOOKSHELF_CONFIG environment variable, environment variables and
+// command-line flags. It adds a flag for each setting to fs, and parses args
+// with it.
+//
+// The configuration file is a JSON object whose keys are flag names, e.g.
+//
+//	{"db": "sqlite", "sqlite-path": "bookshelf.db"}
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := &Config{}
+	settings := c.settings()
+
+	file := fs.String("config", os.Getenv("BOOKSHELF_CONFIG"), "JSON configuration file")
+	flags := make(map[string]*string)
+	for _, s := range settings {
+		flags[s.flag] = fs.String(s.flag, "", s.usage+" [$"+s.env+"]")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		b, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return nil, fmt.Errorf("config: could not read %s: %v", *file, err)
+		}
+		values := make(map[string]string)
+		if err := json.Unmarshal(b, &values); err != nil {
+			return nil, fmt.Errorf("config: could not parse %s: %v", *file, err)
+		}
+		for _, s := range settings {
+			if v, ok := values[s.flag]; ok {
+				*s.value = v
+				delete(values, s.flag)
+			}
+		}
+		for k := range values {
+			return nil, fmt.Errorf("config: unknown setting %q in %s", k, *file)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			*s.value = v
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range settings {
+		if set[s.flag] {
+			*s.value = *flags[s.flag]
+		}
+	}
+
+	c.setDefaults()
+	return c, nil
+}
+
+// setDefaults fills in the settings whose defaults depend on other settings.
+func (c *Config) setDefaults() {
+	if c.Exporter == "" && c.ProjectID != "" {
+		c.Exporter = "stackdriver"
+	}
+	if c.DB == "" {
+		c.DB = "memory"
+		if c.ProjectID != "" {
+			c.DB = "datastore"
+		}
+	}
+	if c.Storage == "" {
+		c.Storage = "local"
+		if c.StorageBucket != "" {
+			c.Storage = "gcs"
+		}
+	}
+	if c.Queue == "" {
+		c.Queue = "memory"
+		if c.ProjectID != "" && c.DB != "memory" {
+			c.Queue = "pubsub"
+		}
+	}
+	if c.OAuthRedirectURL == "" {
+		c.OAuthRedirectURL = "http://localhost:8080/oauth2callback"
+	}
+	if c.OIDCIssuer == "" && c.OAuthClientID != "" {
+		c.OIDCIssuer = "https://accounts.google.com"
+	}
+}
 
-func init() {
+// Configure sets up the exporters and the package variables (DB,
+// SessionStore, etc) according to cfg. It must be called before the
+// variables are used.
+func Configure(cfg *Config) error {
 	var err error
 
-	exporter, err := stackdriver.NewExporter(stackdriver.Options{ProjectID: projectID})
-	if err != nil {
-		log.Fatal(err)
+	switch cfg.Exporter {
+	case "stackdriver":
+		exporter, err := stackdriver.NewExporter(stackdriver.Options{ProjectID: cfg.ProjectID})
+		if err != nil {
+			return fmt.Errorf("config: could not create Stackdriver exporter: %v", err)
+		}
+		trace.RegisterExporter(exporter)
+		view.RegisterExporter(exporter)
+		log.Printf("installed opencensus trace exporter")
+	case "", "none":
+	default:
+		return fmt.Errorf("config: unknown exporter %q", cfg.Exporter)
 	}
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-	trace.RegisterExporter(exporter)
-
-	view.RegisterExporter(exporter)
 
 	// register to views
 	view.Register(ochttp.DefaultServerViews...)
 	view.Register(ochttp.DefaultClientViews...)
 	view.Register(ocgrpc.DefaultServerViews...)
 	view.Register(ocgrpc.DefaultClientViews...)
-
-	log.Printf("installed opencensus trace exporter")
-
-	span := trace.NewSpan("test-span-"+os.Args[0], nil, trace.StartOptions{})
-	span.End()
-
-	// To use the in-memory test database, uncomment the next line.
-	//DB = newMemoryDB()
-
-	// [START cloudsql]
-	// To use Cloud SQL, uncomment the following lines, and update the username,
-	// password and instance connection string. When running locally,
-	// localhost:3306 is used, and the instance name is ignored.
-	// DB, err = configureCloudSQL(cloudSQLConfig{
-	// 	Username: "root",
-	// 	Password: "",
-	// 	// The connection name of the Cloud SQL v2 instance, i.e.,
-	// 	// "project:region:instance-id"
-	// 	// Cloud SQL v1 instances are not supported.
-	// 	Instance: "",
-	// })
-	// [END cloudsql]
-
-	// [START mongo]
-	// To use Mongo, uncomment the next lines and update the address string and
-	// optionally, the credentials.
-	//
-	// var cred *mgo.Credential
-	// DB, err = newMongoDB("localhost", cred)
-	// [END mongo]
-
-	// [START datastore]
-	// To use Cloud Datastore, uncomment the following lines and update the
-	// project ID.
-	// More options can be set, see the google package docs for details:
-	// http://godoc.org/golang.org/x/oauth2/google
-	//
-	DB, err = configureDatastoreDB(projectID)
-	// [END datastore]
-
+	view.Register(
+		dbtrace.QueryTime.Distribution,
+		dbtrace.ExecTime.Distribution,
+		dbtrace.RowsPerQuery,
+		dbtrace.RowsAffected,
+	)
+	view.Register(DefaultDBViews...)
+	view.Register(DefaultImageViews...)
+	view.Register(DefaultWorkerViews...)
+	view.Register(DefaultAuthzViews...)
+	view.Register(DefaultHealthViews...)
+	view.Register(DefaultCacheViews...)
+
+	db, err := configureDB(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	// [START storage]
-	// To configure Cloud Storage, uncomment the following lines and update the
-	// bucket name.
-	//
-	StorageBucketName = projectID
-	StorageBucket, err = configureStorage(StorageBucketName)
-	// [END storage]
-
+	TrashRetention = DefaultTrashRetention
+	if cfg.TrashRetention != "" {
+		if TrashRetention, err = time.ParseDuration(cfg.TrashRetention); err != nil {
+			return fmt.Errorf("config: bad trash retention %q: %v", cfg.TrashRetention, err)
+		}
+	}
+	Audit, err = newAuditLog(db)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// Trace every database call and record its latency, whichever backend
+	// is configured.
+	DB = InstrumentedDB(AuditedDB(db, Audit))
+
+	switch cfg.Storage {
+	case "gcs":
+		store, err := newGCSImageStore(cfg.StorageBucket)
+		if err != nil {
+			return fmt.Errorf("config: could not configure Cloud Storage: %v", err)
+		}
+		Images = InstrumentedImageStore(store)
+	case "local":
+		dir := cfg.ImageDir
+		if dir == "" {
+			dir = "images"
+		}
+		store, err := newLocalImageStore(dir)
+		if err != nil {
+			return fmt.Errorf("config: could not configure local image storage: %v", err)
+		}
+		Images = InstrumentedImageStore(store)
+		ImageHandler = store
+	case "none":
+	default:
+		return fmt.Errorf("config: unknown storage %q", cfg.Storage)
 	}
 
-	// [START auth]
-	// To enable user sign-in, uncomment the following lines and update the
-	// Client ID and Client Secret.
-	// You will also need to update OAUTH2_CALLBACK in app.yaml when pushing to
-	// production.
-	//
-	// OAuthConfig = configureOAuthClient("clientid", "clientsecret")
-	// [END auth]
+	if cfg.OIDCIssuer != "" {
+		if err := configureOIDC(cfg); err != nil {
+			return err
+		}
+	}
+	Admins = nil
+	for _, a := range strings.Split(cfg.Admins, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			Admins = append(Admins, a)
+		}
+	}
 
-	// [START sessions]
 	// Configure storage method for session-wide information.
-	// Update "something-very-secret" with a hard to guess string or byte sequence.
-	cookieStore := sessions.NewCookieStore([]byte("something-very-secret"))
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		log.Printf("No session secret is configured; using a random one. Sessions will not survive restarts.")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("config: could not generate session secret: %v", err)
+		}
+	}
+	cookieStore := sessions.NewCookieStore(secret)
 	cookieStore.Options = &sessions.Options{
 		HttpOnly: true,
 	}
 	SessionStore = cookieStore
-	// [END sessions]
 
-	// [START pubsub]
-	// To configure Pub/Sub, uncomment the following lines and update the project ID.
-	//
-	PubsubClient, err = configurePubsub(projectID)
-	// [END pubsub]
-
-	if err != nil {
-		log.Fatal(err)
+	switch cfg.Queue {
+	case "pubsub":
+		if cfg.DB == "memory" {
+			return errors.New("config: Pub/Sub worker doesn't work with the in-memory DB " +
+				"(worker does not share its memory as the main app). Configure another " +
+				"database first (e.g. MySQL, Cloud Datastore, etc)")
+		}
+		if Updates, err = newPubsubQueue(cfg.ProjectID); err != nil {
+			return fmt.Errorf("config: could not configure Pub/Sub: %v", err)
+		}
+	case "memory":
+		Updates = newMemoryQueue()
+	case "none":
+	default:
+		return fmt.Errorf("config: unknown queue %q", cfg.Queue)
 	}
-}
 
-func configureDatastoreDB(projectID string) (BookDatabase, error) {
-	ctx := context.Background()
-	client, err := datastore.NewClient(ctx, projectID)
-	if err != nil {
-		return nil, err
+	// With the Pub/Sub queue, the worker changes the books in another
+	// process, so every process publishes its changes for the others to
+	// invalidate their caches.
+	Changes = nil
+	if cfg.Queue == "pubsub" {
+		if Changes, err = newPubsubChangeFeed(cfg.ProjectID); err != nil {
+			return fmt.Errorf("config: could not configure Pub/Sub: %v", err)
+		}
+		DB = NotifyingDB(DB, Changes)
 	}
-	return newDatastoreDB(client)
-}
-
-func configureStorage(bucketID string) (*storage.BucketHandle, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
+	// Reads are cached in front of the instrumentation, so that only the
+	// misses are recorded as database calls.
+	if Cache, err = configureCache(cfg, DB); err != nil {
+		return err
+	}
+	if Cache != nil {
+		DB = Cache
 	}
-	return client.Bucket(bucketID), nil
-}
 
-func configurePubsub(projectID string) (*pubsub.Client, error) {
-	//if _, ok := DB.(*memoryDB); ok {
-	//	return nil, errors.New("Pub/Sub worker doesn't work with the in-memory DB " +
-	//		"(worker does not share its memory as the main app). Configure another " +
-	//		"database in bookshelf/config.go first (e.g. MySQL, Cloud Datastore, etc)")
-	//}
+	return nil
+}
 
-	ctx := context.Background()
-	client, err := pubsub.NewClient(ctx, projectID)
-	if err != nil {
-		return nil, err
+// configureCache returns the BookCache of db configured by cfg, or nil if
+// caching is disabled.
+func configureCache(cfg *Config, db BookDatabase) (*BookCache, error) {
+	size := DefaultCacheSize
+	if cfg.CacheSize != "" {
+		var err error
+		if size, err = strconv.Atoi(cfg.CacheSize); err != nil || size < 0 {
+			return nil, fmt.Errorf("config: bad cache size %q", cfg.CacheSize)
+		}
 	}
-
-	// Create the topic if it doesn't exist.
-	if exists, err := client.Topic(PubsubTopicID).Exists(ctx); err != nil {
-		return nil, err
-	} else if !exists {
-		if _, err := client.CreateTopic(ctx, PubsubTopicID); err != nil {
-			return nil, err
+	ttl := DefaultCacheTTL
+	if cfg.CacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cfg.CacheTTL); err != nil {
+			return nil, fmt.Errorf("config: bad cache TTL %q: %v", cfg.CacheTTL, err)
 		}
 	}
-	return client, nil
+	if size == 0 || ttl <= 0 {
+		return nil, nil
+	}
+	return NewBookCache(db, size, ttl), nil
 }
 
-func configureOAuthClient(clientID, clientSecret string) *oauth2.Config {
-	redirectURL := os.Getenv("OAUTH2_CALLBACK")
-	if redirectURL == "" {
-		redirectURL = "http://localhost:8080/oauth2callback"
-	}
-	return &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes:       []string{"email", "profile"},
-		Endpoint:     google.Endpoint,
+// configureDB returns the BookDatabase selected by cfg.DB.
+func configureDB(cfg *Config) (BookDatabase, error) {
+	switch cfg.DB {
+	case "memory":
+		return newMemoryDB(), nil
+
+	case "datastore":
+		return configureDatastoreDB(cfg.ProjectID)
+
+	case "cloudsql":
+		// The connection name of the Cloud SQL v2 instance, i.e.,
+		// "project:region:instance-id"
+		// Cloud SQL v1 instances are not supported.
+		return newMySQLDB(MySQLConfig{
+			Username:   cfg.MySQLUser,
+			Password:   cfg.MySQLPassword,
+			UnixSocket: "/cloudsql/" + cfg.CloudSQLInstance,
+		})
+
+	case "mysql":
+		addr := cfg.MySQLAddr
+		if addr == "" {
+			addr = "localhost:3306"
+		}
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("config: bad MySQL address %q: %v", addr, err)
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("config: bad MySQL port %q: %v", p, err)
+		}
+		return newMySQLDB(MySQLConfig{
+			Username: cfg.MySQLUser,
+			Password: cfg.MySQLPassword,
+			Host:     host,
+			Port:     port,
+		})
+
+	case "sqlite":
+		path := cfg.SQLitePath
+		if path == "" {
+			path = "bookshelf.db"
+		}
+		return newSQLiteDB(path)
+
+	case "mongo":
+		addr := cfg.MongoAddr
+		if addr == "" {
+			addr = "localhost"
+		}
+		var cred *mgo.Credential
+		if cfg.MongoUser != "" {
+			cred = &mgo.Credential{Username: cfg.MongoUser, Password: cfg.MongoPassword}
+		}
+		return newMongoDB(addr, cred)
+
+	default:
+		return nil, fmt.Errorf("config: unknown db %q", cfg.DB)
 	}
 }
 
-type cloudSQLConfig struct {
-	Username, Password, Instance string
+func configureDatastoreDB(projectID string) (BookDatabase, error) {
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return newDatastoreDB(client)
 }
-
-//func configureCloudSQL(config cloudSQLConfig) (BookDatabase, error) {
-//	if os.Getenv("GAE_INSTANCE") != "" {
-//		// Running in production.
-//		return newMySQLDB(MySQLConfig{
-//			Username:   config.Username,
-//			Password:   config.Password,
-//			UnixSocket: "/cloudsql/" + config.Instance,
-//		})
-//	}
-//
-//	// Running locally.
-//	return newMySQLDB(MySQLConfig{
-//		Username: config.Username,
-//		Password: config.Password,
-//		Host:     "localhost",
-//		Port:     3306,
-//	})
-//}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bookshelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(file, []byte(`{"db": "mysql", "mysql-addr": "file:3306", "mysql-user": "file"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"BOOKSHELF_CONFIG":     file,
+		"BOOKSHELF_MYSQL_ADDR": "env:3306",
+		"BOOKSHELF_MYSQL_USER": "env",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := LoadConfig(fs, []string{"-mysql-user", "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.DB, "mysql"; got != want {
+		t.Errorf("DB = %q, want %q from the file", got, want)
+	}
+	if got, want := cfg.MySQLAddr, "env:3306"; got != want {
+		t.Errorf("MySQLAddr = %q, want %q from the environment", got, want)
+	}
+	if got, want := cfg.MySQLUser, "flag"; got != want {
+		t.Errorf("MySQLUser = %q, want %q from the flag", got, want)
+	}
+	if got, want := cfg.Queue, "memory"; got != want {
+		t.Errorf("Queue = %q, want default %q", got, want)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := LoadConfig(fs, []string{"-project-id", "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB != "datastore" || cfg.Queue != "pubsub" || cfg.Exporter != "stackdriver" || cfg.Storage != "local" {
+		t.Errorf("LoadConfig(-project-id p) = %+v, want Cloud services by default", cfg)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	if cfg, err = LoadConfig(fs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB != "memory" || cfg.Queue != "memory" || cfg.Exporter != "" {
+		t.Errorf("LoadConfig() = %+v, want local services by default", cfg)
+	}
+}
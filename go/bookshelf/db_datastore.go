@@ -5,15 +5,19 @@
 package bookshelf
 
 import (
-	"fmt"
+	"sort"
+	"time"
 
 	"cloud.google.com/go/datastore"
 
 	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
 )
 
 // datastoreDB persists books to Cloud Datastore.
 // https://cloud.google.com/datastore/docs/concepts/overview
+// Trashed books are moved to the TrashedBook kind, with the same ID, so that
+// the queries of Book entities need not filter them out.
 type datastoreDB struct {
 	client *datastore.Client
 }
@@ -29,10 +33,10 @@ func newDatastoreDB(client *datastore.Client) (BookDatabase, error) {
 	// Verify that we can communicate and authenticate with the datastore service.
 	t, err := client.NewTransaction(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("datastoredb: could not connect: %v", err)
+		return nil, WrapErrorf(err, "datastoredb: could not connect: %v", err)
 	}
 	if err := t.Rollback(); err != nil {
-		return nil, fmt.Errorf("datastoredb: could not connect: %v", err)
+		return nil, WrapErrorf(err, "datastoredb: could not connect: %v", err)
 	}
 	return &datastoreDB{
 		client: client,
@@ -48,12 +52,19 @@ func (db *datastoreDB) datastoreKey(id int64) *datastore.Key {
 	return datastore.IDKey("Book", id, nil)
 }
 
+func (db *datastoreDB) trashKey(id int64) *datastore.Key {
+	return datastore.IDKey("TrashedBook", id, nil)
+}
+
 // GetBook retrieves a book by its ID.
 func (db *datastoreDB) GetBook(ctx context.Context, id int64) (*Book, error) {
 	k := db.datastoreKey(id)
 	book := &Book{}
 	if err := db.client.Get(ctx, k, book); err != nil {
-		return nil, fmt.Errorf("datastoredb: could not get Book: %v", err)
+		if err == datastore.ErrNoSuchEntity {
+			return nil, ErrNoSuchBook
+		}
+		return nil, WrapErrorf(err, "datastoredb: could not get Book: %v", err)
 	}
 	book.ID = id
 	return book, nil
@@ -62,28 +73,147 @@ func (db *datastoreDB) GetBook(ctx context.Context, id int64) (*Book, error) {
 // AddBook saves a given book, assigning it a new ID.
 func (db *datastoreDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
 	k := datastore.IncompleteKey("Book", nil)
+	b.Version = 1
 	k, err = db.client.Put(ctx, k, b)
 	if err != nil {
-		return 0, fmt.Errorf("datastoredb: could not put Book: %v", err)
+		return 0, WrapErrorf(err, "datastoredb: could not put Book: %v", err)
 	}
 	return k.ID, nil
 }
 
-// DeleteBook removes a given book by its ID.
+// DeleteBook moves a given book to the trash.
 func (db *datastoreDB) DeleteBook(ctx context.Context, id int64) error {
-	k := db.datastoreKey(id)
-	if err := db.client.Delete(ctx, k); err != nil {
-		return fmt.Errorf("datastoredb: could not delete Book: %v", err)
+	// Keys with other IDs are invalid, rather than missing.
+	if id <= 0 {
+		return Errorf(KindInvalidArgument, "datastoredb: book with unassigned ID passed into deleteBook")
+	}
+	err := db.move(ctx, db.datastoreKey(id), db.trashKey(id), time.Now())
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchBook
+	}
+	if err != nil {
+		return WrapErrorf(err, "datastoredb: could not delete Book: %v", err)
 	}
 	return nil
 }
 
-// UpdateBook updates the entry for a given book.
+// move moves the book at from to to in a transaction, setting its TrashedAt.
+func (db *datastoreDB) move(ctx context.Context, from, to *datastore.Key, trashedAt time.Time) error {
+	_, err := db.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var book Book
+		if err := tx.Get(from, &book); err != nil {
+			return err
+		}
+		book.TrashedAt = trashedAt
+		if _, err := tx.Put(to, &book); err != nil {
+			return err
+		}
+		return tx.Delete(from)
+	})
+	return err
+}
+
+// ListTrash returns the books in the trash, most recently trashed first.
+func (db *datastoreDB) ListTrash(ctx context.Context, userID string) ([]*Book, error) {
+	books := make([]*Book, 0)
+	q := datastore.NewQuery("TrashedBook")
+	if userID != "" {
+		q = q.Filter("CreatedByID =", userID)
+	}
+	keys, err := db.client.GetAll(ctx, q, &books)
+	if err != nil {
+		return nil, WrapErrorf(err, "datastoredb: could not list trash: %v", err)
+	}
+	for i, k := range keys {
+		books[i].ID = k.ID
+	}
+	// Ordering in the query would need another composite index.
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].TrashedAt.After(books[j].TrashedAt)
+	})
+	return books, nil
+}
+
+// GetTrashedBook retrieves a book in the trash by its ID.
+func (db *datastoreDB) GetTrashedBook(ctx context.Context, id int64) (*Book, error) {
+	book := &Book{}
+	if err := db.client.Get(ctx, db.trashKey(id), book); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, ErrNoSuchBook
+		}
+		return nil, WrapErrorf(err, "datastoredb: could not get TrashedBook: %v", err)
+	}
+	book.ID = id
+	return book, nil
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *datastoreDB) RestoreBook(ctx context.Context, id int64) error {
+	err := db.move(ctx, db.trashKey(id), db.datastoreKey(id), time.Time{})
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchBook
+	}
+	if err != nil {
+		return WrapErrorf(err, "datastoredb: could not restore Book: %v", err)
+	}
+	return nil
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *datastoreDB) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	q := datastore.NewQuery("TrashedBook").Filter("TrashedAt <", before).KeysOnly()
+	keys, err := db.client.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, WrapErrorf(err, "datastoredb: could not list trash: %v", err)
+	}
+	var ids []int64
+	// DeleteMulti takes at most 500 keys.
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 500 {
+			n = 500
+		}
+		if err := db.client.DeleteMulti(ctx, keys[:n]); err != nil {
+			return ids, WrapErrorf(err, "datastoredb: could not purge trash: %v", err)
+		}
+		for _, k := range keys[:n] {
+			ids = append(ids, k.ID)
+		}
+		keys = keys[n:]
+	}
+	return ids, nil
+}
+
+// UpdateBook updates the entry for a given book, checking its version in a
+// transaction.
 func (db *datastoreDB) UpdateBook(ctx context.Context, b *Book) error {
+	if b.ID <= 0 {
+		return Errorf(KindInvalidArgument, "datastoredb: book with unassigned ID passed into updateBook")
+	}
 	k := db.datastoreKey(b.ID)
-	if _, err := db.client.Put(ctx, k, b); err != nil {
-		return fmt.Errorf("datastoredb: could not update Book: %v", err)
+	book := *b
+	book.Version++
+	_, err := db.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var old Book
+		if err := tx.Get(k, &old); err != nil {
+			return err
+		}
+		if old.Version != b.Version {
+			return &ConflictError{ID: b.ID, Version: b.Version}
+		}
+		_, err := tx.Put(k, &book)
+		return err
+	})
+	if _, ok := err.(*ConflictError); ok {
+		return err
 	}
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchBook
+	}
+	if err != nil {
+		return WrapErrorf(err, "datastoredb: could not update Book: %v", err)
+	}
+	b.Version = book.Version
 	return nil
 }
 
@@ -96,7 +226,7 @@ func (db *datastoreDB) ListBooks(ctx context.Context) ([]*Book, error) {
 	keys, err := db.client.GetAll(ctx, q, &books)
 
 	if err != nil {
-		return nil, fmt.Errorf("datastoredb: could not list books: %v", err)
+		return nil, WrapErrorf(err, "datastoredb: could not list books: %v", err)
 	}
 
 	for i, k := range keys {
@@ -121,7 +251,7 @@ func (db *datastoreDB) ListBooksCreatedBy(ctx context.Context, userID string) ([
 	keys, err := db.client.GetAll(ctx, q, &books)
 
 	if err != nil {
-		return nil, fmt.Errorf("datastoredb: could not list books: %v", err)
+		return nil, WrapErrorf(err, "datastoredb: could not list books: %v", err)
 	}
 
 	for i, k := range keys {
@@ -130,3 +260,74 @@ func (db *datastoreDB) ListBooksCreatedBy(ctx context.Context, userID string) ([
 
 	return books, nil
 }
+
+// ListBooksPage returns a page of books, ordered by title.
+func (db *datastoreDB) ListBooksPage(ctx context.Context, pageSize int, cursor string) ([]*Book, string, error) {
+	q := datastore.NewQuery("Book").
+		Order("Title")
+
+	return db.listPage(ctx, q, pageSize, cursor)
+}
+
+// ListBooksCreatedByPage returns a page of books, ordered by title, filtered
+// by the user who created the book entry.
+func (db *datastoreDB) ListBooksCreatedByPage(ctx context.Context, userID string, pageSize int, cursor string) ([]*Book, string, error) {
+	if userID == "" {
+		return db.ListBooksPage(ctx, pageSize, cursor)
+	}
+
+	q := datastore.NewQuery("Book").
+		Filter("CreatedByID =", userID).
+		Order("Title")
+
+	return db.listPage(ctx, q, pageSize, cursor)
+}
+
+// listPage runs q from the given Datastore cursor, returning up to size books
+// and the cursor after the last of them if more books follow.
+func (db *datastoreDB) listPage(ctx context.Context, q *datastore.Query, size int, cursor string) ([]*Book, string, error) {
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", Errorf(KindInvalidArgument, "datastoredb: invalid cursor %q: %v", cursor, err)
+		}
+		q = q.Start(c)
+	}
+	size = pageSize(size)
+
+	// Fetch one extra book to find out whether there is a next page.
+	it := db.client.Run(ctx, q.Limit(size+1))
+	books := make([]*Book, 0, size)
+	var end datastore.Cursor
+	for {
+		book := &Book{}
+		k, err := it.Next(book)
+		if err == iterator.Done {
+			return books, "", nil
+		}
+		if err != nil {
+			return nil, "", WrapErrorf(err, "datastoredb: could not list books: %v", err)
+		}
+		if len(books) == size {
+			return books, end.String(), nil
+		}
+
+		book.ID = k.ID
+		books = append(books, book)
+		if len(books) == size {
+			if end, err = it.Cursor(); err != nil {
+				return nil, "", WrapErrorf(err, "datastoredb: could not get cursor: %v", err)
+			}
+		}
+	}
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+// Datastore has no text search, so every book is scanned.
+func (db *datastoreDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	books, err := searchByScan(ctx, db, query)
+	if err != nil {
+		return nil, WrapErrorf(err, "datastoredb: could not search books: %v", err)
+	}
+	return books, nil
+}
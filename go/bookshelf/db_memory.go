@@ -0,0 +1,244 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// memoryDB is a simple in-memory persistence layer for books, for local runs
+// and tests. Its contents are lost when the process exits, and are not
+// shared with other processes.
+type memoryDB struct {
+	mu     sync.Mutex
+	nextID int64           // next ID to assign to a book.
+	books  map[int64]*Book // maps from Book's ID to book.
+	trash  map[int64]*Book // maps from Book's ID to trashed book.
+}
+
+// Ensure memoryDB conforms to the BookDatabase interface.
+var _ BookDatabase = &memoryDB{}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{
+		books:  make(map[int64]*Book),
+		trash:  make(map[int64]*Book),
+		nextID: 1,
+	}
+}
+
+// Close closes the database.
+func (db *memoryDB) Close(_ context.Context) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.books = nil
+	db.trash = nil
+}
+
+// GetBook retrieves a book by its ID.
+func (db *memoryDB) GetBook(_ context.Context, id int64) (*Book, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	book, ok := db.books[id]
+	if !ok {
+		return nil, ErrNoSuchBook
+	}
+	b := *book
+	return &b, nil
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *memoryDB) AddBook(_ context.Context, b *Book) (id int64, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	b.ID = db.nextID
+	b.Version = 1
+	book := *b
+	db.books[b.ID] = &book
+
+	db.nextID++
+
+	return b.ID, nil
+}
+
+// DeleteBook moves a given book to the trash.
+func (db *memoryDB) DeleteBook(_ context.Context, id int64) error {
+	if id == 0 {
+		return Errorf(KindInvalidArgument, "memorydb: book with unassigned ID passed into deleteBook")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	book, ok := db.books[id]
+	if !ok {
+		return ErrNoSuchBook
+	}
+	book.TrashedAt = time.Now()
+	db.trash[id] = book
+	delete(db.books, id)
+	return nil
+}
+
+// ListTrash returns the books in the trash, most recently trashed first.
+func (db *memoryDB) ListTrash(_ context.Context, userID string) ([]*Book, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	books := make([]*Book, 0)
+	for _, book := range db.trash {
+		if userID == "" || book.CreatedByID == userID {
+			b := *book
+			books = append(books, &b)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].TrashedAt.After(books[j].TrashedAt)
+	})
+	return books, nil
+}
+
+// GetTrashedBook retrieves a book in the trash by its ID.
+func (db *memoryDB) GetTrashedBook(_ context.Context, id int64) (*Book, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	book, ok := db.trash[id]
+	if !ok {
+		return nil, ErrNoSuchBook
+	}
+	b := *book
+	return &b, nil
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *memoryDB) RestoreBook(_ context.Context, id int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	book, ok := db.trash[id]
+	if !ok {
+		return ErrNoSuchBook
+	}
+	book.TrashedAt = time.Time{}
+	db.books[id] = book
+	delete(db.trash, id)
+	return nil
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *memoryDB) PurgeTrash(_ context.Context, before time.Time) ([]int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var ids []int64
+	for id, book := range db.trash {
+		if book.TrashedAt.Before(before) {
+			ids = append(ids, id)
+			delete(db.trash, id)
+		}
+	}
+	return ids, nil
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *memoryDB) UpdateBook(_ context.Context, b *Book) error {
+	if b.ID == 0 {
+		return Errorf(KindInvalidArgument, "memorydb: book with unassigned ID passed into updateBook")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.books[b.ID]
+	if !ok {
+		return ErrNoSuchBook
+	}
+	if old.Version != b.Version {
+		return &ConflictError{ID: b.ID, Version: b.Version}
+	}
+	b.Version++
+	book := *b
+	db.books[b.ID] = &book
+	return nil
+}
+
+// booksOrderedByTitle returns copies of the books for which keep returns
+// true, ordered by title and then ID.
+func (db *memoryDB) booksOrderedByTitle(keep func(*Book) bool) []*Book {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	books := make([]*Book, 0)
+	for _, book := range db.books {
+		if keep(book) {
+			b := *book
+			books = append(books, &b)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		if books[i].Title != books[j].Title {
+			return books[i].Title < books[j].Title
+		}
+		return books[i].ID < books[j].ID
+	})
+	return books
+}
+
+// ListBooks returns a list of books, ordered by title.
+func (db *memoryDB) ListBooks(_ context.Context) ([]*Book, error) {
+	return db.booksOrderedByTitle(func(*Book) bool { return true }), nil
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry.
+func (db *memoryDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	if userID == "" {
+		return db.ListBooks(ctx)
+	}
+	return db.booksOrderedByTitle(func(b *Book) bool { return b.CreatedByID == userID }), nil
+}
+
+// ListBooksPage returns a page of books, ordered by title.
+func (db *memoryDB) ListBooksPage(ctx context.Context, size int, cursor string) ([]*Book, string, error) {
+	return db.ListBooksCreatedByPage(ctx, "", size, cursor)
+}
+
+// ListBooksCreatedByPage returns a page of books, ordered by title, filtered
+// by the user who created the book entry.
+func (db *memoryDB) ListBooksCreatedByPage(_ context.Context, userID string, size int, cursor string) ([]*Book, string, error) {
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "memorydb: %v", err)
+	}
+	if c == nil {
+		c = &keysetCursor{}
+	}
+	size = pageSize(size)
+
+	books := db.booksOrderedByTitle(func(b *Book) bool {
+		if userID != "" && b.CreatedByID != userID {
+			return false
+		}
+		return b.Title > c.Title || (b.Title == c.Title && b.ID > c.ID)
+	})
+	if len(books) > size+1 {
+		books = books[:size+1]
+	}
+	books, next := trimPage(books, size)
+	return books, next, nil
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+func (db *memoryDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return searchByScan(ctx, db, query)
+}
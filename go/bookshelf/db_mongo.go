@@ -0,0 +1,432 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"regexp"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+const (
+	mongoDatabase       = "bookshelf"
+	mongoBooks          = "books"
+	mongoTrash          = "trash"
+	mongoCounters       = "counters"
+	mongoBookIDsCounter = "books"
+)
+
+// mongoDB persists books to a MongoDB server.
+// Books are stored with mgo's default field names (lowercased), and IDs are
+// allocated from a per-collection counter document, so they stay small,
+// positive and increasing like the other backends' IDs. Trashed books are
+// moved to the trash collection.
+type mongoDB struct {
+	session *mgo.Session
+}
+
+// Ensure mongoDB conforms to the BookDatabase interface.
+var _ BookDatabase = &mongoDB{}
+
+// newMongoDB creates a new BookDatabase backed by a given Mongo server,
+// authenticated with given credentials.
+func newMongoDB(addr string, cred *mgo.Credential) (BookDatabase, error) {
+	session, err := mgo.Dial(addr)
+	if err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not dial: %v", err)
+	}
+
+	if cred != nil {
+		if err := session.Login(cred); err != nil {
+			session.Close()
+			return nil, WrapErrorf(err, "mongodb: could not log in: %v", err)
+		}
+	}
+
+	books := session.DB(mongoDatabase).C(mongoBooks)
+	for _, index := range []mgo.Index{
+		{Key: []string{"id"}, Unique: true},
+		{Key: []string{"title", "id"}},
+		{Key: []string{"createdbyid", "title", "id"}},
+	} {
+		if err := books.EnsureIndex(index); err != nil {
+			session.Close()
+			return nil, WrapErrorf(err, "mongodb: could not create index %v: %v", index.Key, err)
+		}
+	}
+
+	trash := session.DB(mongoDatabase).C(mongoTrash)
+	for _, index := range []mgo.Index{
+		{Key: []string{"id"}, Unique: true},
+		{Key: []string{"trashedat"}},
+		{Key: []string{"createdbyid", "trashedat"}},
+	} {
+		if err := trash.EnsureIndex(index); err != nil {
+			session.Close()
+			return nil, WrapErrorf(err, "mongodb: could not create index %v: %v", index.Key, err)
+		}
+	}
+
+	return &mongoDB{
+		session: session,
+	}, nil
+}
+
+// Close closes the database.
+func (db *mongoDB) Close(_ context.Context) {
+	db.session.Close()
+}
+
+// startSpan starts a span for the named operation and returns a copy of the
+// session for it to use. The caller must close the session and end the span.
+func (db *mongoDB) startSpan(ctx context.Context, op string) (*mgo.Session, *trace.Span) {
+	_, span := trace.StartSpan(ctx, "bookshelf/mongodb."+op)
+	return db.session.Copy(), span
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: KindOf(err).TraceCode(), Message: err.Error()})
+	}
+	span.End()
+}
+
+// GetBook retrieves a book by its ID.
+func (db *mongoDB) GetBook(ctx context.Context, id int64) (book *Book, err error) {
+	s, span := db.startSpan(ctx, "GetBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+	span.AddAttributes(trace.Int64Attribute("id", id))
+
+	book = &Book{}
+	if err := s.DB(mongoDatabase).C(mongoBooks).Find(bson.M{"id": id}).One(book); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNoSuchBook
+		}
+		return nil, WrapErrorf(err, "mongodb: could not get book: %v", err)
+	}
+	return book, nil
+}
+
+// nextID allocates a new book ID by atomically incrementing the counter
+// document, creating it on first use.
+func nextID(s *mgo.Session) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	_, err := s.DB(mongoDatabase).C(mongoCounters).FindId(mongoBookIDsCounter).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": int64(1)}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *mongoDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
+	s, span := db.startSpan(ctx, "AddBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	id, err = nextID(s)
+	if err != nil {
+		return 0, WrapErrorf(err, "mongodb: could not assign a new ID: %v", err)
+	}
+	span.AddAttributes(trace.Int64Attribute("id", id))
+
+	b.ID = id
+	b.Version = 1
+	if err := s.DB(mongoDatabase).C(mongoBooks).Insert(b); err != nil {
+		return 0, WrapErrorf(err, "mongodb: could not add book: %v", err)
+	}
+	return id, nil
+}
+
+// DeleteBook moves a given book to the trash.
+func (db *mongoDB) DeleteBook(ctx context.Context, id int64) (err error) {
+	if id == 0 {
+		return Errorf(KindInvalidArgument, "mongodb: book with unassigned ID passed into deleteBook")
+	}
+	s, span := db.startSpan(ctx, "DeleteBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+	span.AddAttributes(trace.Int64Attribute("id", id))
+
+	err = move(s, mongoBooks, mongoTrash, id, time.Now())
+	if err == mgo.ErrNotFound {
+		return ErrNoSuchBook
+	}
+	if err != nil {
+		return WrapErrorf(err, "mongodb: could not delete book: %v", err)
+	}
+	return nil
+}
+
+// move moves the book with the given ID from one collection to another,
+// setting its TrashedAt. MongoDB has no multi-document transactions, so the
+// book is inserted before it is removed: if the removal fails, the book is
+// left in both collections rather than lost, and moving it again completes
+// the move.
+func move(s *mgo.Session, from, to string, id int64, trashedAt time.Time) error {
+	var book Book
+	if err := s.DB(mongoDatabase).C(from).Find(bson.M{"id": id}).One(&book); err != nil {
+		return err
+	}
+	book.TrashedAt = trashedAt
+	if _, err := s.DB(mongoDatabase).C(to).Upsert(bson.M{"id": id}, &book); err != nil {
+		return err
+	}
+	return s.DB(mongoDatabase).C(from).Remove(bson.M{"id": id})
+}
+
+// ListTrash returns the books in the trash, most recently trashed first.
+func (db *mongoDB) ListTrash(ctx context.Context, userID string) (books []*Book, err error) {
+	s, span := db.startSpan(ctx, "ListTrash")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	filter := bson.M{}
+	if userID != "" {
+		filter["createdbyid"] = userID
+	}
+	books = make([]*Book, 0)
+	if err := s.DB(mongoDatabase).C(mongoTrash).Find(filter).Sort("-trashedat", "id").All(&books); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not list trash: %v", err)
+	}
+	return books, nil
+}
+
+// GetTrashedBook retrieves a book in the trash by its ID.
+func (db *mongoDB) GetTrashedBook(ctx context.Context, id int64) (book *Book, err error) {
+	s, span := db.startSpan(ctx, "GetTrashedBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+	span.AddAttributes(trace.Int64Attribute("id", id))
+
+	book = &Book{}
+	if err := s.DB(mongoDatabase).C(mongoTrash).Find(bson.M{"id": id}).One(book); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNoSuchBook
+		}
+		return nil, WrapErrorf(err, "mongodb: could not get book: %v", err)
+	}
+	return book, nil
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *mongoDB) RestoreBook(ctx context.Context, id int64) (err error) {
+	s, span := db.startSpan(ctx, "RestoreBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+	span.AddAttributes(trace.Int64Attribute("id", id))
+
+	err = move(s, mongoTrash, mongoBooks, id, time.Time{})
+	if err == mgo.ErrNotFound {
+		return ErrNoSuchBook
+	}
+	if err != nil {
+		return WrapErrorf(err, "mongodb: could not restore book: %v", err)
+	}
+	return nil
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *mongoDB) PurgeTrash(ctx context.Context, before time.Time) (ids []int64, err error) {
+	s, span := db.startSpan(ctx, "PurgeTrash")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	trash := s.DB(mongoDatabase).C(mongoTrash)
+	var books []*Book
+	if err := trash.Find(bson.M{"trashedat": bson.M{"$lt": before}}).Select(bson.M{"id": 1}).All(&books); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not list trash: %v", err)
+	}
+	for _, b := range books {
+		// Books restored since they were listed are not found.
+		err := trash.Remove(bson.M{"id": b.ID, "trashedat": bson.M{"$lt": before}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return ids, WrapErrorf(err, "mongodb: could not purge book %d: %v", b.ID, err)
+		}
+		ids = append(ids, b.ID)
+	}
+	return ids, nil
+}
+
+// UpdateBook updates the entry for a given book, if it is still at
+// b.Version.
+func (db *mongoDB) UpdateBook(ctx context.Context, b *Book) (err error) {
+	if b.ID == 0 {
+		return Errorf(KindInvalidArgument, "mongodb: book with unassigned ID passed into updateBook")
+	}
+	s, span := db.startSpan(ctx, "UpdateBook")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+	span.AddAttributes(trace.Int64Attribute("id", b.ID))
+
+	selector := bson.M{"id": b.ID, "version": b.Version}
+	if b.Version == 0 {
+		// Books added before versioning have no version field.
+		selector["version"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	book := *b
+	book.Version++
+	c := s.DB(mongoDatabase).C(mongoBooks)
+	if err := c.Update(selector, &book); err != nil {
+		if err == mgo.ErrNotFound {
+			n, err := c.Find(bson.M{"id": b.ID}).Count()
+			if err == nil && n > 0 {
+				return &ConflictError{ID: b.ID, Version: b.Version}
+			}
+			if err == nil {
+				return ErrNoSuchBook
+			}
+		}
+		return WrapErrorf(err, "mongodb: could not update book: %v", err)
+	}
+	b.Version = book.Version
+	return nil
+}
+
+// ListBooks returns a list of books, ordered by title.
+func (db *mongoDB) ListBooks(ctx context.Context) (books []*Book, err error) {
+	s, span := db.startSpan(ctx, "ListBooks")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	books = make([]*Book, 0)
+	if err := s.DB(mongoDatabase).C(mongoBooks).Find(nil).Sort("title", "id").All(&books); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not list books: %v", err)
+	}
+	span.AddAttributes(trace.Int64Attribute("results", int64(len(books))))
+	return books, nil
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry.
+func (db *mongoDB) ListBooksCreatedBy(ctx context.Context, userID string) (books []*Book, err error) {
+	if userID == "" {
+		return db.ListBooks(ctx)
+	}
+
+	s, span := db.startSpan(ctx, "ListBooksCreatedBy")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	books = make([]*Book, 0)
+	q := s.DB(mongoDatabase).C(mongoBooks).Find(bson.M{"createdbyid": userID})
+	if err := q.Sort("title", "id").All(&books); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not list books: %v", err)
+	}
+	span.AddAttributes(trace.Int64Attribute("results", int64(len(books))))
+	return books, nil
+}
+
+// afterCursor returns a query selector matching the books that come after c
+// in (title, id) order, or all books if c is nil.
+func afterCursor(c *keysetCursor) bson.M {
+	if c == nil {
+		return bson.M{}
+	}
+	return bson.M{"$or": []bson.M{
+		{"title": bson.M{"$gt": c.Title}},
+		{"title": c.Title, "id": bson.M{"$gt": c.ID}},
+	}}
+}
+
+// ListBooksPage returns a page of books, ordered by title.
+func (db *mongoDB) ListBooksPage(ctx context.Context, size int, cursor string) (books []*Book, next string, err error) {
+	s, span := db.startSpan(ctx, "ListBooksPage")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "mongodb: %v", err)
+	}
+	size = pageSize(size)
+
+	books = make([]*Book, 0, size+1)
+	q := s.DB(mongoDatabase).C(mongoBooks).Find(afterCursor(c))
+	if err := q.Sort("title", "id").Limit(size + 1).All(&books); err != nil {
+		return nil, "", WrapErrorf(err, "mongodb: could not list books: %v", err)
+	}
+	books, next = trimPage(books, size)
+	span.AddAttributes(trace.Int64Attribute("results", int64(len(books))))
+	return books, next, nil
+}
+
+// ListBooksCreatedByPage returns a page of books, ordered by title, filtered
+// by the user who created the book entry.
+func (db *mongoDB) ListBooksCreatedByPage(ctx context.Context, userID string, size int, cursor string) (books []*Book, next string, err error) {
+	if userID == "" {
+		return db.ListBooksPage(ctx, size, cursor)
+	}
+
+	s, span := db.startSpan(ctx, "ListBooksCreatedByPage")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "mongodb: %v", err)
+	}
+	size = pageSize(size)
+
+	selector := afterCursor(c)
+	selector["createdbyid"] = userID
+	books = make([]*Book, 0, size+1)
+	q := s.DB(mongoDatabase).C(mongoBooks).Find(selector)
+	if err := q.Sort("title", "id").Limit(size + 1).All(&books); err != nil {
+		return nil, "", WrapErrorf(err, "mongodb: could not list books: %v", err)
+	}
+	books, next = trimPage(books, size)
+	span.AddAttributes(trace.Int64Attribute("results", int64(len(books))))
+	return books, next, nil
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+// The query only narrows the books down to those containing each keyword;
+// filterSearch then applies the exact matching rules.
+func (db *mongoDB) SearchBooks(ctx context.Context, query string) (books []*Book, err error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return make([]*Book, 0), nil
+	}
+
+	s, span := db.startSpan(ctx, "SearchBooks")
+	defer s.Close()
+	defer func() { endSpan(span, err) }()
+
+	var and []bson.M
+	for _, term := range terms {
+		re := bson.RegEx{Pattern: regexp.QuoteMeta(term), Options: "i"}
+		and = append(and, bson.M{"$or": []bson.M{
+			{"title": re},
+			{"author": re},
+			{"description": re},
+		}})
+	}
+
+	books = make([]*Book, 0)
+	q := s.DB(mongoDatabase).C(mongoBooks).Find(bson.M{"$and": and})
+	if err := q.Sort("title", "id").All(&books); err != nil {
+		return nil, WrapErrorf(err, "mongodb: could not search books: %v", err)
+	}
+	books = filterSearch(books, terms)
+	span.AddAttributes(trace.Int64Attribute("results", int64(len(books))))
+	return books, nil
+}
@@ -0,0 +1,644 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	// Register the database/sql drivers used by sqlDB.
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/dbtrace"
+)
+
+// sqlDialect identifies the flavour of SQL spoken by the database behind a
+// sqlDB. Its value is also the database/sql driver name.
+type sqlDialect string
+
+const (
+	dialectMySQL  sqlDialect = "mysql"
+	dialectSQLite sqlDialect = "sqlite3"
+)
+
+// sqlMigration is a single schema change. Statements that are the same in
+// every dialect only set both.
+type sqlMigration struct {
+	both, mysql, sqlite string
+}
+
+func (m sqlMigration) statement(d sqlDialect) string {
+	switch {
+	case m.both != "":
+		return m.both
+	case d == dialectMySQL:
+		return m.mysql
+	default:
+		return m.sqlite
+	}
+}
+
+// sqlMigrations lists the schema changes applied, in order, to a SQL
+// database. A database at schema version n has had the first n migrations
+// applied. Once released, a migration must not be edited; append a new one
+// instead.
+var sqlMigrations = []sqlMigration{
+	{
+		mysql: `CREATE TABLE books (
+			id BIGINT NOT NULL AUTO_INCREMENT,
+			title VARCHAR(255) NULL,
+			author VARCHAR(255) NULL,
+			publishedDate VARCHAR(255) NULL,
+			imageUrl VARCHAR(255) NULL,
+			description TEXT NULL,
+			createdBy VARCHAR(255) NULL,
+			createdById VARCHAR(255) NULL,
+			PRIMARY KEY (id)
+		)`,
+		sqlite: `CREATE TABLE books (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NULL,
+			author TEXT NULL,
+			publishedDate TEXT NULL,
+			imageUrl TEXT NULL,
+			description TEXT NULL,
+			createdBy TEXT NULL,
+			createdById TEXT NULL
+		)`,
+	},
+	{both: `CREATE INDEX books_title ON books (title)`},
+	{
+		// Prefixes keep each key part within the 767 bytes InnoDB allows
+		// with the COMPACT row format of MySQL 5.6, even in utf8mb4.
+		mysql:  `CREATE INDEX books_created_by_id ON books (createdById(191), title(191))`,
+		sqlite: `CREATE INDEX books_created_by_id ON books (createdById, title)`,
+	},
+	{
+		mysql:  `ALTER TABLE books ADD COLUMN isbn VARCHAR(17) NULL`,
+		sqlite: `ALTER TABLE books ADD COLUMN isbn TEXT NULL`,
+	},
+	{both: `ALTER TABLE books ADD COLUMN version BIGINT NOT NULL DEFAULT 1`},
+	{
+		mysql: `CREATE TABLE audit_log (
+			id BIGINT NOT NULL AUTO_INCREMENT,
+			bookId BIGINT NOT NULL,
+			action VARCHAR(16) NOT NULL,
+			actorId VARCHAR(255) NOT NULL,
+			actorName VARCHAR(255) NOT NULL,
+			time BIGINT NOT NULL,
+			changes TEXT NOT NULL,
+			traceId VARCHAR(32) NOT NULL,
+			PRIMARY KEY (id)
+		)`,
+		sqlite: `CREATE TABLE audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			bookId INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			actorId TEXT NOT NULL,
+			actorName TEXT NOT NULL,
+			time INTEGER NOT NULL,
+			changes TEXT NOT NULL,
+			traceId TEXT NOT NULL
+		)`,
+	},
+	{both: `CREATE INDEX audit_log_book_id ON audit_log (bookId, id)`},
+	// Trashed books have the time they were trashed, in microseconds since
+	// the Unix epoch.
+	{both: `ALTER TABLE books ADD COLUMN trashedAt BIGINT NULL`},
+	{both: `CREATE INDEX books_trashed_at ON books (trashedAt)`},
+}
+
+// sqlDB persists books to a SQL database, either MySQL or SQLite.
+// Every statement is traced with the dbtrace package.
+type sqlDB struct {
+	conn    *sql.DB
+	dialect sqlDialect
+}
+
+// Ensure sqlDB conforms to the BookDatabase interface.
+var _ BookDatabase = &sqlDB{}
+
+// MySQLConfig holds the connection settings for a MySQL server.
+type MySQLConfig struct {
+	// Optional.
+	Username, Password string
+
+	// Host of the MySQL instance.
+	//
+	// If set, UnixSocket should be unset.
+	Host string
+
+	// Port of the MySQL instance.
+	//
+	// If set, UnixSocket should be unset.
+	Port int
+
+	// UnixSocket is the filepath to a unix socket.
+	//
+	// If set, Host and Port should be unset.
+	UnixSocket string
+}
+
+// dataStoreName returns a connection string suitable for sql.Open.
+func (c MySQLConfig) dataStoreName(databaseName string) string {
+	var cred string
+	// [username[:password]@]
+	if c.Username != "" {
+		cred = c.Username
+		if c.Password != "" {
+			cred = cred + ":" + c.Password
+		}
+		cred = cred + "@"
+	}
+
+	if c.UnixSocket != "" {
+		return fmt.Sprintf("%sunix(%s)/%s", cred, c.UnixSocket, databaseName)
+	}
+	return fmt.Sprintf("%stcp([%s]:%d)/%s", cred, c.Host, c.Port, databaseName)
+}
+
+// newMySQLDB creates a new BookDatabase backed by a given MySQL server,
+// creating the "library" database and migrating its schema as needed. The
+// server must run MySQL 5.6 or later.
+func newMySQLDB(config MySQLConfig) (BookDatabase, error) {
+	ctx := context.Background()
+
+	// Create the database if it doesn't exist.
+	conn, err := sql.Open(string(dialectMySQL), config.dataStoreName(""))
+	if err != nil {
+		return nil, WrapErrorf(err, "mysql: could not get a connection: %v", err)
+	}
+	e := dbtrace.StartExec(ctx, "CREATE DATABASE IF NOT EXISTS library DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci'")
+	e.Result, e.Err = conn.ExecContext(ctx, e.Query)
+	e.End(ctx)
+	conn.Close()
+	if e.Err != nil {
+		return nil, WrapErrorf(e.Err, "mysql: could not create database: %v", e.Err)
+	}
+
+	// clientFoundRows makes an UPDATE that changes nothing still report the
+	// matched row as affected, like SQLite does.
+	conn, err = sql.Open(string(dialectMySQL), config.dataStoreName("library")+"?clientFoundRows=true")
+	if err != nil {
+		return nil, WrapErrorf(err, "mysql: could not get a connection: %v", err)
+	}
+	return newSQLDB(ctx, conn, dialectMySQL)
+}
+
+// newSQLiteDB creates a new BookDatabase backed by the SQLite database file at
+// the given path, creating the file and migrating its schema as needed.
+// It is intended for running the app locally.
+func newSQLiteDB(path string) (BookDatabase, error) {
+	conn, err := sql.Open(string(dialectSQLite), path)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqlite: could not open %q: %v", path, err)
+	}
+	// SQLite allows a single writer; serialize access rather than fail with
+	// "database is locked".
+	conn.SetMaxOpenConns(1)
+	return newSQLDB(context.Background(), conn, dialectSQLite)
+}
+
+// newSQLDB verifies the connection and brings the schema up to date.
+func newSQLDB(ctx context.Context, conn *sql.DB, dialect sqlDialect) (*sqlDB, error) {
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, WrapErrorf(err, "%s: could not establish a good connection: %v", dialect, err)
+	}
+	db := &sqlDB{
+		conn:    conn,
+		dialect: dialect,
+	}
+	if err := db.migrate(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate applies the migrations in sqlMigrations that have not yet been
+// applied, recording progress in the schema_version table.
+func (db *sqlDB) migrate(ctx context.Context) error {
+	if _, err := db.exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INT NOT NULL)`); err != nil {
+		return WrapErrorf(err, "sqldb: could not create schema_version table: %v", err)
+	}
+
+	var version int
+	q := dbtrace.StartQuery(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`)
+	q.Rows, q.Err = db.conn.QueryContext(ctx, q.Query)
+	if q.Err == nil {
+		if q.NextRow() {
+			q.Err = q.Rows.Scan(&version)
+		}
+		q.Rows.Close()
+	}
+	q.End(ctx)
+	if q.Err != nil {
+		return WrapErrorf(q.Err, "sqldb: could not read schema version: %v", q.Err)
+	}
+
+	for ; version < len(sqlMigrations); version++ {
+		if _, err := db.exec(ctx, sqlMigrations[version].statement(db.dialect)); err != nil {
+			return WrapErrorf(err, "sqldb: could not apply migration %d: %v", version+1, err)
+		}
+		if _, err := db.exec(ctx, `INSERT INTO schema_version (version) VALUES (?)`, version+1); err != nil {
+			return WrapErrorf(err, "sqldb: could not record migration %d: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database, freeing up any resources.
+func (db *sqlDB) Close(_ context.Context) {
+	db.conn.Close()
+}
+
+// exec runs a statement that returns no rows, tracing it with dbtrace.
+func (db *sqlDB) exec(ctx context.Context, stmt string, args ...interface{}) (sql.Result, error) {
+	e := dbtrace.StartExec(ctx, stmt)
+	e.Result, e.Err = db.conn.ExecContext(ctx, stmt, args...)
+	e.End(ctx)
+	return e.Result, e.Err
+}
+
+// queryBooks runs a query whose rows are books, tracing it with dbtrace.
+func (db *sqlDB) queryBooks(ctx context.Context, query string, args ...interface{}) ([]*Book, error) {
+	q := dbtrace.StartQuery(ctx, query)
+	defer q.End(ctx)
+
+	q.Rows, q.Err = db.conn.QueryContext(ctx, query, args...)
+	if q.Err != nil {
+		return nil, q.Err
+	}
+	defer q.Rows.Close()
+
+	books := make([]*Book, 0)
+	for q.NextRow() {
+		book, err := scanBook(q.Rows)
+		if err != nil {
+			q.Err = err
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	q.Err = q.Rows.Err()
+	return books, q.Err
+}
+
+// rowScanner is implemented by sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// bookColumns lists the columns read by scanBook, in order.
+const bookColumns = `id, title, author, publishedDate, imageUrl, description, isbn, createdBy, createdById, version, trashedAt`
+
+// scanBook reads a book from a sql.Row or sql.Rows
+func scanBook(s rowScanner) (*Book, error) {
+	var (
+		id            int64
+		title         sql.NullString
+		author        sql.NullString
+		publishedDate sql.NullString
+		imageURL      sql.NullString
+		description   sql.NullString
+		isbn          sql.NullString
+		createdBy     sql.NullString
+		createdByID   sql.NullString
+		version       int64
+		trashedAt     sql.NullInt64
+	)
+	if err := s.Scan(&id, &title, &author, &publishedDate, &imageURL,
+		&description, &isbn, &createdBy, &createdByID, &version, &trashedAt); err != nil {
+		return nil, err
+	}
+
+	book := &Book{
+		ID:            id,
+		Title:         title.String,
+		Author:        author.String,
+		PublishedDate: publishedDate.String,
+		ImageURL:      imageURL.String,
+		Description:   description.String,
+		ISBN:          isbn.String,
+		CreatedBy:     createdBy.String,
+		CreatedByID:   createdByID.String,
+		Version:       version,
+	}
+	if trashedAt.Valid {
+		book.TrashedAt = time.Unix(0, trashedAt.Int64*int64(time.Microsecond))
+	}
+	return book, nil
+}
+
+const listStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE trashedAt IS NULL ORDER BY title, id`
+
+// ListBooks returns a list of books, ordered by title.
+func (db *sqlDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	books, err := db.queryBooks(ctx, listStatement)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not list books: %v", err)
+	}
+	return books, nil
+}
+
+const listByStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE createdById = ? AND trashedAt IS NULL ORDER BY title, id`
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry.
+func (db *sqlDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	if userID == "" {
+		return db.ListBooks(ctx)
+	}
+
+	books, err := db.queryBooks(ctx, listByStatement, userID)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not list books: %v", err)
+	}
+	return books, nil
+}
+
+const listPageStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE (title > ? OR (title = ? AND id > ?)) AND trashedAt IS NULL
+  ORDER BY title, id LIMIT ?`
+
+// ListBooksPage returns a page of books, ordered by title.
+func (db *sqlDB) ListBooksPage(ctx context.Context, size int, cursor string) ([]*Book, string, error) {
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "sqldb: %v", err)
+	}
+	if c == nil {
+		c = &keysetCursor{}
+	}
+	size = pageSize(size)
+
+	books, err := db.queryBooks(ctx, listPageStatement, c.Title, c.Title, c.ID, size+1)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "sqldb: could not list books: %v", err)
+	}
+	books, next := trimPage(books, size)
+	return books, next, nil
+}
+
+const listByPageStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE createdById = ? AND (title > ? OR (title = ? AND id > ?))
+    AND trashedAt IS NULL
+  ORDER BY title, id LIMIT ?`
+
+// ListBooksCreatedByPage returns a page of books, ordered by title, filtered
+// by the user who created the book entry.
+func (db *sqlDB) ListBooksCreatedByPage(ctx context.Context, userID string, size int, cursor string) ([]*Book, string, error) {
+	if userID == "" {
+		return db.ListBooksPage(ctx, size, cursor)
+	}
+
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "sqldb: %v", err)
+	}
+	if c == nil {
+		c = &keysetCursor{}
+	}
+	size = pageSize(size)
+
+	books, err := db.queryBooks(ctx, listByPageStatement, userID, c.Title, c.Title, c.ID, size+1)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "sqldb: could not list books: %v", err)
+	}
+	books, next := trimPage(books, size)
+	return books, next, nil
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+// The query only narrows the books down to those containing each keyword;
+// filterSearch then applies the exact matching rules.
+func (db *sqlDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return make([]*Book, 0), nil
+	}
+
+	var (
+		where = []string{`trashedAt IS NULL`}
+		args  []interface{}
+	)
+	for _, term := range terms {
+		// Terms only contain letters and digits, so need no LIKE escaping.
+		pattern := "%" + term + "%"
+		where = append(where, `(LOWER(title) LIKE ? OR LOWER(author) LIKE ? OR LOWER(description) LIKE ?)`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	stmt := `SELECT ` + bookColumns + ` FROM books WHERE ` +
+		strings.Join(where, " AND ") + ` ORDER BY title, id`
+
+	books, err := db.queryBooks(ctx, stmt, args...)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not search books: %v", err)
+	}
+	return filterSearch(books, terms), nil
+}
+
+const getStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE id = ? AND trashedAt IS NULL`
+
+// GetBook retrieves a book by its ID.
+func (db *sqlDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	books, err := db.queryBooks(ctx, getStatement, id)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not get book: %v", err)
+	}
+	if len(books) == 0 {
+		return nil, ErrNoSuchBook
+	}
+	return books[0], nil
+}
+
+const insertStatement = `
+  INSERT INTO books (
+    title, author, publishedDate, imageUrl, description, isbn, createdBy,
+    createdById
+  ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *sqlDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
+	r, err := db.execAffectingOneRow(ctx, insertStatement, b.Title, b.Author,
+		b.PublishedDate, b.ImageURL, b.Description, b.ISBN, b.CreatedBy, b.CreatedByID)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertID, err := r.LastInsertId()
+	if err != nil {
+		return 0, WrapErrorf(err, "sqldb: could not get last insert ID: %v", err)
+	}
+	// New rows get the column's default version of 1.
+	b.Version = 1
+	return lastInsertID, nil
+}
+
+const deleteStatement = `UPDATE books SET trashedAt = ?
+  WHERE id = ? AND trashedAt IS NULL`
+
+// DeleteBook moves a given book to the trash.
+func (db *sqlDB) DeleteBook(ctx context.Context, id int64) error {
+	if id == 0 {
+		return Errorf(KindInvalidArgument, "sqldb: book with unassigned ID passed into deleteBook")
+	}
+	r, err := db.exec(ctx, deleteStatement, unixMicros(time.Now()), id)
+	if err != nil {
+		return WrapErrorf(err, "sqldb: could not execute statement: %v", err)
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return WrapErrorf(err, "sqldb: could not get rows affected: %v", err)
+	} else if n == 0 {
+		return ErrNoSuchBook
+	}
+	return nil
+}
+
+// unixMicros returns t in microseconds since the Unix epoch.
+func unixMicros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+const listTrashStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE trashedAt IS NOT NULL ORDER BY trashedAt DESC, id`
+
+const listTrashByStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE createdById = ? AND trashedAt IS NOT NULL ORDER BY trashedAt DESC, id`
+
+// ListTrash returns the books in the trash, most recently trashed first.
+func (db *sqlDB) ListTrash(ctx context.Context, userID string) ([]*Book, error) {
+	var (
+		books []*Book
+		err   error
+	)
+	if userID == "" {
+		books, err = db.queryBooks(ctx, listTrashStatement)
+	} else {
+		books, err = db.queryBooks(ctx, listTrashByStatement, userID)
+	}
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not list trash: %v", err)
+	}
+	return books, nil
+}
+
+const getTrashedStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE id = ? AND trashedAt IS NOT NULL`
+
+// GetTrashedBook retrieves a book in the trash by its ID.
+func (db *sqlDB) GetTrashedBook(ctx context.Context, id int64) (*Book, error) {
+	books, err := db.queryBooks(ctx, getTrashedStatement, id)
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not get book: %v", err)
+	}
+	if len(books) == 0 {
+		return nil, ErrNoSuchBook
+	}
+	return books[0], nil
+}
+
+const restoreStatement = `UPDATE books SET trashedAt = NULL
+  WHERE id = ? AND trashedAt IS NOT NULL`
+
+// RestoreBook moves a given book out of the trash.
+func (db *sqlDB) RestoreBook(ctx context.Context, id int64) error {
+	r, err := db.exec(ctx, restoreStatement, id)
+	if err != nil {
+		return WrapErrorf(err, "sqldb: could not execute statement: %v", err)
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return WrapErrorf(err, "sqldb: could not get rows affected: %v", err)
+	} else if n == 0 {
+		return ErrNoSuchBook
+	}
+	return nil
+}
+
+const purgeSelectStatement = `SELECT ` + bookColumns + ` FROM books
+  WHERE trashedAt < ?`
+
+const purgeStatement = `DELETE FROM books WHERE id = ? AND trashedAt < ?`
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *sqlDB) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	books, err := db.queryBooks(ctx, purgeSelectStatement, unixMicros(before))
+	if err != nil {
+		return nil, WrapErrorf(err, "sqldb: could not list trash: %v", err)
+	}
+	var ids []int64
+	for _, b := range books {
+		// Books restored since they were listed are not deleted.
+		r, err := db.exec(ctx, purgeStatement, b.ID, unixMicros(before))
+		if err != nil {
+			return ids, WrapErrorf(err, "sqldb: could not purge book %d: %v", b.ID, err)
+		}
+		if n, err := r.RowsAffected(); err == nil && n == 1 {
+			ids = append(ids, b.ID)
+		}
+	}
+	return ids, nil
+}
+
+const updateStatement = `
+  UPDATE books
+  SET title=?, author=?, publishedDate=?, imageUrl=?, description=?, isbn=?,
+      createdBy=?, createdById=?, version=version+1
+  WHERE id = ? AND version = ? AND trashedAt IS NULL`
+
+// UpdateBook updates the entry for a given book, if it is still at
+// b.Version.
+func (db *sqlDB) UpdateBook(ctx context.Context, b *Book) error {
+	if b.ID == 0 {
+		return Errorf(KindInvalidArgument, "sqldb: book with unassigned ID passed into updateBook")
+	}
+
+	r, err := db.exec(ctx, updateStatement, b.Title, b.Author,
+		b.PublishedDate, b.ImageURL, b.Description, b.ISBN, b.CreatedBy, b.CreatedByID,
+		b.ID, b.Version)
+	if err != nil {
+		return WrapErrorf(err, "sqldb: could not execute statement: %v", err)
+	}
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return WrapErrorf(err, "sqldb: could not get rows affected: %v", err)
+	}
+	if rowsAffected != 1 {
+		// Either the book is gone, or it is at another version.
+		if _, err := db.GetBook(ctx, b.ID); err == ErrNoSuchBook {
+			return err
+		} else if err != nil {
+			return WrapErrorf(err, "sqldb: could not update book: %v", err)
+		}
+		return &ConflictError{ID: b.ID, Version: b.Version}
+	}
+	b.Version++
+	return nil
+}
+
+// execAffectingOneRow executes a given statement, expecting one row to be
+// affected.
+func (db *sqlDB) execAffectingOneRow(ctx context.Context, stmt string, args ...interface{}) (sql.Result, error) {
+	r, err := db.exec(ctx, stmt, args...)
+	if err != nil {
+		return r, WrapErrorf(err, "sqldb: could not execute statement: %v", err)
+	}
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return r, WrapErrorf(err, "sqldb: could not get rows affected: %v", err)
+	} else if rowsAffected != 1 {
+		return r, fmt.Errorf("sqldb: expected 1 row affected, got %d", rowsAffected)
+	}
+	return r, nil
+}
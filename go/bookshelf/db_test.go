@@ -0,0 +1,450 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"golang.org/x/net/context"
+)
+
+// testDB exercises the BookDatabase contract against db, and closes it.
+func testDB(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	defer db.Close(ctx)
+
+	b := &Book{
+		Author:        "testy mc testface",
+		Title:         fmt.Sprintf("t-%d", time.Now().UnixNano()),
+		PublishedDate: fmt.Sprintf("%d", time.Now().Unix()),
+		Description:   "desc",
+		ISBN:          "978-0-13-468599-1",
+		CreatedByID:   fmt.Sprintf("u-%d", time.Now().UnixNano()),
+	}
+
+	id, err := db.AddBook(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Version != 1 {
+		t.Errorf("Version after AddBook: got %d, want 1", b.Version)
+	}
+
+	b.ID = id
+	b.Description = "newdesc"
+	if err := db.UpdateBook(ctx, b); err != nil {
+		t.Error(err)
+	}
+	if b.Version != 2 {
+		t.Errorf("Version after UpdateBook: got %d, want 2", b.Version)
+	}
+
+	// A write of the version before the update conflicts with it.
+	stale := *b
+	stale.Version = 1
+	stale.Description = "stale"
+	if err := db.UpdateBook(ctx, &stale); err == nil {
+		t.Error("UpdateBook of a stale version: got no error, want a conflict")
+	} else if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("UpdateBook of a stale version: got err %v, want a *ConflictError", err)
+	}
+
+	if _, _, err := db.ListBooksPage(ctx, 10, "not a cursor"); KindOf(err) != KindInvalidArgument {
+		t.Errorf("ListBooksPage with a bad cursor: got err %v, want one of KindInvalidArgument", err)
+	}
+
+	gotBook, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gotBook.Description, b.Description; got != want {
+		t.Errorf("Update description: got %q, want %q", got, want)
+	}
+	if got, want := gotBook.ISBN, b.ISBN; got != want {
+		t.Errorf("ISBN: got %q, want %q", got, want)
+	}
+	if got, want := gotBook.Version, b.Version; got != want {
+		t.Errorf("Version: got %d, want %d", got, want)
+	}
+
+	mine, err := db.ListBooksCreatedBy(ctx, b.CreatedByID)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(mine) != 1 || mine[0].ID != id {
+		t.Errorf("ListBooksCreatedBy(%q) = %v, want only book %d", b.CreatedByID, mine, id)
+	}
+
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := db.GetBook(ctx, id); err != ErrNoSuchBook {
+		t.Errorf("GetBook of a deleted book: got err %v, want ErrNoSuchBook", err)
+	}
+	for _, missing := range []int64{id, id + 1000} {
+		if err := db.DeleteBook(ctx, missing); KindOf(err) != KindNotFound {
+			t.Errorf("DeleteBook(%d) of a missing or trashed book: got err %v, want one of KindNotFound", missing, err)
+		}
+		stale := *gotBook
+		stale.ID = missing
+		if err := db.UpdateBook(ctx, &stale); KindOf(err) != KindNotFound {
+			t.Errorf("UpdateBook(%d) of a missing or trashed book: got err %v, want one of KindNotFound", missing, err)
+		}
+	}
+
+	if err := db.DeleteBook(ctx, 0); KindOf(err) != KindInvalidArgument {
+		t.Errorf("DeleteBook(0): got err %v, want one of KindInvalidArgument", err)
+	}
+	unassigned := *gotBook
+	unassigned.ID = 0
+	if err := db.UpdateBook(ctx, &unassigned); KindOf(err) != KindInvalidArgument {
+		t.Errorf("UpdateBook of a book without an ID: got err %v, want one of KindInvalidArgument", err)
+	}
+
+	testTrash(t, db, id, b.CreatedByID)
+	testPagination(t, db)
+	testSearch(t, db)
+}
+
+// testTrash checks that the deleted book id, created by userID, can be
+// restored from the trash until it is purged.
+func testTrash(t *testing.T, db BookDatabase, id int64, userID string) {
+	ctx := context.Background()
+
+	trash, err := db.ListTrash(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != id || trash[0].TrashedAt.IsZero() {
+		t.Errorf("ListTrash(%q) = %v, want only book %d with a TrashedAt", userID, trash, id)
+	}
+	if books, err := db.ListBooksCreatedBy(ctx, userID); err != nil || len(books) != 0 {
+		t.Errorf("ListBooksCreatedBy(%q) = %v, %v; want no books in the trash", userID, books, err)
+	}
+
+	if err := db.RestoreBook(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Errorf("GetBook of a restored book: %v", err)
+	}
+	if err := db.RestoreBook(ctx, id); err != ErrNoSuchBook {
+		t.Errorf("RestoreBook of a book not in the trash: got err %v, want ErrNoSuchBook", err)
+	}
+
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	// Books trashed after the cutoff are kept.
+	if ids, err := db.PurgeTrash(ctx, time.Now().Add(-time.Hour)); err != nil || len(ids) != 0 {
+		t.Errorf("PurgeTrash(an hour ago) = %v, %v; want nothing purged", ids, err)
+	}
+	ids, err := db.PurgeTrash(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged := false
+	for _, p := range ids {
+		purged = purged || p == id
+	}
+	if !purged {
+		t.Errorf("PurgeTrash(now) = %v, want book %d purged", ids, id)
+	}
+	if _, err := db.GetTrashedBook(ctx, id); err != ErrNoSuchBook {
+		t.Errorf("GetTrashedBook of a purged book: got err %v, want ErrNoSuchBook", err)
+	}
+}
+
+// testSearch checks that SearchBooks matches keyword prefixes in any of the
+// searchable fields.
+func testSearch(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	unique := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	id, err := db.AddBook(ctx, &Book{
+		Title:       "Zyzzyva " + unique,
+		Author:      "Quentin Quixote",
+		Description: "An improbable tale.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DeleteBook(ctx, id)
+
+	for query, want := range map[string]bool{
+		unique:                   true,
+		"ZYZZ " + unique:         true,
+		"quix improb " + unique:  true,
+		"zyzzyva tale " + unique: true,
+		"yva " + unique:          false,
+		"missing " + unique:      false,
+	} {
+		books, err := db.SearchBooks(ctx, query)
+		if err != nil {
+			t.Errorf("SearchBooks(%q): %v", query, err)
+			continue
+		}
+		found := len(books) == 1 && books[0].ID == id
+		if found != want || len(books) > 1 {
+			t.Errorf("SearchBooks(%q) = %v, want book %d: %v", query, books, id, want)
+		}
+	}
+}
+
+// testPagination checks that paging through books created by a single user
+// returns each of them once, in title order.
+func testPagination(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	userID := fmt.Sprintf("p-%d", time.Now().UnixNano())
+
+	var want []int64
+	for _, title := range []string{"a", "b", "b", "c", "d"} {
+		id, err := db.AddBook(ctx, &Book{Title: title, CreatedByID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+		defer db.DeleteBook(ctx, id)
+	}
+
+	var got []int64
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		books, next, err := db.ListBooksCreatedByPage(ctx, userID, 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range books {
+			got = append(got, b.ID)
+		}
+		if page < 2 && next == "" {
+			t.Fatalf("page %d: got no next cursor, want one", page)
+		}
+		if page == 2 && next != "" {
+			t.Errorf("page %d: got next cursor %q, want none", page, next)
+		}
+		cursor = next
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paged IDs: got %v, want %v", got, want)
+	}
+}
+
+func TestMemoryDB(t *testing.T) {
+	testDB(t, newMemoryDB())
+}
+
+func TestSQLiteDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bookshelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bookshelf.db")
+	db, err := newSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB(t, db)
+
+	// Reopening an up to date database must not re-run migrations.
+	db, err = newSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close(context.Background())
+}
+
+func TestMySQLDB(t *testing.T) {
+	host := os.Getenv("BOOKSHELF_MYSQL_HOST")
+	if host == "" {
+		t.Skip("BOOKSHELF_MYSQL_HOST not set.")
+	}
+	port, err := strconv.Atoi(os.Getenv("BOOKSHELF_MYSQL_PORT"))
+	if err != nil {
+		port = 3306
+	}
+
+	db, err := newMySQLDB(MySQLConfig{
+		Username: os.Getenv("BOOKSHELF_MYSQL_USER"),
+		Password: os.Getenv("BOOKSHELF_MYSQL_PASSWORD"),
+		Host:     host,
+		Port:     port,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB(t, db)
+}
+
+func TestMySQLDBUnavailable(t *testing.T) {
+	// Nothing listens on the port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	_, err = newMySQLDB(MySQLConfig{Host: "127.0.0.1", Port: port})
+	if KindOf(err) != KindUnavailable {
+		t.Errorf("newMySQLDB of a server that is down: got err %v, want one of KindUnavailable", err)
+	}
+}
+
+// TestDatastoreDB runs against the Cloud Datastore emulator at
+// DATASTORE_EMULATOR_HOST, as set by
+// "gcloud beta emulators datastore env-init".
+func TestDatastoreDB(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST not set.")
+	}
+	projectID := os.Getenv("DATASTORE_PROJECT_ID")
+	if projectID == "" {
+		projectID = "bookshelf-test"
+	}
+
+	client, err := datastore.NewClient(context.Background(), projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := newDatastoreDB(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB(t, db)
+}
+
+// TestMongoDB runs against the server at BOOKSHELF_MONGO_ADDR if set, a
+// throwaway mongod if one is on the PATH, and otherwise a fakeMongod.
+func TestMongoDB(t *testing.T) {
+	addr, stop := mongoAddr(t)
+	defer stop()
+
+	db, err := newMongoDB(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB(t, db)
+}
+
+// TestMongoDBLegacyBooks checks that books added before versioning, which
+// have no version field, can be read and updated.
+func TestMongoDBLegacyBooks(t *testing.T) {
+	addr, stop := mongoAddr(t)
+	defer stop()
+	db, err := newMongoDB(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer db.Close(ctx)
+
+	id, err := db.AddBook(ctx, &Book{Title: "New"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyID := id + 1000
+	books := db.(*mongoDB).session.DB(mongoDatabase).C(mongoBooks)
+	if err := books.Insert(bson.M{"id": legacyID, "title": "Legacy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := db.GetBook(ctx, legacyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != 0 {
+		t.Errorf("legacy book: got version %d, want 0", b.Version)
+	}
+	b.Title = "Updated"
+	if err := db.UpdateBook(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := db.GetBook(ctx, legacyID); err != nil || b.Title != "Updated" || b.Version != 1 {
+		t.Errorf("legacy book after update: got %+v, %v; want title Updated at version 1", b, err)
+	}
+	// Version 0 only matches books without a version.
+	if err := db.UpdateBook(ctx, &Book{ID: id, Title: "Blind"}); KindOf(err) != KindConflict {
+		t.Errorf("UpdateBook at version 0 of a versioned book: got err %v, want a conflict", err)
+	}
+
+	// IDs are allocated from the counter, after the last one.
+	next, err := db.AddBook(ctx, &Book{Title: "Next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != id+1 {
+		t.Errorf("AddBook after book %d: got ID %d, want %d", id, next, id+1)
+	}
+}
+
+// mongoAddr returns the address of the server TestMongoDB runs against, and
+// a function stopping it.
+func mongoAddr(t *testing.T) (addr string, stop func()) {
+	if addr := os.Getenv("BOOKSHELF_MONGO_ADDR"); addr != "" {
+		return addr, func() {}
+	}
+	if _, err := exec.LookPath("mongod"); err == nil {
+		return startMongod(t)
+	}
+	return startFakeMongod(t)
+}
+
+// startMongod starts a mongod with an empty data directory on a free local
+// port, and waits for it to accept connections.
+func startMongod(t *testing.T) (addr string, stop func()) {
+	path, err := exec.LookPath("mongod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "bookshelf-mongo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command(path, "--dbpath", dir, "--bind_ip", "127.0.0.1",
+		"--port", strconv.Itoa(port))
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+
+	addr = fmt.Sprintf("127.0.0.1:%d", port)
+	s, err := mgo.DialWithTimeout(addr, 30*time.Second)
+	if err != nil {
+		stop()
+		t.Fatalf("mongod did not start: %v", err)
+	}
+	s.Close()
+	return addr, stop
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorKind classifies errors by how their caller should react to them, e.g.
+// by the HTTP status code to respond with.
+type ErrorKind int
+
+// The kinds of errors. KindUnknown is the kind of errors that are not
+// classified.
+const (
+	KindUnknown ErrorKind = iota
+	// KindNotFound means a book does not exist.
+	KindNotFound
+	// KindInvalidArgument means a request is malformed, e.g. it has a bad
+	// ID, cursor or field.
+	KindInvalidArgument
+	// KindUnauthorized means a user may not do what they asked.
+	KindUnauthorized
+	// KindConflict means a request conflicts with the current state of a
+	// book, e.g. it was written since it was read.
+	KindConflict
+	// KindUnavailable means a backend could not be reached, and the request
+	// may succeed if retried.
+	KindUnavailable
+)
+
+var errorKindNames = [...]string{
+	KindUnknown:         "unknown",
+	KindNotFound:        "not_found",
+	KindInvalidArgument: "invalid_argument",
+	KindUnauthorized:    "unauthorized",
+	KindConflict:        "conflict",
+	KindUnavailable:     "unavailable",
+}
+
+// String returns the name of k, e.g. "not_found", suitable as a tag value.
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKindNames) {
+		return errorKindNames[KindUnknown]
+	}
+	return errorKindNames[k]
+}
+
+// TraceCode returns the canonical status code of a span that failed with an
+// error of kind k.
+func (k ErrorKind) TraceCode() int32 {
+	switch k {
+	case KindNotFound:
+		return 5 // NOT_FOUND
+	case KindInvalidArgument:
+		return 3 // INVALID_ARGUMENT
+	case KindUnauthorized:
+		return 7 // PERMISSION_DENIED
+	case KindConflict:
+		return 10 // ABORTED
+	case KindUnavailable:
+		return 14 // UNAVAILABLE
+	default:
+		return 2 // UNKNOWN
+	}
+}
+
+// Error is an error of a known kind.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// ErrorKind returns e.Kind.
+func (e *Error) ErrorKind() ErrorKind { return e.Kind }
+
+// Errorf returns an error of the given kind, with a message formatted like
+// fmt.Sprintf.
+func Errorf(kind ErrorKind, format string, v ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, v...)}
+}
+
+// WrapErrorf returns an error with a message formatted like fmt.Sprintf,
+// usually describing err, of the kind of err.
+func WrapErrorf(err error, format string, v ...interface{}) error {
+	return &Error{Kind: KindOf(err), Message: fmt.Sprintf(format, v...)}
+}
+
+// KindOf returns the kind of err. Errors that are not classified, but show
+// that a database or service could not be reached, are of KindUnavailable.
+func KindOf(err error) ErrorKind {
+	if k, ok := err.(interface {
+		ErrorKind() ErrorKind
+	}); ok {
+		return k.ErrorKind()
+	}
+	if _, ok := err.(net.Error); ok {
+		return KindUnavailable
+	}
+	switch err {
+	case nil:
+		return KindUnknown
+	case context.DeadlineExceeded, driver.ErrBadConn:
+		return KindUnavailable
+	}
+	// The Cloud APIs report errors as gRPC statuses.
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return KindUnavailable
+		case codes.NotFound:
+			return KindNotFound
+		case codes.InvalidArgument:
+			return KindInvalidArgument
+		case codes.PermissionDenied, codes.Unauthenticated:
+			return KindUnauthorized
+		case codes.Aborted, codes.AlreadyExists, codes.FailedPrecondition:
+			return KindConflict
+		}
+	}
+	return KindUnknown
+}
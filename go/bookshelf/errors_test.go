@@ -0,0 +1,36 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKindOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ErrorKind
+	}{
+		{ErrNoSuchBook, KindNotFound},
+		{ErrNotAuthorized, KindUnauthorized},
+		{&ConflictError{ID: 1, Version: 2}, KindConflict},
+		{WrapErrorf(ErrNoSuchBook, "could not find book: %v", ErrNoSuchBook), KindNotFound},
+		{Errorf(KindInvalidArgument, "bad id"), KindInvalidArgument},
+		{driver.ErrBadConn, KindUnavailable},
+		{context.DeadlineExceeded, KindUnavailable},
+		{status.Error(codes.Unavailable, "try again"), KindUnavailable},
+		{errors.New("boom"), KindUnknown},
+	} {
+		if got := KindOf(tc.err); got != tc.want {
+			t.Errorf("KindOf(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -50,7 +50,7 @@ command=/app/app
 autostart=true
 autorestart=true
 user=goapp
-environment=HOME="/home/goapp",USER="goapp"
+environment=HOME="/home/goapp",USER="goapp",BOOKSHELF_PROJECT_ID="$PROJECTID"
 stdout_logfile=syslog
 stderr_logfile=syslog
 EOF
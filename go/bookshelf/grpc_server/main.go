@@ -0,0 +1,332 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Sample grpc_server serves the bookshelf database over gRPC, as the
+// BookService defined in bookshelf/proto.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+	pb "github.com/census-ecosystem/opencensus-experiments/go/bookshelf/proto"
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+func main() {
+	cfg, err := bookshelf.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// With the in-process queue, the worker must run in this binary.
+	if cfg.Queue == "memory" {
+		worker, err := bookshelf.NewWorker(bookshelf.DB, bookshelf.Updates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(worker.Run(context.Background()))
+		}()
+	}
+
+	go bookshelf.NewTrashPurger(bookshelf.DB, bookshelf.TrashRetention).Run(context.Background())
+
+	// Drop the cached books changed by the worker and the other instances.
+	if bookshelf.Cache != nil && bookshelf.Changes != nil {
+		go func() {
+			err := bookshelf.Cache.Follow(context.Background(), bookshelf.Changes)
+			log.Printf("Stopped following book changes; other processes' changes show once cached books expire: %v", err)
+		}()
+	}
+
+	port := "50051"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("could not listen on port %s: %v", port, err)
+	}
+
+	// ocgrpc.ServerHandler traces each RPC, continuing the caller's trace,
+	// and records the ocgrpc.DefaultServerViews registered by bookshelf.Configure.
+	srv := grpc.NewServer(grpc.StatsHandler(&ocgrpc.ServerHandler{}),
+		grpc.UnaryInterceptor(authenticate))
+	pb.RegisterBookServiceServer(srv, &server{})
+	log.Printf("Serving BookService on port %s", port)
+	if err := srv.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// server implements BookService on top of bookshelf.DB.
+type server struct{}
+
+var _ pb.BookServiceServer = (*server)(nil)
+
+// ListBooks returns a page of books, ordered by title.
+func (s *server) ListBooks(ctx context.Context, req *pb.ListBooksRequest) (*pb.ListBooksResponse, error) {
+	if req.PageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must not be negative")
+	}
+	books, next, err := bookshelf.DB.ListBooksCreatedByPage(ctx, req.CreatedById, int(req.PageSize), req.PageToken)
+	if err != nil {
+		return nil, errorf(err, "could not list books: %v", err)
+	}
+	return &pb.ListBooksResponse{
+		Books:         toProtos(books),
+		NextPageToken: next,
+	}, nil
+}
+
+// GetBook retrieves a book by its ID.
+func (s *server) GetBook(ctx context.Context, req *pb.GetBookRequest) (*pb.Book, error) {
+	book, err := getBook(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(book), nil
+}
+
+// CreateBook adds a book, assigning it a new ID.
+func (s *server) CreateBook(ctx context.Context, req *pb.CreateBookRequest) (*pb.Book, error) {
+	if req.Book == nil || req.Book.Title == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "book.title is required")
+	}
+	if req.Book.Id != 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "book.id must not be set")
+	}
+
+	// The creator is the caller, never what the request claims.
+	book := fromProto(req.Book)
+	if u := userFromContext(ctx); u != nil {
+		book.CreatedBy = u.name
+		book.CreatedByID = u.ID
+	} else {
+		book.SetCreatorAnonymous()
+	}
+	id, err := bookshelf.DB.AddBook(ctx, book)
+	if err != nil {
+		return nil, errorf(err, "could not save book: %v", err)
+	}
+	book.ID = id
+	go publishUpdate(ctx, id)
+	return toProto(book), nil
+}
+
+// UpdateBook replaces the details of the book with the given ID. The creator
+// of a book cannot be changed. book.version must be the version replaced; if
+// it is missing it fails with FailedPrecondition, and if the book has been
+// updated since, with Aborted.
+func (s *server) UpdateBook(ctx context.Context, req *pb.UpdateBookRequest) (*pb.Book, error) {
+	if req.Book == nil || req.Book.Title == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "book.title is required")
+	}
+	if req.Book.Version <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "book.version is required")
+	}
+	old, err := getBook(ctx, req.Book.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, "update", old); err != nil {
+		return nil, err
+	}
+
+	book := fromProto(req.Book)
+	book.CreatedBy = old.CreatedBy
+	book.CreatedByID = old.CreatedByID
+	if err := bookshelf.DB.UpdateBook(ctx, book); err != nil {
+		return nil, errorf(err, "could not save book: %v", err)
+	}
+	go publishUpdate(ctx, book.ID)
+	return toProto(book), nil
+}
+
+// DeleteBook moves a book to the trash by its ID.
+func (s *server) DeleteBook(ctx context.Context, req *pb.DeleteBookRequest) (*pb.DeleteBookResponse, error) {
+	old, err := getBook(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(ctx, "delete", old); err != nil {
+		return nil, err
+	}
+	if err := bookshelf.DB.DeleteBook(ctx, req.Id); err != nil {
+		return nil, errorf(err, "could not delete book: %v", err)
+	}
+	return &pb.DeleteBookResponse{}, nil
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+func (s *server) SearchBooks(ctx context.Context, req *pb.SearchBooksRequest) (*pb.SearchBooksResponse, error) {
+	books, err := bookshelf.DB.SearchBooks(ctx, req.Query)
+	if err != nil {
+		return nil, errorf(err, "could not search books: %v", err)
+	}
+	return &pb.SearchBooksResponse{Books: toProtos(books)}, nil
+}
+
+// grpcActor is the actor of the changes made through the service by clients
+// that are not signed in.
+var grpcActor = bookshelf.Actor{ID: "grpc", Name: "gRPC client"}
+
+// grpcUser is a caller signed in with the ID token it sent.
+type grpcUser struct {
+	*bookshelf.User
+	name string
+}
+
+type userKey struct{}
+
+// userFromContext returns the caller set by authenticate, or nil if it is
+// not signed in.
+func userFromContext(ctx context.Context) *grpcUser {
+	u, _ := ctx.Value(userKey{}).(*grpcUser)
+	return u
+}
+
+// authenticate is a unary interceptor identifying the caller by the ID token
+// in the "authorization: Bearer <token>" metadata, if sign-in is configured,
+// and attributing the changes made by the call to it in the audit log. Calls
+// without a token are anonymous; calls with a bad one fail with
+// Unauthenticated.
+func authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	actor := grpcActor
+	if raw := bearerToken(ctx); raw != "" && bookshelf.OIDCVerifier != nil {
+		u, name, err := bookshelf.VerifyIDToken(ctx, raw)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		ctx = context.WithValue(ctx, userKey{}, &grpcUser{User: u, name: name})
+		actor = bookshelf.Actor{ID: u.ID, Name: name}
+	}
+	return handler(bookshelf.WithActor(ctx, actor), req)
+}
+
+// bearerToken returns the bearer token in the authorization metadata of the
+// call, if any.
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md["authorization"] {
+		if strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+	return ""
+}
+
+// authorize returns a PermissionDenied error if the caller may not perform
+// action on book. As in the app, without sign-in there are no users to tell
+// apart, so anyone may modify any book.
+func authorize(ctx context.Context, action string, book *bookshelf.Book) error {
+	if bookshelf.OIDCVerifier == nil {
+		return nil
+	}
+	var user *bookshelf.User
+	if u := userFromContext(ctx); u != nil {
+		user = u.User
+	}
+	if err := bookshelf.Authorize(ctx, action, user, book); err != nil {
+		return errorf(err, "may not %s book %d: %v", action, book.ID, err)
+	}
+	return nil
+}
+
+// getBook retrieves a book by its ID, returning a gRPC status error on
+// failure.
+func getBook(ctx context.Context, id int64) (*bookshelf.Book, error) {
+	if id <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "bad book id %d", id)
+	}
+	book, err := bookshelf.DB.GetBook(ctx, id)
+	if err == bookshelf.ErrNoSuchBook {
+		return nil, status.Errorf(codes.NotFound, "no book with id %d", id)
+	}
+	if err != nil {
+		return nil, errorf(err, "could not get book: %v", err)
+	}
+	return book, nil
+}
+
+// publishUpdate notifies the worker that the book identified with the given
+// ID has been added/modified. The update continues the trace of reqCtx.
+func publishUpdate(reqCtx context.Context, bookID int64) {
+	if bookshelf.Updates == nil {
+		return
+	}
+
+	// The RPC's context is cancelled when it returns, so only keep its span
+	// and tags.
+	ctx := tag.NewContext(context.Background(), tag.FromContext(reqCtx))
+	ctx = trace.NewContext(ctx, trace.FromContext(reqCtx))
+
+	err := bookshelf.Updates.Publish(ctx, bookID)
+	log.Printf("Published update for Book ID %d: %v", bookID, err)
+}
+
+func toProto(b *bookshelf.Book) *pb.Book {
+	return &pb.Book{
+		Id:            b.ID,
+		Title:         b.Title,
+		Author:        b.Author,
+		PublishedDate: b.PublishedDate,
+		ImageUrl:      b.ImageURL,
+		Description:   b.Description,
+		Isbn:          b.ISBN,
+		CreatedBy:     b.CreatedBy,
+		CreatedById:   b.CreatedByID,
+		Version:       b.Version,
+	}
+}
+
+func toProtos(books []*bookshelf.Book) []*pb.Book {
+	pbs := make([]*pb.Book, len(books))
+	for i, b := range books {
+		pbs[i] = toProto(b)
+	}
+	return pbs
+}
+
+func fromProto(b *pb.Book) *bookshelf.Book {
+	return &bookshelf.Book{
+		ID:            b.Id,
+		Title:         b.Title,
+		Author:        b.Author,
+		PublishedDate: b.PublishedDate,
+		ImageURL:      b.ImageUrl,
+		Description:   b.Description,
+		ISBN:          b.Isbn,
+		CreatedBy:     b.CreatedBy,
+		CreatedByID:   b.CreatedById,
+		Version:       b.Version,
+	}
+}
+
+// errorf returns a gRPC status error with the code of the kind of err, e.g.
+// NotFound for a book that does not exist, or Internal if its kind is
+// unknown.
+func errorf(err error, format string, v ...interface{}) error {
+	kind := bookshelf.KindOf(err)
+	if kind == bookshelf.KindUnknown {
+		return status.Errorf(codes.Internal, format, v...)
+	}
+	return status.Errorf(codes.Code(kind.TraceCode()), format, v...)
+}
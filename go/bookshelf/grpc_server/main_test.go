@@ -0,0 +1,88 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
+	pb "github.com/census-ecosystem/opencensus-experiments/go/bookshelf/proto"
+)
+
+func TestMain(m *testing.M) {
+	cfg := &bookshelf.Config{DB: "memory", Storage: "none", Queue: "none", CacheSize: "0"}
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestAuthorization(t *testing.T) {
+	// Sign-in is configured; the callers below are signed in by setting
+	// them in the context, as authenticate does.
+	bookshelf.OIDCVerifier = oidc.NewVerifier("https://issuer.example.com", nil, &oidc.Config{ClientID: "bookshelf"})
+	defer func() { bookshelf.OIDCVerifier = nil }()
+
+	s := &server{}
+	ctx := context.Background()
+	owner := context.WithValue(ctx, userKey{}, &grpcUser{User: &bookshelf.User{ID: "u1"}, name: "Owner"})
+	other := context.WithValue(ctx, userKey{}, &grpcUser{User: &bookshelf.User{ID: "u2"}, name: "Other"})
+
+	// The creator is the caller, whoever the request claims it is.
+	book, err := s.CreateBook(owner, &pb.CreateBookRequest{Book: &pb.Book{Title: "Title", CreatedBy: "Other", CreatedById: "u2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.CreatedById != "u1" || book.CreatedBy != "Owner" {
+		t.Errorf("CreateBook: got creator %q (%q), want Owner (u1)", book.CreatedBy, book.CreatedById)
+	}
+	anon, err := s.CreateBook(ctx, &pb.CreateBookRequest{Book: &pb.Book{Title: "Anonymous", CreatedById: "u1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anon.CreatedById != "anonymous" {
+		t.Errorf("CreateBook without sign-in: got creator ID %q, want anonymous", anon.CreatedById)
+	}
+
+	update := &pb.UpdateBookRequest{Book: &pb.Book{Id: book.Id, Title: "Changed", Version: book.Version}}
+	for name, ctx := range map[string]context.Context{"another user": other, "a signed-out caller": ctx} {
+		if _, err := s.UpdateBook(ctx, update); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("UpdateBook by %s: got err %v, want PermissionDenied", name, err)
+		}
+		if _, err := s.DeleteBook(ctx, &pb.DeleteBookRequest{Id: book.Id}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("DeleteBook by %s: got err %v, want PermissionDenied", name, err)
+		}
+	}
+	// Updates must say which version they replace.
+	blind := &pb.UpdateBookRequest{Book: &pb.Book{Id: book.Id, Title: "Changed"}}
+	if _, err := s.UpdateBook(owner, blind); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("UpdateBook without a version: got err %v, want FailedPrecondition", err)
+	}
+	if _, err := s.UpdateBook(owner, update); err != nil {
+		t.Errorf("UpdateBook by the creator: %v", err)
+	}
+	if _, err := s.DeleteBook(owner, &pb.DeleteBookRequest{Id: book.Id}); err != nil {
+		t.Errorf("DeleteBook by the creator: %v", err)
+	}
+
+	// Calls with a bad ID token are rejected before they are handled.
+	bad := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer not-a-token"))
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		t.Error("a call with a bad ID token was handled")
+		return nil, nil
+	}
+	if _, err := authenticate(bad, nil, &grpc.UnaryServerInfo{}, handler); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("authenticate with a bad ID token: got err %v, want Unauthenticated", err)
+	}
+}
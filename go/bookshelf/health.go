@@ -0,0 +1,165 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// prober is implemented by the ImageStores and UpdateQueues that can check
+// that the service they use is reachable. The others are always healthy.
+type prober interface {
+	Probe(ctx context.Context) error
+}
+
+var (
+	healthUp           = stats.Int64("bookshelf/health/up", "Whether a dependency was reachable when last probed", stats.UnitNone)
+	healthProbeLatency = stats.Float64("bookshelf/health/probe_latency", "Latency of the last probe of a dependency", stats.UnitMilliseconds)
+
+	keyDependency = mustNewKey("bookshelf_dependency")
+)
+
+var (
+	// HealthUpView is 1 for each dependency that was reachable when it was
+	// last probed by CheckHealth, and 0 for the others.
+	HealthUpView = &view.View{
+		Name:        "bookshelf/health/up",
+		Description: "Whether each dependency was reachable when last probed",
+		Measure:     healthUp,
+		TagKeys:     []tag.Key{keyDependency},
+		Aggregation: view.LastValue(),
+	}
+
+	// HealthProbeLatencyView is the latency of the last probe of each
+	// dependency.
+	HealthProbeLatencyView = &view.View{
+		Name:        "bookshelf/health/probe_latency",
+		Description: "Latency of the last probe of each dependency",
+		Measure:     healthProbeLatency,
+		TagKeys:     []tag.Key{keyDependency},
+		Aggregation: view.LastValue(),
+	}
+
+	// DefaultHealthViews are the views recorded by CheckHealth.
+	DefaultHealthViews = []*view.View{HealthUpView, HealthProbeLatencyView}
+)
+
+// DependencyHealth is the outcome of probing a dependency.
+type DependencyHealth struct {
+	Name          string  `json:"name"`
+	OK            bool    `json:"ok"`
+	Error         string  `json:"error,omitempty"`
+	LatencyMillis float64 `json:"latencyMs"`
+}
+
+// Health is the outcome of probing all the dependencies.
+type Health struct {
+	// OK is set if every dependency is healthy.
+	OK           bool                `json:"ok"`
+	Dependencies []*DependencyHealth `json:"dependencies"`
+}
+
+// dependency is a configured dependency and how to probe it.
+type dependency struct {
+	name  string
+	probe func(context.Context) error
+}
+
+// dependencies returns the configured dependencies: DB, and Images and
+// Updates if they are set.
+func dependencies() []dependency {
+	deps := []dependency{{"database", func(ctx context.Context) error {
+		// Reading a book works with every backend and wrapper. The cache
+		// is bypassed, so that the database itself is probed.
+		_, _, err := uncached(DB).ListBooksPage(ctx, 1, "")
+		return err
+	}}}
+	if p, ok := Images.(prober); ok {
+		deps = append(deps, dependency{"images", p.Probe})
+	} else if Images != nil {
+		deps = append(deps, dependency{"images", nil})
+	}
+	if p, ok := Updates.(prober); ok {
+		deps = append(deps, dependency{"queue", p.Probe})
+	} else if Updates != nil {
+		deps = append(deps, dependency{"queue", nil})
+	}
+	return deps
+}
+
+// CheckHealth probes the configured database, image store and update queue
+// concurrently, each within timeout, and records the outcomes in
+// DefaultHealthViews.
+func CheckHealth(ctx context.Context, timeout time.Duration) *Health {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/health.Check")
+	defer span.End()
+
+	deps := dependencies()
+	h := &Health{OK: true, Dependencies: make([]*DependencyHealth, len(deps))}
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func(i int, d dependency) {
+			defer wg.Done()
+			h.Dependencies[i] = probe(ctx, d, timeout)
+		}(i, d)
+	}
+	wg.Wait()
+
+	for _, d := range h.Dependencies {
+		h.OK = h.OK && d.OK
+	}
+	span.AddAttributes(trace.BoolAttribute("ok", h.OK))
+	if !h.OK {
+		span.SetStatus(trace.Status{Code: 14, Message: "a dependency is unavailable"}) // UNAVAILABLE
+	}
+	return h
+}
+
+// probe probes d within timeout, and records the outcome. A probe that does
+// not return by the timeout fails, even if it ignores ctx.
+func probe(ctx context.Context, d dependency, timeout time.Duration) *DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx, span := trace.StartSpan(ctx, "bookshelf/health.Probe")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("dependency", d.name))
+
+	start := time.Now()
+	var err error
+	if d.probe != nil {
+		errc := make(chan error, 1)
+		go func() { errc <- d.probe(ctx) }()
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			err = fmt.Errorf("no response within %v", timeout)
+		}
+	}
+	dh := &DependencyHealth{
+		Name:          d.name,
+		OK:            err == nil,
+		LatencyMillis: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	up := int64(1)
+	if err != nil {
+		dh.Error = err.Error()
+		up = 0
+		span.SetStatus(trace.Status{Code: 14, Message: err.Error()}) // UNAVAILABLE
+	}
+
+	// The probe may still be running with ctx, so the tags get a new one.
+	tagged, _ := tag.New(ctx, tag.Upsert(keyDependency, d.name))
+	stats.Record(tagged, healthUp.M(up), healthProbeLatency.M(dh.LatencyMillis))
+	return dh
+}
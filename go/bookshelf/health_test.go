@@ -0,0 +1,36 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		probe  func(context.Context) error
+		wantOK bool
+	}{
+		{"healthy", func(context.Context) error { return nil }, true},
+		{"unprobed", nil, true},
+		{"down", func(context.Context) error { return errors.New("connection refused") }, false},
+		// A probe that ignores its context still fails on time.
+		{"hung", func(context.Context) error { time.Sleep(time.Second); return nil }, false},
+	} {
+		start := time.Now()
+		got := probe(context.Background(), dependency{tc.name, tc.probe}, 10*time.Millisecond)
+		if got.OK != tc.wantOK || got.Name != tc.name || (got.Error == "") != tc.wantOK {
+			t.Errorf("probe(%s) = %+v, want OK = %v", tc.name, got, tc.wantOK)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("probe(%s) took %v, want at most the timeout", tc.name, d)
+		}
+	}
+}
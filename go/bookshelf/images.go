@@ -0,0 +1,157 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// ImageStore stores book cover images and makes them publicly readable.
+type ImageStore interface {
+	// PutImage stores the image read from r under the given name, which
+	// must be a plain file name, and returns the URL it can be read from.
+	PutImage(ctx context.Context, name, contentType string, r io.Reader) (url string, err error)
+}
+
+// validImageName reports whether name is a plain file name.
+func validImageName(name string) bool {
+	return name != "" && name != "." && name != ".." && path.Base(name) == name
+}
+
+// imageExtensions are the file name extensions of the image types that may be
+// uploaded, by content type. Anything else, such as HTML or SVG, could run
+// scripts when served.
+var imageExtensions = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// SniffImage returns the content type of the image read from r, as detected
+// from its first bytes rather than as claimed by the uploader, and its file
+// name extension. The returned reader reads the whole image. It returns an
+// error of KindInvalidArgument if the image is not of an allowed type.
+func SniffImage(r io.Reader) (contentType, ext string, image io.Reader, err error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", nil, WrapErrorf(err, "images: could not read image: %v", err)
+	}
+	head = head[:n]
+	contentType = http.DetectContentType(head)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return "", "", nil, Errorf(KindInvalidArgument, "images: %s is not a GIF, JPEG, PNG or WebP image", contentType)
+	}
+	return contentType, ext, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// imageContentType returns the content type of an image stored under name,
+// or false if it is not of an allowed type.
+func imageContentType(name string) (string, bool) {
+	ext := path.Ext(name)
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	for t, e := range imageExtensions {
+		if e == ext {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+var (
+	imageBytes = stats.Int64("bookshelf/images/uploaded_bytes", "Size of uploaded images", stats.UnitBytes)
+
+	keyImageContentType = mustNewKey("bookshelf_image_content_type")
+)
+
+var (
+	// ImageBytesUploadedView is the total size of the images uploaded, by
+	// content type.
+	ImageBytesUploadedView = &view.View{
+		Name:        "bookshelf/images/uploaded_bytes",
+		Description: "Total size of uploaded images, by content type",
+		Measure:     imageBytes,
+		TagKeys:     []tag.Key{keyImageContentType},
+		Aggregation: view.Sum(),
+	}
+
+	// ImageSizeView is the distribution of the sizes of uploaded images.
+	ImageSizeView = &view.View{
+		Name:        "bookshelf/images/size",
+		Description: "Size distribution of uploaded images",
+		Measure:     imageBytes,
+		Aggregation: view.Distribution(0, 1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20, 16<<20),
+	}
+
+	// DefaultImageViews are the views recorded by InstrumentedImageStore.
+	DefaultImageViews = []*view.View{ImageBytesUploadedView, ImageSizeView}
+)
+
+// instrumentedImageStore decorates an ImageStore with a span for each
+// upload, and records the size of successful uploads.
+type instrumentedImageStore struct {
+	s ImageStore
+}
+
+// InstrumentedImageStore returns an ImageStore that traces each upload to s
+// and records it in DefaultImageViews. The views must be registered for
+// their data to be exported.
+func InstrumentedImageStore(s ImageStore) ImageStore {
+	return &instrumentedImageStore{s: s}
+}
+
+// PutImage stores an image and returns its URL.
+func (s *instrumentedImageStore) PutImage(ctx context.Context, name, contentType string, r io.Reader) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/images.PutImage")
+	defer span.End()
+
+	cr := &countingReader{r: r}
+	url, err := s.s.PutImage(ctx, name, contentType, cr)
+	span.AddAttributes(
+		trace.StringAttribute("name", name),
+		trace.StringAttribute("content_type", contentType),
+		trace.Int64Attribute("size", cr.n))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		return "", err
+	}
+
+	ctx, _ = tag.New(ctx, tag.Upsert(keyImageContentType, contentType))
+	stats.Record(ctx, imageBytes.M(cr.n))
+	return url, nil
+}
+
+// Probe probes s, if it can be.
+func (s *instrumentedImageStore) Probe(ctx context.Context) error {
+	if p, ok := s.s.(prober); ok {
+		return p.Probe(ctx)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
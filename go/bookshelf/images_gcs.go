@@ -0,0 +1,70 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+
+	"golang.org/x/net/context"
+)
+
+// gcsImageStore stores images as publicly readable objects in a Cloud
+// Storage bucket.
+type gcsImageStore struct {
+	bucket     *storage.BucketHandle
+	bucketName string
+}
+
+// Ensure gcsImageStore conforms to the ImageStore interface.
+var _ ImageStore = &gcsImageStore{}
+
+// newGCSImageStore returns an ImageStore that uses the named bucket.
+func newGCSImageStore(bucketName string) (*gcsImageStore, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: could not create client: %v", err)
+	}
+	return &gcsImageStore{
+		bucket:     client.Bucket(bucketName),
+		bucketName: bucketName,
+	}, nil
+}
+
+// Probe checks that the bucket exists and can be read.
+func (s *gcsImageStore) Probe(ctx context.Context) error {
+	if _, err := s.bucket.Attrs(ctx); err != nil {
+		return fmt.Errorf("gcs: could not get bucket %s: %v", s.bucketName, err)
+	}
+	return nil
+}
+
+// PutImage uploads an image to the bucket and returns its public URL.
+func (s *gcsImageStore) PutImage(ctx context.Context, name, contentType string, r io.Reader) (string, error) {
+	if !validImageName(name) {
+		return "", fmt.Errorf("gcs: invalid image name %q", name)
+	}
+
+	w := s.bucket.Object(name).NewWriter(ctx)
+	w.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
+	w.ContentType = contentType
+
+	// Entries are immutable, be aggressive about caching (1 day).
+	w.CacheControl = "public, max-age=86400"
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return "", fmt.Errorf("gcs: could not upload %s: %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("gcs: could not upload %s: %v", name, err)
+	}
+
+	const publicURL = "https://storage.googleapis.com/%s/%s"
+	return fmt.Sprintf(publicURL, s.bucketName, name), nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// LocalImagePath is the path under which the app serves the images stored
+// by the local image store.
+const LocalImagePath = "/images/"
+
+// localImageStore stores images as files in a directory on the local disk,
+// for local runs. It is also an http.Handler serving the files, which must
+// be served at LocalImagePath.
+type localImageStore struct {
+	dir string
+}
+
+// Ensure localImageStore conforms to the ImageStore interface.
+var _ ImageStore = &localImageStore{}
+
+// newLocalImageStore returns an ImageStore that writes to dir, creating it
+// if needed.
+func newLocalImageStore(dir string) (*localImageStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("localimages: could not create %s: %v", dir, err)
+	}
+	return &localImageStore{dir: dir}, nil
+}
+
+// Probe checks that the directory still exists.
+func (s *localImageStore) Probe(context.Context) error {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("localimages: %v", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("localimages: %s is not a directory", s.dir)
+	}
+	return nil
+}
+
+// PutImage writes an image to the directory and returns its URL path.
+func (s *localImageStore) PutImage(_ context.Context, name, _ string, r io.Reader) (string, error) {
+	if !validImageName(name) {
+		return "", fmt.Errorf("localimages: invalid image name %q", name)
+	}
+
+	path := filepath.Join(s.dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("localimages: could not create %s: %v", name, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("localimages: could not write %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("localimages: could not write %s: %v", name, err)
+	}
+	return LocalImagePath + name, nil
+}
+
+// ServeHTTP serves the image named by the request path, relative to
+// LocalImagePath. Only names with the extension of an allowed image type
+// are served.
+func (s *localImageStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, LocalImagePath)
+	contentType, ok := imageContentType(name)
+	if !validImageName(name) || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// The images are served from the app's origin, so browsers must never
+	// treat them as anything but images, even if one is not what its name
+	// claims.
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	h.Set("Content-Disposition", "inline; filename="+name)
+	// Entries are immutable, be aggressive about caching (1 day).
+	h.Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, filepath.Join(s.dir, name))
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestLocalImageStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bookshelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newLocalImageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s := InstrumentedImageStore(store)
+
+	url, err := s.PutImage(ctx, "cover.png", "image/png", strings.NewReader("not really a png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := LocalImagePath + "cover.png"; url != want {
+		t.Errorf("PutImage returned URL %q, want %q", url, want)
+	}
+	if _, err := s.PutImage(ctx, "cover.png", "image/png", strings.NewReader("")); err == nil {
+		t.Error("PutImage overwrote an existing image")
+	}
+	if _, err := s.PutImage(ctx, "../cover.png", "image/png", strings.NewReader("")); err == nil {
+		t.Error("PutImage accepted a name outside its directory")
+	}
+
+	w := httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != 200 || w.Body.String() != "not really a png" {
+		t.Errorf("GET %s = %d %q, want the stored image", url, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("GET %s: got Content-Type %q, want image/png", url, got)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("GET %s: got X-Content-Type-Options %q, want nosniff", url, got)
+	}
+	// Files that are not images are never served, even if they were stored.
+	if _, err := s.PutImage(ctx, "cover.html", "text/html", strings.NewReader("<script>")); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest("GET", LocalImagePath+"cover.html", nil))
+	if w.Code != 404 {
+		t.Errorf("GET of an HTML file = %d, want 404", w.Code)
+	}
+	w = httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest("GET", LocalImagePath+"missing.png", nil))
+	if w.Code != 404 {
+		t.Errorf("GET of a missing image = %d, want 404", w.Code)
+	}
+}
+
+func TestSniffImage(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\nrest of the image"
+	contentType, ext, r, err := SniffImage(strings.NewReader(png))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" || ext != ".png" {
+		t.Errorf("SniffImage of a PNG = %q, %q, want image/png, .png", contentType, ext)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != png {
+		t.Errorf("SniffImage returned a reader of %q, want the whole image", b)
+	}
+
+	for _, data := range []string{
+		"",
+		"<html><script>alert(1)</script></html>",
+		`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+	} {
+		if _, _, _, err := SniffImage(strings.NewReader(data)); KindOf(err) != KindInvalidArgument {
+			t.Errorf("SniffImage(%q): got err %v, want KindInvalidArgument", data, err)
+		}
+	}
+}
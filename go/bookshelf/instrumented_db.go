@@ -0,0 +1,229 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"log"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+var (
+	dbLatency = stats.Float64("bookshelf/db/latency", "Latency of BookDatabase calls", stats.UnitMilliseconds)
+	dbResults = stats.Int64("bookshelf/db/results", "Number of books returned by BookDatabase list calls", stats.UnitNone)
+
+	keyDBMethod  = mustNewKey("bookshelf_db_method")
+	keyDBOutcome = mustNewKey("bookshelf_db_outcome")
+)
+
+var (
+	// DBLatencyView is the distribution of BookDatabase call latencies, by
+	// method and outcome ("ok" or "error").
+	DBLatencyView = &view.View{
+		Name:        "bookshelf/db/latency",
+		Description: "Latency distribution of BookDatabase calls, by method and outcome",
+		Measure:     dbLatency,
+		TagKeys:     []tag.Key{keyDBMethod, keyDBOutcome},
+		Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000),
+	}
+
+	// DBCallCountView counts BookDatabase calls, by method and outcome.
+	DBCallCountView = &view.View{
+		Name:        "bookshelf/db/calls",
+		Description: "Count of BookDatabase calls, by method and outcome",
+		Measure:     dbLatency,
+		TagKeys:     []tag.Key{keyDBMethod, keyDBOutcome},
+		Aggregation: view.Count(),
+	}
+
+	// DBResultsView is the distribution of the number of books returned by
+	// successful BookDatabase list calls, by method.
+	DBResultsView = &view.View{
+		Name:        "bookshelf/db/results",
+		Description: "Distribution of the number of books returned by BookDatabase list calls, by method",
+		Measure:     dbResults,
+		TagKeys:     []tag.Key{keyDBMethod},
+		Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000),
+	}
+
+	// DefaultDBViews are the views recorded by InstrumentedDB.
+	DefaultDBViews = []*view.View{DBLatencyView, DBCallCountView, DBResultsView}
+)
+
+// mustNewKey returns a tag key with the given name, which must be valid.
+func mustNewKey(name string) tag.Key {
+	k, err := tag.NewKey(name)
+	if err != nil {
+		log.Fatalf("invalid tag key %q: %v", name, err)
+	}
+	return k
+}
+
+// instrumentedDB decorates a BookDatabase with a span for each call, and
+// records the latency of each call and the number of results of list calls.
+type instrumentedDB struct {
+	db BookDatabase
+}
+
+// Ensure instrumentedDB conforms to the BookDatabase interface.
+var _ BookDatabase = &instrumentedDB{}
+
+// InstrumentedDB returns a BookDatabase that traces each call to db and
+// records it in DefaultDBViews. The views must be registered for their data
+// to be exported.
+func InstrumentedDB(db BookDatabase) BookDatabase {
+	return &instrumentedDB{db: db}
+}
+
+// start starts a span for a call to the named method. The returned function
+// ends it, recording err and, if err is nil and results is not negative, the
+// number of results.
+func (db *instrumentedDB) start(ctx context.Context, method string) (context.Context, func(results int, err error)) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/db."+method)
+	start := time.Now()
+	return ctx, func(results int, err error) {
+		outcome := "ok"
+		ms := []stats.Measurement{
+			dbLatency.M(float64(time.Since(start)) / float64(time.Millisecond)),
+		}
+		if err != nil {
+			outcome = "error"
+			span.SetStatus(trace.Status{Code: KindOf(err).TraceCode(), Message: err.Error()})
+		} else if results >= 0 {
+			span.AddAttributes(trace.Int64Attribute("results", int64(results)))
+			ms = append(ms, dbResults.M(int64(results)))
+		}
+		span.End()
+
+		ctx, _ := tag.New(ctx,
+			tag.Upsert(keyDBMethod, method),
+			tag.Upsert(keyDBOutcome, outcome))
+		stats.Record(ctx, ms...)
+	}
+}
+
+// ListBooks returns a list of books, ordered by title.
+func (db *instrumentedDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	ctx, end := db.start(ctx, "ListBooks")
+	books, err := db.db.ListBooks(ctx)
+	end(len(books), err)
+	return books, err
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry.
+func (db *instrumentedDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	ctx, end := db.start(ctx, "ListBooksCreatedBy")
+	books, err := db.db.ListBooksCreatedBy(ctx, userID)
+	end(len(books), err)
+	return books, err
+}
+
+// ListBooksPage returns a page of books, ordered by title.
+func (db *instrumentedDB) ListBooksPage(ctx context.Context, pageSize int, cursor string) ([]*Book, string, error) {
+	ctx, end := db.start(ctx, "ListBooksPage")
+	books, next, err := db.db.ListBooksPage(ctx, pageSize, cursor)
+	end(len(books), err)
+	return books, next, err
+}
+
+// ListBooksCreatedByPage returns a page of books, ordered by title, filtered
+// by the user who created the book entry.
+func (db *instrumentedDB) ListBooksCreatedByPage(ctx context.Context, userID string, pageSize int, cursor string) ([]*Book, string, error) {
+	ctx, end := db.start(ctx, "ListBooksCreatedByPage")
+	books, next, err := db.db.ListBooksCreatedByPage(ctx, userID, pageSize, cursor)
+	end(len(books), err)
+	return books, next, err
+}
+
+// SearchBooks returns the books matching a search query, ordered by title.
+func (db *instrumentedDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	ctx, end := db.start(ctx, "SearchBooks")
+	books, err := db.db.SearchBooks(ctx, query)
+	end(len(books), err)
+	return books, err
+}
+
+// GetBook retrieves a book by its ID.
+func (db *instrumentedDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	ctx, end := db.start(ctx, "GetBook")
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", id))
+	book, err := db.db.GetBook(ctx, id)
+	end(-1, err)
+	return book, err
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *instrumentedDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	ctx, end := db.start(ctx, "AddBook")
+	id, err := db.db.AddBook(ctx, b)
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", id))
+	end(-1, err)
+	return id, err
+}
+
+// DeleteBook moves a given book to the trash.
+func (db *instrumentedDB) DeleteBook(ctx context.Context, id int64) error {
+	ctx, end := db.start(ctx, "DeleteBook")
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", id))
+	err := db.db.DeleteBook(ctx, id)
+	end(-1, err)
+	return err
+}
+
+// ListTrash returns the books in the trash, most recently trashed first.
+func (db *instrumentedDB) ListTrash(ctx context.Context, userID string) ([]*Book, error) {
+	ctx, end := db.start(ctx, "ListTrash")
+	books, err := db.db.ListTrash(ctx, userID)
+	end(len(books), err)
+	return books, err
+}
+
+// GetTrashedBook retrieves a book in the trash by its ID.
+func (db *instrumentedDB) GetTrashedBook(ctx context.Context, id int64) (*Book, error) {
+	ctx, end := db.start(ctx, "GetTrashedBook")
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", id))
+	book, err := db.db.GetTrashedBook(ctx, id)
+	end(-1, err)
+	return book, err
+}
+
+// RestoreBook moves a given book out of the trash.
+func (db *instrumentedDB) RestoreBook(ctx context.Context, id int64) error {
+	ctx, end := db.start(ctx, "RestoreBook")
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", id))
+	err := db.db.RestoreBook(ctx, id)
+	end(-1, err)
+	return err
+}
+
+// PurgeTrash permanently removes the books trashed before a given time.
+func (db *instrumentedDB) PurgeTrash(ctx context.Context, before time.Time) ([]int64, error) {
+	ctx, end := db.start(ctx, "PurgeTrash")
+	ids, err := db.db.PurgeTrash(ctx, before)
+	end(len(ids), err)
+	return ids, err
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *instrumentedDB) UpdateBook(ctx context.Context, b *Book) error {
+	ctx, end := db.start(ctx, "UpdateBook")
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("id", b.ID))
+	err := db.db.UpdateBook(ctx, b)
+	end(-1, err)
+	return err
+}
+
+// Close closes the database, freeing up any available resources.
+func (db *instrumentedDB) Close(ctx context.Context) {
+	ctx, end := db.start(ctx, "Close")
+	db.db.Close(ctx)
+	end(-1, nil)
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"golang.org/x/net/context"
+)
+
+func TestInstrumentedDB(t *testing.T) {
+	if err := view.Register(DefaultDBViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(DefaultDBViews...)
+
+	dir, err := ioutil.TempDir("", "bookshelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := newSQLiteDB(filepath.Join(dir, "bookshelf.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idb := InstrumentedDB(db)
+	ctx := context.Background()
+	id, err := idb.AddBook(ctx, &Book{Title: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idb.GetBook(ctx, id); err != nil {
+		t.Error(err)
+	}
+	if _, err := idb.GetBook(ctx, id+1); err == nil {
+		t.Error("GetBook of a missing book: want non-nil err")
+	}
+	if _, err := idb.ListBooks(ctx); err != nil {
+		t.Error(err)
+	}
+	idb.Close(ctx)
+
+	rows, err := view.RetrieveData(DBCallCountView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(map[string]int64)
+	for _, row := range rows {
+		var method, outcome string
+		for _, tag := range row.Tags {
+			switch tag.Key {
+			case keyDBMethod:
+				method = tag.Value
+			case keyDBOutcome:
+				outcome = tag.Value
+			}
+		}
+		calls[method+"/"+outcome] += row.Data.(*view.CountData).Value
+	}
+
+	for key, want := range map[string]int64{
+		"AddBook/ok":    1,
+		"GetBook/ok":    1,
+		"GetBook/error": 1,
+		"ListBooks/ok":  1,
+		"Close/ok":      1,
+	} {
+		if got := calls[key]; got != want {
+			t.Errorf("calls[%q] = %d, want %d", key, got, want)
+		}
+	}
+}
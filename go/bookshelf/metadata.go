@@ -0,0 +1,114 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// BookMetadata describes a book, as found by a MetadataProvider.
+type BookMetadata struct {
+	Title         string
+	Authors       []string
+	PublishedDate string
+	Description   string
+	ImageURL      string
+	ISBNs         []string
+}
+
+// MetadataProvider finds the metadata of books, to fill in their details.
+type MetadataProvider interface {
+	// SearchByTitle returns metadata of the books matching a title and, if
+	// it is not empty, an author, most relevant first.
+	SearchByTitle(ctx context.Context, title, author string) ([]*BookMetadata, error)
+
+	// LookupISBN returns the metadata of the book with the given ISBN, or
+	// nil if it is unknown.
+	LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error)
+}
+
+// Thresholds of matchScore.
+const (
+	// confidentMatchScore is the score above which metadata is trusted to
+	// describe the book, so that it may replace the book's title and
+	// author.
+	confidentMatchScore = 0.8
+	// minMatchScore is the score below which metadata is assumed to
+	// describe another book, and is ignored.
+	minMatchScore = 0.5
+)
+
+// matchScore returns how well m matches b, from 0 (unrelated) to 1 (the
+// same book). Books with a common ISBN match exactly; otherwise the score is
+// based on the words of their titles and, if b has one, authors.
+func matchScore(b *Book, m *BookMetadata) float64 {
+	if isbn := normalizeISBN(b.ISBN); isbn != "" {
+		for _, other := range m.ISBNs {
+			if normalizeISBN(other) == isbn {
+				return 1
+			}
+		}
+	}
+
+	score := wordSimilarity(b.Title, m.Title)
+	if b.Author != "" {
+		// Users often enter only some of the authors, or their last names.
+		score = 0.7*score + 0.3*wordContainment(b.Author, strings.Join(m.Authors, " "))
+	}
+	return score
+}
+
+// wordSet returns the set of search terms in s.
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range searchTerms(s) {
+		set[w] = true
+	}
+	return set
+}
+
+// commonWords returns the number of words in both sets.
+func commonWords(a, b map[string]bool) int {
+	n := 0
+	for w := range a {
+		if b[w] {
+			n++
+		}
+	}
+	return n
+}
+
+// wordSimilarity returns the Dice coefficient of the sets of words of a and
+// b: twice the number of words they have in common over the total number of
+// words in each.
+func wordSimilarity(a, b string) float64 {
+	wa, wb := wordSet(a), wordSet(b)
+	if len(wa)+len(wb) == 0 {
+		return 0
+	}
+	return 2 * float64(commonWords(wa, wb)) / float64(len(wa)+len(wb))
+}
+
+// wordContainment returns the fraction of the words of a that are in b.
+func wordContainment(a, b string) float64 {
+	wa := wordSet(a)
+	if len(wa) == 0 {
+		return 0
+	}
+	return float64(commonWords(wa, wordSet(b))) / float64(len(wa))
+}
+
+// normalizeISBN strips the hyphens and spaces from an ISBN, and upper-cases
+// the "X" check digit of ISBN-10s.
+func normalizeISBN(isbn string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, isbn))
+}
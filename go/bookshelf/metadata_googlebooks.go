@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	books "google.golang.org/api/books/v1"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/net/context"
+)
+
+// googleBooksProvider is a MetadataProvider using the Google Books API.
+type googleBooksProvider struct {
+	svc *books.Service
+}
+
+// Ensure googleBooksProvider conforms to the MetadataProvider interface.
+var _ MetadataProvider = &googleBooksProvider{}
+
+// NewGoogleBooksProvider returns a MetadataProvider using the Google Books
+// API. Its calls are traced, and their latency is recorded in
+// BooksAPILatencyView.
+func NewGoogleBooksProvider() (MetadataProvider, error) {
+	// ochttp.Transport traces the calls to the Books API.
+	svc, err := books.New(&http.Client{Transport: &ochttp.Transport{}})
+	if err != nil {
+		return nil, fmt.Errorf("googlebooks: could not create client: %v", err)
+	}
+	return &googleBooksProvider{svc: svc}, nil
+}
+
+// SearchByTitle searches for volumes by title and author.
+func (p *googleBooksProvider) SearchByTitle(ctx context.Context, title, author string) ([]*BookMetadata, error) {
+	q := "intitle:" + title
+	if author != "" {
+		q += " inauthor:" + author
+	}
+	return p.search(ctx, q)
+}
+
+// LookupISBN searches for the volume with the given ISBN.
+func (p *googleBooksProvider) LookupISBN(ctx context.Context, isbn string) (*BookMetadata, error) {
+	ms, err := p.search(ctx, "isbn:"+normalizeISBN(isbn))
+	if err != nil || len(ms) == 0 {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// search lists the volumes matching q, recording the latency of the call.
+func (p *googleBooksProvider) search(ctx context.Context, q string) ([]*BookMetadata, error) {
+	start := time.Now()
+	vols, err := p.svc.Volumes.List(q).Context(ctx).Do()
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	tctx, _ := tag.New(ctx, tag.Upsert(keyBooksAPIOutcome, outcome))
+	stats.Record(tctx, booksAPILatency.M(sinceMillis(start)))
+	recordBooksAPILatency(time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("googlebooks: could not list volumes: %v", err)
+	}
+
+	ms := make([]*BookMetadata, 0, len(vols.Items))
+	for _, v := range vols.Items {
+		if v.VolumeInfo != nil {
+			ms = append(ms, volumeMetadata(v.VolumeInfo))
+		}
+	}
+	return ms, nil
+}
+
+// volumeMetadata converts the information of a Books API volume.
+func volumeMetadata(info *books.VolumeVolumeInfo) *BookMetadata {
+	m := &BookMetadata{
+		Title:         info.Title,
+		Authors:       info.Authors,
+		PublishedDate: info.PublishedDate,
+		Description:   info.Description,
+	}
+	if info.ImageLinks != nil {
+		url := info.ImageLinks.Thumbnail
+		// Replace http with https to prevent Content Security errors on the page.
+		m.ImageURL = strings.Replace(url, "http://", "https://", 1)
+	}
+	for _, id := range info.IndustryIdentifiers {
+		if id.Type == "ISBN_10" || id.Type == "ISBN_13" {
+			m.ISBNs = append(m.ISBNs, id.Identifier)
+		}
+	}
+	return m
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeMetadataProvider is a MetadataProvider returning canned metadata.
+type fakeMetadataProvider struct {
+	books []*BookMetadata
+	// byISBN are the books returned by LookupISBN regardless of their
+	// ISBNs, as the Books API may.
+	byISBN map[string]*BookMetadata
+	err    error
+}
+
+// SearchByTitle returns the books sharing a word with title, in order.
+func (p *fakeMetadataProvider) SearchByTitle(_ context.Context, title, _ string) ([]*BookMetadata, error) {
+	var ms []*BookMetadata
+	for _, m := range p.books {
+		if wordSimilarity(title, m.Title) > 0 {
+			ms = append(ms, m)
+		}
+	}
+	return ms, p.err
+}
+
+// LookupISBN returns the book in byISBN, or else the first book with the
+// given ISBN.
+func (p *fakeMetadataProvider) LookupISBN(_ context.Context, isbn string) (*BookMetadata, error) {
+	if m, ok := p.byISBN[isbn]; ok {
+		return m, p.err
+	}
+	for _, m := range p.books {
+		for _, other := range m.ISBNs {
+			if normalizeISBN(other) == normalizeISBN(isbn) {
+				return m, p.err
+			}
+		}
+	}
+	return nil, p.err
+}
+
+func TestMatchScore(t *testing.T) {
+	gopl := &BookMetadata{
+		Title:   "The Go Programming Language",
+		Authors: []string{"Alan A. A. Donovan", "Brian W. Kernighan"},
+		ISBNs:   []string{"9780134190440"},
+	}
+	for _, tc := range []struct {
+		book      Book
+		confident bool
+		match     bool
+	}{
+		{Book{Title: "the go programming language"}, true, true},
+		{Book{Title: "Go programming", Author: "Kernighan"}, false, true},
+		{Book{Title: "Programming Pearls"}, false, false},
+		{Book{Title: "Anything", ISBN: "978-0-13-419044-0"}, true, true},
+	} {
+		score := matchScore(&tc.book, gopl)
+		if got := score >= confidentMatchScore; got != tc.confident {
+			t.Errorf("matchScore(%+v) = %v, confident = %v, want %v", tc.book, score, got, tc.confident)
+		}
+		if got := score >= minMatchScore; got != tc.match {
+			t.Errorf("matchScore(%+v) = %v, match = %v, want %v", tc.book, score, got, tc.match)
+		}
+	}
+}
+
+func TestWorkerUpdate(t *testing.T) {
+	gopl := &BookMetadata{
+		Title:         "The Go Programming Language",
+		Authors:       []string{"Alan A. A. Donovan", "Brian W. Kernighan"},
+		PublishedDate: "2015",
+		Description:   "gopl",
+		ISBNs:         []string{"9780134190440"},
+	}
+	provider := &fakeMetadataProvider{
+		books: []*BookMetadata{
+			gopl,
+			{
+				Title:       "Dune Messiah",
+				Authors:     []string{"Frank Herbert"},
+				Description: "the sequel",
+			},
+		},
+		byISBN: map[string]*BookMetadata{"9780441013593": gopl},
+	}
+	db := newMemoryDB()
+	w := &Worker{Metadata: provider, db: db}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		book    Book
+		updated bool
+		want    Book
+	}{
+		// A confident match replaces the title and author.
+		{
+			Book{Title: "the go programming language", Author: "Kernighan"},
+			true,
+			Book{Title: "The Go Programming Language", Author: "Alan A. A. Donovan, Brian W. Kernighan",
+				PublishedDate: "2015", Description: "gopl", ISBN: "9780134190440"},
+		},
+		// A weaker match only fills in blanks.
+		{
+			Book{Title: "Go programming language", Author: "Rob Pike"},
+			true,
+			Book{Title: "Go programming language", Author: "Rob Pike", PublishedDate: "2015", Description: "gopl"},
+		},
+		{
+			Book{Title: "Dune"},
+			true,
+			Book{Title: "Dune", Author: "Frank Herbert", Description: "the sequel"},
+		},
+		// Books are looked up by ISBN first.
+		{
+			Book{Title: "gopl", ISBN: "978-0-13-419044-0"},
+			true,
+			Book{Title: "The Go Programming Language", Author: "Alan A. A. Donovan, Brian W. Kernighan",
+				PublishedDate: "2015", Description: "gopl", ISBN: "978-0-13-419044-0"},
+		},
+		// A volume found by ISBN with another ISBN is scored like any
+		// other, and ignored if unrelated.
+		{
+			Book{Title: "Dune", ISBN: "9780441013593"},
+			false,
+			Book{Title: "Dune", ISBN: "9780441013593"},
+		},
+		// Unrelated volumes are ignored.
+		{
+			Book{Title: "Go Tell It on the Mountain"},
+			false,
+			Book{Title: "Go Tell It on the Mountain"},
+		},
+	} {
+		b := tc.book
+		id, err := db.AddBook(ctx, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updated, err := w.update(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetBook(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.want.ID = id
+		// The book is updated once, if at all.
+		tc.want.Version = 1
+		if tc.updated {
+			tc.want.Version = 2
+		}
+		if updated != tc.updated || *got != tc.want {
+			t.Errorf("update(%+v) = %v, book %+v; want %v, book %+v", tc.book, updated, *got, tc.updated, tc.want)
+		}
+	}
+}
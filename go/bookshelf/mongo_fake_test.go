@@ -0,0 +1,575 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeMongod is an in-process MongoDB server, implementing just enough of
+// the wire protocol and query language for mongoDB and mongoAuditLog. It
+// reports wire version 2 (MongoDB 2.6), so that mgo sends queries as
+// OP_QUERY messages and everything else as commands.
+type fakeMongod struct {
+	l net.Listener
+
+	mu          sync.Mutex
+	collections map[string][]bson.M // by full name, e.g. "bookshelf.books".
+}
+
+// startFakeMongod starts a fakeMongod on a free local port.
+func startFakeMongod(t *testing.T) (addr string, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMongod{l: l, collections: make(map[string][]bson.M)}
+	go m.serve()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func (m *fakeMongod) serve() {
+	for {
+		conn, err := m.l.Accept()
+		if err != nil {
+			return
+		}
+		go m.serveConn(conn)
+	}
+}
+
+// Wire protocol opcodes.
+const (
+	opReply = 1
+	opQuery = 2004
+)
+
+// serveConn answers the OP_QUERY messages read from conn, until it is
+// closed.
+func (m *fakeMongod) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		msg := make([]byte, int(binary.LittleEndian.Uint32(header[0:]))-len(header))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		requestID := binary.LittleEndian.Uint32(header[4:])
+		if binary.LittleEndian.Uint32(header[12:]) != opQuery {
+			// Nothing else expects a reply.
+			continue
+		}
+
+		// flags, fullCollectionName, numberToSkip, numberToReturn, query,
+		// and an optional field selector.
+		msg = msg[4:]
+		end := strings.IndexByte(string(msg), 0)
+		collection := string(msg[:end])
+		msg = msg[end+1:]
+		skip := int(int32(binary.LittleEndian.Uint32(msg)))
+		limit := int(int32(binary.LittleEndian.Uint32(msg[4:])))
+		msg = msg[8:]
+		query, msg := nextDoc(msg)
+		var fields []byte
+		if len(msg) > 0 {
+			fields, _ = nextDoc(msg)
+		}
+
+		var docs []interface{}
+		if strings.HasSuffix(collection, ".$cmd") {
+			docs = []interface{}{m.command(strings.TrimSuffix(collection, ".$cmd"), query)}
+		} else {
+			docs = m.query(collection, query, fields, skip, limit)
+		}
+		if _, err := conn.Write(reply(requestID, docs)); err != nil {
+			return
+		}
+	}
+}
+
+// nextDoc splits the BSON document at the start of b from the rest.
+func nextDoc(b []byte) (doc, rest []byte) {
+	n := int(binary.LittleEndian.Uint32(b))
+	return b[:n], b[n:]
+}
+
+// reply returns an OP_REPLY message, with no cursor, answering the given
+// request with docs.
+func reply(requestID uint32, docs []interface{}) []byte {
+	b := make([]byte, 36)
+	for _, d := range docs {
+		data, err := bson.Marshal(d)
+		if err != nil {
+			panic(err)
+		}
+		b = append(b, data...)
+	}
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[8:], requestID)
+	binary.LittleEndian.PutUint32(b[12:], opReply)
+	binary.LittleEndian.PutUint32(b[32:], uint32(len(docs)))
+	return b
+}
+
+// unwrap decodes a query, returning its filter and sort order, which are
+// wrapped in $query and $orderby if there is an order.
+func unwrap(raw []byte) (filter bson.M, orderBy bson.D) {
+	var ordered bson.D
+	bson.Unmarshal(raw, &ordered)
+	bson.Unmarshal(raw, &filter)
+	if q, ok := filter["$query"]; ok {
+		filter, _ = q.(bson.M)
+		for _, e := range ordered {
+			if e.Name == "$orderby" {
+				orderBy = toD(e.Value)
+			}
+		}
+	}
+	return filter, orderBy
+}
+
+// toD returns a document decoded into a bson.D as a bson.D.
+func toD(v interface{}) bson.D {
+	switch v := v.(type) {
+	case bson.D:
+		return v
+	case bson.M:
+		var d bson.D
+		for k, e := range v {
+			d = append(d, bson.DocElem{Name: k, Value: e})
+		}
+		return d
+	}
+	return nil
+}
+
+// query returns the documents of a collection matching a query.
+func (m *fakeMongod) query(collection string, raw, fields []byte, skip, limit int) []interface{} {
+	filter, orderBy := unwrap(raw)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := m.find(collection, filter, orderBy)
+	if skip > len(found) {
+		skip = len(found)
+	}
+	found = found[skip:]
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+
+	var selected bson.M
+	if fields != nil {
+		bson.Unmarshal(fields, &selected)
+	}
+	docs := make([]interface{}, len(found))
+	for i, doc := range found {
+		docs[i] = project(doc, selected)
+	}
+	return docs
+}
+
+// find returns the documents of a collection matching filter, in the given
+// order. m.mu must be held.
+func (m *fakeMongod) find(collection string, filter bson.M, orderBy bson.D) []bson.M {
+	var found []bson.M
+	for _, doc := range m.collections[collection] {
+		if matches(doc, filter) {
+			found = append(found, doc)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, e := range orderBy {
+			c := compareValues(found[i][e.Name], found[j][e.Name])
+			if n, _ := number(e.Value); n < 0 {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return found
+}
+
+// command runs a command against a database, and returns its result.
+func (m *fakeMongod) command(db string, raw []byte) bson.M {
+	cmd, _ := unwrap(raw)
+	var ordered bson.D
+	bson.Unmarshal(raw, &ordered)
+	if len(ordered) == 0 {
+		return bson.M{"ok": 0, "errmsg": "no command"}
+	}
+	name := ordered[0].Name
+	if name == "$query" {
+		ordered = toD(ordered[0].Value)
+		name = ordered[0].Name
+	}
+	collection := fmt.Sprintf("%s.%v", db, cmd[name])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch strings.ToLower(name) {
+	case "ismaster":
+		return bson.M{"ismaster": true, "maxWireVersion": 2, "ok": 1}
+	case "getnonce":
+		// mgo asks for one on every connection.
+		return bson.M{"nonce": "fake", "ok": 1}
+
+	case "insert":
+		docs := cmd["documents"].([]interface{})
+		for _, d := range docs {
+			doc := d.(bson.M)
+			if _, ok := doc["_id"]; !ok {
+				doc["_id"] = bson.NewObjectId()
+			}
+			m.collections[collection] = append(m.collections[collection], doc)
+		}
+		return bson.M{"ok": 1, "n": len(docs)}
+
+	case "update":
+		n, modified := 0, 0
+		var upserted []bson.M
+		for i, u := range cmd["updates"].([]interface{}) {
+			u := u.(bson.M)
+			filter, _ := u["q"].(bson.M)
+			update, _ := u["u"].(bson.M)
+			found := m.find(collection, filter, nil)
+			if len(found) == 0 {
+				if upsert, _ := u["upsert"].(bool); upsert {
+					doc := m.upsert(collection, filter, update)
+					upserted = append(upserted, bson.M{"index": i, "_id": doc["_id"]})
+					n++
+				}
+				continue
+			}
+			if multi, _ := u["multi"].(bool); !multi {
+				found = found[:1]
+			}
+			for _, doc := range found {
+				m.replace(collection, doc, applyUpdate(doc, update))
+				n++
+				modified++
+			}
+		}
+		result := bson.M{"ok": 1, "n": n, "nModified": modified}
+		if len(upserted) > 0 {
+			result["upserted"] = upserted
+		}
+		return result
+
+	case "delete":
+		n := 0
+		for _, d := range cmd["deletes"].([]interface{}) {
+			d := d.(bson.M)
+			filter, _ := d["q"].(bson.M)
+			found := m.find(collection, filter, nil)
+			if limit, _ := number(d["limit"]); limit > 0 && len(found) > int(limit) {
+				found = found[:int(limit)]
+			}
+			for _, doc := range found {
+				m.replace(collection, doc, nil)
+				n++
+			}
+		}
+		return bson.M{"ok": 1, "n": n}
+
+	case "findandmodify":
+		filter, _ := cmd["query"].(bson.M)
+		update, _ := cmd["update"].(bson.M)
+		found := m.find(collection, filter, toD(cmd["sort"]))
+		returnNew, _ := cmd["new"].(bool)
+		if len(found) == 0 {
+			if upsert, _ := cmd["upsert"].(bool); upsert {
+				doc := m.upsert(collection, filter, update)
+				if !returnNew {
+					doc = nil
+				}
+				return bson.M{"ok": 1, "value": doc, "lastErrorObject": bson.M{"n": 1, "updatedExisting": false}}
+			}
+			return bson.M{"ok": 1, "value": nil, "lastErrorObject": bson.M{"n": 0}}
+		}
+		old := found[0]
+		doc := applyUpdate(old, update)
+		m.replace(collection, old, doc)
+		if !returnNew {
+			doc = old
+		}
+		return bson.M{"ok": 1, "value": doc, "lastErrorObject": bson.M{"n": 1, "updatedExisting": true}}
+
+	case "count":
+		filter, _ := cmd["query"].(bson.M)
+		return bson.M{"ok": 1, "n": len(m.find(collection, filter, nil))}
+
+	case "createindexes", "ping", "getlasterror":
+		return bson.M{"ok": 1}
+	}
+	return bson.M{"ok": 0, "errmsg": "no such command: " + name}
+}
+
+// replace replaces doc in a collection with the new one, or removes it if
+// the new one is nil. m.mu must be held.
+func (m *fakeMongod) replace(collection string, doc, new bson.M) {
+	docs := m.collections[collection]
+	for i, d := range docs {
+		if reflect.ValueOf(d).Pointer() != reflect.ValueOf(doc).Pointer() {
+			continue
+		}
+		if new == nil {
+			m.collections[collection] = append(docs[:i:i], docs[i+1:]...)
+		} else {
+			docs[i] = new
+		}
+		return
+	}
+}
+
+// upsert inserts the document made by applying update to the fields filter
+// requires, and returns it. m.mu must be held.
+func (m *fakeMongod) upsert(collection string, filter, update bson.M) bson.M {
+	base := bson.M{}
+	for k, v := range filter {
+		if _, isOp := v.(bson.M); !strings.HasPrefix(k, "$") && !isOp {
+			base[k] = v
+		}
+	}
+	doc := applyUpdate(base, update)
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	m.collections[collection] = append(m.collections[collection], doc)
+	return doc
+}
+
+// applyUpdate returns doc updated with the $set and $inc operators of
+// update, or replaced by update if it has no operators.
+func applyUpdate(doc, update bson.M) bson.M {
+	updated := bson.M{}
+	replace := true
+	for k := range update {
+		if strings.HasPrefix(k, "$") {
+			replace = false
+		}
+	}
+	if replace {
+		for k, v := range update {
+			updated[k] = v
+		}
+		if id, ok := doc["_id"]; ok {
+			updated["_id"] = id
+		}
+		return updated
+	}
+
+	for k, v := range doc {
+		updated[k] = v
+	}
+	if set, ok := update["$set"].(bson.M); ok {
+		for k, v := range set {
+			updated[k] = v
+		}
+	}
+	if inc, ok := update["$inc"].(bson.M); ok {
+		for k, v := range inc {
+			old, _ := number(updated[k])
+			by, _ := number(v)
+			updated[k] = int64(old + by)
+		}
+	}
+	return updated
+}
+
+// project returns the fields of doc selected by fields, or all of them if
+// fields is empty.
+func project(doc, fields bson.M) bson.M {
+	if len(fields) == 0 {
+		return doc
+	}
+	selected := bson.M{"_id": doc["_id"]}
+	for k := range fields {
+		if v, ok := doc[k]; ok {
+			selected[k] = v
+		}
+	}
+	return selected
+}
+
+// matches reports whether doc matches filter.
+func matches(doc, filter bson.M) bool {
+	for k, cond := range filter {
+		switch k {
+		case "$or":
+			matched := false
+			for _, f := range cond.([]interface{}) {
+				matched = matched || matches(doc, f.(bson.M))
+			}
+			if !matched {
+				return false
+			}
+		case "$and":
+			for _, f := range cond.([]interface{}) {
+				if !matches(doc, f.(bson.M)) {
+					return false
+				}
+			}
+		default:
+			v, present := doc[k]
+			if !matchesField(v, present, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesField reports whether a field with value v, if present, meets a
+// condition.
+func matchesField(v interface{}, present bool, cond interface{}) bool {
+	switch cond := cond.(type) {
+	case bson.RegEx:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		pattern := cond.Pattern
+		if strings.Contains(cond.Options, "i") {
+			pattern = "(?i)" + pattern
+		}
+		return regexp.MustCompile(pattern).MatchString(s)
+	case bson.M:
+		for op, arg := range cond {
+			ok := false
+			switch op {
+			case "$gt", "$lt":
+				c, comparable := compare(v, arg)
+				ok = present && comparable && (op == "$gt" && c > 0 || op == "$lt" && c < 0)
+			case "$in":
+				for _, e := range arg.([]interface{}) {
+					ok = ok || equal(v, present, e)
+				}
+			case "$ne":
+				ok = !equal(v, present, arg)
+			default:
+				panic("fakeMongod: unsupported operator " + op)
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return equal(v, present, cond)
+}
+
+// equal reports whether a field with value v, if present, equals want. A
+// nil want matches missing fields too.
+func equal(v interface{}, present bool, want interface{}) bool {
+	if want == nil {
+		return !present || v == nil
+	}
+	c, comparable := compare(v, want)
+	return present && comparable && c == 0
+}
+
+// number returns v as a float64, if it is a number.
+func number(v interface{}) (float64, bool) {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// compare compares two values of the same type, and reports whether they
+// are.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		switch {
+		case !ok:
+			return 0, false
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		y, ok := b.(bool)
+		switch {
+		case !ok:
+			return 0, false
+		case x == y:
+			return 0, true
+		case y:
+			return -1, true
+		}
+		return 1, true
+	}
+	// Strings and ObjectIds.
+	if ra, rb := reflect.ValueOf(a), reflect.ValueOf(b); ra.Kind() == reflect.String && rb.Kind() == reflect.String {
+		return strings.Compare(ra.String(), rb.String()), true
+	}
+	return 0, reflect.DeepEqual(a, b)
+}
+
+// compareValues orders values of any types, as MongoDB sorts them: missing
+// and null values first, then numbers, strings, booleans and dates.
+func compareValues(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		if v == nil {
+			return 0
+		}
+		if _, ok := number(v); ok {
+			return 1
+		}
+		switch v.(type) {
+		case bool:
+			return 3
+		case time.Time:
+			return 4
+		}
+		return 2
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	c, _ := compare(a, b)
+	return c
+}
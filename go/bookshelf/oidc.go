@@ -0,0 +1,96 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// FakeOIDCIssuer is the value of Config.OIDCIssuer selecting the local fake
+// OpenID Connect provider.
+const FakeOIDCIssuer = "fake"
+
+// FakeOIDCPath is the path under which the app serves the fake OpenID
+// Connect provider.
+const FakeOIDCPath = "/fake-oidc/"
+
+// configureOIDC sets OAuthConfig and OIDCVerifier, and FakeOIDC if the fake
+// provider is selected, for signing in with the OpenID Connect provider of
+// cfg.
+func configureOIDC(cfg *Config) error {
+	clientID := cfg.OAuthClientID
+	scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+
+	if cfg.OIDCIssuer == FakeOIDCIssuer {
+		// The fake provider is served by the app itself, so its endpoints
+		// are derived from the redirect URL. It can't be discovered now,
+		// since the app is not serving yet; its keys are fetched once the
+		// first ID token is verified.
+		u, err := url.Parse(cfg.OAuthRedirectURL)
+		if err != nil {
+			return fmt.Errorf("oidc: bad redirect URL %q: %v", cfg.OAuthRedirectURL, err)
+		}
+		issuer := u.Scheme + "://" + u.Host + FakeOIDCPath[:len(FakeOIDCPath)-1]
+		if clientID == "" {
+			clientID = "bookshelf"
+		}
+		fake, err := newFakeOIDCProvider(issuer, clientID)
+		if err != nil {
+			return err
+		}
+		FakeOIDC = fake
+		OAuthConfig = &oauth2.Config{
+			ClientID:    clientID,
+			RedirectURL: cfg.OAuthRedirectURL,
+			Scopes:      scopes,
+			Endpoint:    fake.endpoint(),
+		}
+		keys := oidc.NewRemoteKeySet(context.Background(), fake.keysURL())
+		OIDCVerifier = oidc.NewVerifier(issuer, keys, &oidc.Config{ClientID: clientID})
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), cfg.OIDCIssuer)
+	if err != nil {
+		return fmt.Errorf("oidc: could not discover provider %s: %v", cfg.OIDCIssuer, err)
+	}
+	OAuthConfig = &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: cfg.OAuthClientSecret,
+		RedirectURL:  cfg.OAuthRedirectURL,
+		Scopes:       scopes,
+		Endpoint:     provider.Endpoint(),
+	}
+	OIDCVerifier = provider.Verifier(&oidc.Config{ClientID: clientID})
+	return nil
+}
+
+// VerifyIDToken verifies a raw ID token with OIDCVerifier, and returns the
+// user it identifies and their name. It returns an error of
+// KindUnauthorized if the token is not valid.
+func VerifyIDToken(ctx context.Context, raw string) (u *User, name string, err error) {
+	idToken, err := OIDCVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, "", Errorf(KindUnauthorized, "oidc: bad ID token: %v", err)
+	}
+	var claims struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", Errorf(KindUnauthorized, "oidc: bad ID token claims: %v", err)
+	}
+	u = &User{ID: idToken.Subject, Email: claims.Email, EmailVerified: claims.EmailVerified}
+	if claims.Name == "" {
+		claims.Name = claims.Email
+	}
+	return u, claims.Name, nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// fakeOIDCProvider is an OpenID Connect provider for local development and
+// tests, which signs in anyone as whoever they claim to be. It must be
+// served at the path of its issuer URL.
+type fakeOIDCProvider struct {
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+	signer   jose.Signer
+
+	mu    sync.Mutex
+	codes map[string]*fakeOIDCGrant // authorization codes not yet exchanged.
+}
+
+// fakeOIDCGrant is what the user claimed to be on the fake sign-in page.
+type fakeOIDCGrant struct {
+	name, email string
+	expiry      time.Time
+}
+
+const fakeOIDCKeyID = "fake"
+
+func newFakeOIDCProvider(issuer, clientID string) (*fakeOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("fakeoidc: could not generate key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: fakeOIDCKeyID},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fakeoidc: could not create signer: %v", err)
+	}
+	log.Printf("Using the fake OpenID Connect provider at %s; anyone can sign in as anyone.", issuer)
+	return &fakeOIDCProvider{
+		issuer:   issuer,
+		clientID: clientID,
+		key:      key,
+		signer:   signer,
+		codes:    make(map[string]*fakeOIDCGrant),
+	}, nil
+}
+
+func (p *fakeOIDCProvider) endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  p.issuer + "/authorize",
+		TokenURL: p.issuer + "/token",
+	}
+}
+
+func (p *fakeOIDCProvider) keysURL() string {
+	return p.issuer + "/keys"
+}
+
+// ServeHTTP serves the discovery document, the keys, and the authorization
+// and token endpoints.
+func (p *fakeOIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, err := url.Parse(p.issuer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, u.Path) {
+	case "/.well-known/openid-configuration":
+		p.discovery(w, r)
+	case "/keys":
+		p.keys(w, r)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeFakeOIDCJSON(w, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.endpoint().AuthURL,
+		"token_endpoint":                        p.endpoint().TokenURL,
+		"jwks_uri":                              p.keysURL(),
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *fakeOIDCProvider) keys(w http.ResponseWriter, r *http.Request) {
+	writeFakeOIDCJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &p.key.PublicKey,
+		KeyID:     fakeOIDCKeyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+var fakeOIDCLoginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake sign-in</title></head>
+<body>
+<h1>Fake sign-in</h1>
+<p>This is a fake OpenID Connect provider for development. Sign in as anyone.</p>
+<form method="POST">
+  <p><label>Name <input name="name" value="Test User"></label></p>
+  <p><label>Email <input name="email" value="test@example.com"></label></p>
+  <input type="hidden" name="client_id" value="{{.client_id}}">
+  <input type="hidden" name="redirect_uri" value="{{.redirect_uri}}">
+  <input type="hidden" name="state" value="{{.state}}">
+  <button>Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// authorize shows a sign-in form, and redirects back to the client with an
+// authorization code once it is submitted.
+func (p *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if r.Method != "POST" {
+		fakeOIDCLoginTmpl.Execute(w, map[string]string{
+			"client_id":    r.FormValue("client_id"),
+			"redirect_uri": r.FormValue("redirect_uri"),
+			"state":        r.FormValue("state"),
+		})
+		return
+	}
+
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	code, err := randomHex(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = &fakeOIDCGrant{
+		name:   strings.TrimSpace(r.FormValue("name")),
+		email:  email,
+		expiry: time.Now().Add(10 * time.Minute),
+	}
+	p.mu.Unlock()
+
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.FormValue("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for an access token and a signed ID
+// token.
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	p.mu.Lock()
+	grant := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if grant == nil || time.Now().After(grant.expiry) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeFakeOIDCJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":            p.issuer,
+		"aud":            p.clientID,
+		"sub":            "fake-" + grant.email,
+		"email":          grant.email,
+		"email_verified": true,
+		"name":           grant.name,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jws, err := p.signer.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, err := jws.CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := randomHex(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeFakeOIDCJSON(w, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeFakeOIDCJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestFakeOIDC signs in through the fake provider, and checks the claims of
+// the verified ID token.
+func TestFakeOIDC(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := &Config{
+		OIDCIssuer:       FakeOIDCIssuer,
+		OAuthRedirectURL: srv.URL + "/oauth2callback",
+	}
+	if err := configureOIDC(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { OAuthConfig, OIDCVerifier, FakeOIDC = nil, nil, nil }()
+	mux.Handle(FakeOIDCPath, FakeOIDC)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.PostForm(OAuthConfig.Endpoint.AuthURL, url.Values{
+		"client_id":    {OAuthConfig.ClientID},
+		"redirect_uri": {OAuthConfig.RedirectURL},
+		"state":        {"xyz"},
+		"name":         {"Ada Lovelace"},
+		"email":        {"ada@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize: got status %d and no redirect: %v", resp.StatusCode, err)
+	}
+	if got := loc.Query().Get("state"); got != "xyz" {
+		t.Errorf("state: got %q, want %q", got, "xyz")
+	}
+
+	ctx := context.Background()
+	tok, err := OAuthConfig.Exchange(ctx, loc.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	idToken, err := OIDCVerifier.Verify(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Name != "Ada Lovelace" || claims.Email != "ada@example.com" || idToken.Subject == "" {
+		t.Errorf("claims: got %+v, subject %q", claims, idToken.Subject)
+	}
+
+	// Codes can be exchanged only once.
+	if _, err := OAuthConfig.Exchange(ctx, loc.Query().Get("code")); err == nil {
+		t.Error("second exchange of the same code succeeded, want error")
+	}
+}
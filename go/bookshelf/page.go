@@ -0,0 +1,75 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	// DefaultPageSize is the page size used when a non-positive one is
+	// requested.
+	DefaultPageSize = 20
+
+	// MaxPageSize is the largest page size a BookDatabase will return.
+	MaxPageSize = 1000
+)
+
+// pageSize returns the page size to use for a requested page size.
+func pageSize(requested int) int {
+	switch {
+	case requested <= 0:
+		return DefaultPageSize
+	case requested > MaxPageSize:
+		return MaxPageSize
+	default:
+		return requested
+	}
+}
+
+// keysetCursor is the position after which a page of books ordered by
+// (Title, ID) starts. It is used by backends without native cursors.
+type keysetCursor struct {
+	Title string `json:"t"`
+	ID    int64  `json:"i"`
+}
+
+// encodeCursor returns the opaque cursor of the page that follows b.
+func encodeCursor(b *Book) string {
+	j, err := json.Marshal(keysetCursor{Title: b.Title, ID: b.ID})
+	if err != nil {
+		// Marshaling a string and an int cannot fail.
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor. The empty cursor
+// denotes the first page, and decodes to nil.
+func decodeCursor(cursor string) (*keysetCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	j, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, Errorf(KindInvalidArgument, "invalid cursor %q: %v", cursor, err)
+	}
+	c := &keysetCursor{}
+	if err := json.Unmarshal(j, c); err != nil {
+		return nil, Errorf(KindInvalidArgument, "invalid cursor %q: %v", cursor, err)
+	}
+	return c, nil
+}
+
+// trimPage trims books, which were fetched with a limit of size+1, to size
+// and returns the cursor of the next page, or "" if this is the last page.
+func trimPage(books []*Book, size int) ([]*Book, string) {
+	if len(books) <= size {
+		return books, ""
+	}
+	books = books[:size]
+	return books, encodeCursor(books[size-1])
+}
@@ -0,0 +1,781 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: bookshelf.proto
+
+package bookshelfpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type Book struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Title                string   `protobuf:"bytes,2,opt,name=title" json:"title,omitempty"`
+	Author               string   `protobuf:"bytes,3,opt,name=author" json:"author,omitempty"`
+	PublishedDate        string   `protobuf:"bytes,4,opt,name=published_date,json=publishedDate" json:"published_date,omitempty"`
+	ImageUrl             string   `protobuf:"bytes,5,opt,name=image_url,json=imageUrl" json:"image_url,omitempty"`
+	Description          string   `protobuf:"bytes,6,opt,name=description" json:"description,omitempty"`
+	CreatedBy            string   `protobuf:"bytes,7,opt,name=created_by,json=createdBy" json:"created_by,omitempty"`
+	CreatedById          string   `protobuf:"bytes,8,opt,name=created_by_id,json=createdById" json:"created_by_id,omitempty"`
+	Isbn                 string   `protobuf:"bytes,9,opt,name=isbn" json:"isbn,omitempty"`
+	Version              int64    `protobuf:"varint,10,opt,name=version" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Book) Reset()         { *m = Book{} }
+func (m *Book) String() string { return proto.CompactTextString(m) }
+func (*Book) ProtoMessage()    {}
+func (*Book) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{0}
+}
+func (m *Book) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Book.Unmarshal(m, b)
+}
+func (m *Book) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Book.Marshal(b, m, deterministic)
+}
+func (dst *Book) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Book.Merge(dst, src)
+}
+func (m *Book) XXX_Size() int {
+	return xxx_messageInfo_Book.Size(m)
+}
+func (m *Book) XXX_DiscardUnknown() {
+	xxx_messageInfo_Book.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Book proto.InternalMessageInfo
+
+func (m *Book) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Book) GetTitle() string {
+	if m != nil {
+		return m.Title
+	}
+	return ""
+}
+
+func (m *Book) GetAuthor() string {
+	if m != nil {
+		return m.Author
+	}
+	return ""
+}
+
+func (m *Book) GetPublishedDate() string {
+	if m != nil {
+		return m.PublishedDate
+	}
+	return ""
+}
+
+func (m *Book) GetImageUrl() string {
+	if m != nil {
+		return m.ImageUrl
+	}
+	return ""
+}
+
+func (m *Book) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *Book) GetCreatedBy() string {
+	if m != nil {
+		return m.CreatedBy
+	}
+	return ""
+}
+
+func (m *Book) GetCreatedById() string {
+	if m != nil {
+		return m.CreatedById
+	}
+	return ""
+}
+
+func (m *Book) GetIsbn() string {
+	if m != nil {
+		return m.Isbn
+	}
+	return ""
+}
+
+func (m *Book) GetVersion() int64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type ListBooksRequest struct {
+	PageSize             int32    `protobuf:"varint,1,opt,name=page_size,json=pageSize" json:"page_size,omitempty"`
+	PageToken            string   `protobuf:"bytes,2,opt,name=page_token,json=pageToken" json:"page_token,omitempty"`
+	CreatedById          string   `protobuf:"bytes,3,opt,name=created_by_id,json=createdById" json:"created_by_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListBooksRequest) Reset()         { *m = ListBooksRequest{} }
+func (m *ListBooksRequest) String() string { return proto.CompactTextString(m) }
+func (*ListBooksRequest) ProtoMessage()    {}
+func (*ListBooksRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{1}
+}
+func (m *ListBooksRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListBooksRequest.Unmarshal(m, b)
+}
+func (m *ListBooksRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListBooksRequest.Marshal(b, m, deterministic)
+}
+func (dst *ListBooksRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListBooksRequest.Merge(dst, src)
+}
+func (m *ListBooksRequest) XXX_Size() int {
+	return xxx_messageInfo_ListBooksRequest.Size(m)
+}
+func (m *ListBooksRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListBooksRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListBooksRequest proto.InternalMessageInfo
+
+func (m *ListBooksRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+func (m *ListBooksRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
+func (m *ListBooksRequest) GetCreatedById() string {
+	if m != nil {
+		return m.CreatedById
+	}
+	return ""
+}
+
+type ListBooksResponse struct {
+	Books                []*Book  `protobuf:"bytes,1,rep,name=books" json:"books,omitempty"`
+	NextPageToken        string   `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken" json:"next_page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListBooksResponse) Reset()         { *m = ListBooksResponse{} }
+func (m *ListBooksResponse) String() string { return proto.CompactTextString(m) }
+func (*ListBooksResponse) ProtoMessage()    {}
+func (*ListBooksResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{2}
+}
+func (m *ListBooksResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListBooksResponse.Unmarshal(m, b)
+}
+func (m *ListBooksResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListBooksResponse.Marshal(b, m, deterministic)
+}
+func (dst *ListBooksResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListBooksResponse.Merge(dst, src)
+}
+func (m *ListBooksResponse) XXX_Size() int {
+	return xxx_messageInfo_ListBooksResponse.Size(m)
+}
+func (m *ListBooksResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListBooksResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListBooksResponse proto.InternalMessageInfo
+
+func (m *ListBooksResponse) GetBooks() []*Book {
+	if m != nil {
+		return m.Books
+	}
+	return nil
+}
+
+func (m *ListBooksResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
+
+type GetBookRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetBookRequest) Reset()         { *m = GetBookRequest{} }
+func (m *GetBookRequest) String() string { return proto.CompactTextString(m) }
+func (*GetBookRequest) ProtoMessage()    {}
+func (*GetBookRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{3}
+}
+func (m *GetBookRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetBookRequest.Unmarshal(m, b)
+}
+func (m *GetBookRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetBookRequest.Marshal(b, m, deterministic)
+}
+func (dst *GetBookRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetBookRequest.Merge(dst, src)
+}
+func (m *GetBookRequest) XXX_Size() int {
+	return xxx_messageInfo_GetBookRequest.Size(m)
+}
+func (m *GetBookRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetBookRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetBookRequest proto.InternalMessageInfo
+
+func (m *GetBookRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type CreateBookRequest struct {
+	Book                 *Book    `protobuf:"bytes,1,opt,name=book" json:"book,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateBookRequest) Reset()         { *m = CreateBookRequest{} }
+func (m *CreateBookRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateBookRequest) ProtoMessage()    {}
+func (*CreateBookRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{4}
+}
+func (m *CreateBookRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateBookRequest.Unmarshal(m, b)
+}
+func (m *CreateBookRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateBookRequest.Marshal(b, m, deterministic)
+}
+func (dst *CreateBookRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateBookRequest.Merge(dst, src)
+}
+func (m *CreateBookRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateBookRequest.Size(m)
+}
+func (m *CreateBookRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateBookRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateBookRequest proto.InternalMessageInfo
+
+func (m *CreateBookRequest) GetBook() *Book {
+	if m != nil {
+		return m.Book
+	}
+	return nil
+}
+
+type UpdateBookRequest struct {
+	Book                 *Book    `protobuf:"bytes,1,opt,name=book" json:"book,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpdateBookRequest) Reset()         { *m = UpdateBookRequest{} }
+func (m *UpdateBookRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateBookRequest) ProtoMessage()    {}
+func (*UpdateBookRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{5}
+}
+func (m *UpdateBookRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateBookRequest.Unmarshal(m, b)
+}
+func (m *UpdateBookRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateBookRequest.Marshal(b, m, deterministic)
+}
+func (dst *UpdateBookRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateBookRequest.Merge(dst, src)
+}
+func (m *UpdateBookRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateBookRequest.Size(m)
+}
+func (m *UpdateBookRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateBookRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateBookRequest proto.InternalMessageInfo
+
+func (m *UpdateBookRequest) GetBook() *Book {
+	if m != nil {
+		return m.Book
+	}
+	return nil
+}
+
+type DeleteBookRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteBookRequest) Reset()         { *m = DeleteBookRequest{} }
+func (m *DeleteBookRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteBookRequest) ProtoMessage()    {}
+func (*DeleteBookRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{6}
+}
+func (m *DeleteBookRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteBookRequest.Unmarshal(m, b)
+}
+func (m *DeleteBookRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteBookRequest.Marshal(b, m, deterministic)
+}
+func (dst *DeleteBookRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteBookRequest.Merge(dst, src)
+}
+func (m *DeleteBookRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteBookRequest.Size(m)
+}
+func (m *DeleteBookRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteBookRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteBookRequest proto.InternalMessageInfo
+
+func (m *DeleteBookRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type DeleteBookResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteBookResponse) Reset()         { *m = DeleteBookResponse{} }
+func (m *DeleteBookResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteBookResponse) ProtoMessage()    {}
+func (*DeleteBookResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{7}
+}
+func (m *DeleteBookResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteBookResponse.Unmarshal(m, b)
+}
+func (m *DeleteBookResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteBookResponse.Marshal(b, m, deterministic)
+}
+func (dst *DeleteBookResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteBookResponse.Merge(dst, src)
+}
+func (m *DeleteBookResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteBookResponse.Size(m)
+}
+func (m *DeleteBookResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteBookResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteBookResponse proto.InternalMessageInfo
+
+type SearchBooksRequest struct {
+	Query                string   `protobuf:"bytes,1,opt,name=query" json:"query,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchBooksRequest) Reset()         { *m = SearchBooksRequest{} }
+func (m *SearchBooksRequest) String() string { return proto.CompactTextString(m) }
+func (*SearchBooksRequest) ProtoMessage()    {}
+func (*SearchBooksRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{8}
+}
+func (m *SearchBooksRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchBooksRequest.Unmarshal(m, b)
+}
+func (m *SearchBooksRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchBooksRequest.Marshal(b, m, deterministic)
+}
+func (dst *SearchBooksRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchBooksRequest.Merge(dst, src)
+}
+func (m *SearchBooksRequest) XXX_Size() int {
+	return xxx_messageInfo_SearchBooksRequest.Size(m)
+}
+func (m *SearchBooksRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchBooksRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchBooksRequest proto.InternalMessageInfo
+
+func (m *SearchBooksRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+type SearchBooksResponse struct {
+	Books                []*Book  `protobuf:"bytes,1,rep,name=books" json:"books,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchBooksResponse) Reset()         { *m = SearchBooksResponse{} }
+func (m *SearchBooksResponse) String() string { return proto.CompactTextString(m) }
+func (*SearchBooksResponse) ProtoMessage()    {}
+func (*SearchBooksResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bookshelf_17f9b4f1a43c0fe4, []int{9}
+}
+func (m *SearchBooksResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchBooksResponse.Unmarshal(m, b)
+}
+func (m *SearchBooksResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchBooksResponse.Marshal(b, m, deterministic)
+}
+func (dst *SearchBooksResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchBooksResponse.Merge(dst, src)
+}
+func (m *SearchBooksResponse) XXX_Size() int {
+	return xxx_messageInfo_SearchBooksResponse.Size(m)
+}
+func (m *SearchBooksResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchBooksResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchBooksResponse proto.InternalMessageInfo
+
+func (m *SearchBooksResponse) GetBooks() []*Book {
+	if m != nil {
+		return m.Books
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Book)(nil), "bookshelf.Book")
+	proto.RegisterType((*ListBooksRequest)(nil), "bookshelf.ListBooksRequest")
+	proto.RegisterType((*ListBooksResponse)(nil), "bookshelf.ListBooksResponse")
+	proto.RegisterType((*GetBookRequest)(nil), "bookshelf.GetBookRequest")
+	proto.RegisterType((*CreateBookRequest)(nil), "bookshelf.CreateBookRequest")
+	proto.RegisterType((*UpdateBookRequest)(nil), "bookshelf.UpdateBookRequest")
+	proto.RegisterType((*DeleteBookRequest)(nil), "bookshelf.DeleteBookRequest")
+	proto.RegisterType((*DeleteBookResponse)(nil), "bookshelf.DeleteBookResponse")
+	proto.RegisterType((*SearchBooksRequest)(nil), "bookshelf.SearchBooksRequest")
+	proto.RegisterType((*SearchBooksResponse)(nil), "bookshelf.SearchBooksResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for BookService service
+
+type BookServiceClient interface {
+	ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (*ListBooksResponse, error)
+	GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error)
+	CreateBook(ctx context.Context, in *CreateBookRequest, opts ...grpc.CallOption) (*Book, error)
+	UpdateBook(ctx context.Context, in *UpdateBookRequest, opts ...grpc.CallOption) (*Book, error)
+	DeleteBook(ctx context.Context, in *DeleteBookRequest, opts ...grpc.CallOption) (*DeleteBookResponse, error)
+	SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (*SearchBooksResponse, error)
+}
+
+type bookServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBookServiceClient(cc *grpc.ClientConn) BookServiceClient {
+	return &bookServiceClient{cc}
+}
+
+func (c *bookServiceClient) ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (*ListBooksResponse, error) {
+	out := new(ListBooksResponse)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/ListBooks", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/GetBook", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) CreateBook(ctx context.Context, in *CreateBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/CreateBook", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) UpdateBook(ctx context.Context, in *UpdateBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/UpdateBook", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) DeleteBook(ctx context.Context, in *DeleteBookRequest, opts ...grpc.CallOption) (*DeleteBookResponse, error) {
+	out := new(DeleteBookResponse)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/DeleteBook", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (*SearchBooksResponse, error) {
+	out := new(SearchBooksResponse)
+	err := grpc.Invoke(ctx, "/bookshelf.BookService/SearchBooks", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for BookService service
+
+type BookServiceServer interface {
+	ListBooks(context.Context, *ListBooksRequest) (*ListBooksResponse, error)
+	GetBook(context.Context, *GetBookRequest) (*Book, error)
+	CreateBook(context.Context, *CreateBookRequest) (*Book, error)
+	UpdateBook(context.Context, *UpdateBookRequest) (*Book, error)
+	DeleteBook(context.Context, *DeleteBookRequest) (*DeleteBookResponse, error)
+	SearchBooks(context.Context, *SearchBooksRequest) (*SearchBooksResponse, error)
+}
+
+func RegisterBookServiceServer(s *grpc.Server, srv BookServiceServer) {
+	s.RegisterService(&_BookService_serviceDesc, srv)
+}
+
+func _BookService_ListBooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).ListBooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/ListBooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).ListBooks(ctx, req.(*ListBooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_GetBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).GetBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/GetBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).GetBook(ctx, req.(*GetBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_CreateBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).CreateBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/CreateBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).CreateBook(ctx, req.(*CreateBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_UpdateBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).UpdateBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/UpdateBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).UpdateBook(ctx, req.(*UpdateBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_DeleteBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).DeleteBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/DeleteBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).DeleteBook(ctx, req.(*DeleteBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_SearchBooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchBooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).SearchBooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookshelf.BookService/SearchBooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).SearchBooks(ctx, req.(*SearchBooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _BookService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "bookshelf.BookService",
+	HandlerType: (*BookServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListBooks",
+			Handler:    _BookService_ListBooks_Handler,
+		},
+		{
+			MethodName: "GetBook",
+			Handler:    _BookService_GetBook_Handler,
+		},
+		{
+			MethodName: "CreateBook",
+			Handler:    _BookService_CreateBook_Handler,
+		},
+		{
+			MethodName: "UpdateBook",
+			Handler:    _BookService_UpdateBook_Handler,
+		},
+		{
+			MethodName: "DeleteBook",
+			Handler:    _BookService_DeleteBook_Handler,
+		},
+		{
+			MethodName: "SearchBooks",
+			Handler:    _BookService_SearchBooks_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "bookshelf.proto",
+}
+
+func init() { proto.RegisterFile("bookshelf.proto", fileDescriptor_bookshelf_17f9b4f1a43c0fe4) }
+
+var fileDescriptor_bookshelf_17f9b4f1a43c0fe4 = []byte{
+	// 525 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0x4f, 0x8f, 0x12, 0x4f,
+	0x10, 0x5d, 0xfe, 0x33, 0x45, 0x80, 0x1f, 0xf5, 0x23, 0xa6, 0x65, 0x5d, 0x43, 0x66, 0xb3, 0x86,
+	0x78, 0xd8, 0xc3, 0x7a, 0xd0, 0x83, 0x5e, 0x70, 0x13, 0x35, 0x1a, 0x63, 0x06, 0xf7, 0xe2, 0x85,
+	0xcc, 0x30, 0xa5, 0x74, 0x18, 0xe9, 0xd9, 0xee, 0x66, 0x23, 0xfb, 0x55, 0xfc, 0x08, 0x7e, 0x49,
+	0xd3, 0x0d, 0xcc, 0x1f, 0x06, 0x4c, 0xf4, 0x46, 0xbd, 0xf7, 0xaa, 0xeb, 0x55, 0x3d, 0x32, 0xd0,
+	0x0d, 0x84, 0x58, 0xa8, 0x39, 0x45, 0x5f, 0x2f, 0x63, 0x29, 0xb4, 0x40, 0x27, 0x01, 0xdc, 0x9f,
+	0x65, 0xa8, 0x8e, 0x85, 0x58, 0x60, 0x07, 0xca, 0x3c, 0x64, 0xa5, 0x61, 0x69, 0x54, 0xf1, 0xca,
+	0x3c, 0xc4, 0x3e, 0xd4, 0x34, 0xd7, 0x11, 0xb1, 0xf2, 0xb0, 0x34, 0x72, 0xbc, 0x4d, 0x81, 0x0f,
+	0xa0, 0xee, 0xaf, 0xf4, 0x5c, 0x48, 0x56, 0xb1, 0xf0, 0xb6, 0xc2, 0x0b, 0xe8, 0xc4, 0xab, 0x20,
+	0xe2, 0x6a, 0x4e, 0xe1, 0x34, 0xf4, 0x35, 0xb1, 0xaa, 0xe5, 0xdb, 0x09, 0x7a, 0xed, 0x6b, 0xc2,
+	0x53, 0x70, 0xf8, 0x77, 0xff, 0x1b, 0x4d, 0x57, 0x32, 0x62, 0x35, 0xab, 0x68, 0x5a, 0xe0, 0x46,
+	0x46, 0x38, 0x84, 0x56, 0x48, 0x6a, 0x26, 0x79, 0xac, 0xb9, 0x58, 0xb2, 0xba, 0xa5, 0xb3, 0x10,
+	0x9e, 0x01, 0xcc, 0x24, 0xf9, 0x9a, 0xc2, 0x69, 0xb0, 0x66, 0x0d, 0x2b, 0x70, 0xb6, 0xc8, 0x78,
+	0x8d, 0x2e, 0xb4, 0x53, 0x7a, 0xca, 0x43, 0xd6, 0xdc, 0x3c, 0x91, 0x28, 0xde, 0x85, 0x88, 0x50,
+	0xe5, 0x2a, 0x58, 0x32, 0xc7, 0x52, 0xf6, 0x37, 0x32, 0x68, 0xdc, 0x91, 0x54, 0x66, 0x28, 0xd8,
+	0xfd, 0x77, 0xa5, 0x2b, 0xe1, 0xbf, 0x0f, 0x5c, 0x69, 0x73, 0x20, 0xe5, 0xd1, 0xed, 0x8a, 0x94,
+	0x36, 0x3b, 0xc4, 0x66, 0x05, 0xc5, 0xef, 0xc9, 0xde, 0xab, 0xe6, 0x35, 0x0d, 0x30, 0xe1, 0xf7,
+	0x64, 0x1c, 0x5a, 0x52, 0x8b, 0x05, 0x2d, 0xb7, 0xa7, 0xb3, 0xf2, 0xcf, 0x06, 0x28, 0x3a, 0xac,
+	0x14, 0x1c, 0xba, 0x01, 0xf4, 0x32, 0x33, 0x55, 0x2c, 0x96, 0x8a, 0xf0, 0x02, 0x6a, 0x36, 0x33,
+	0x56, 0x1a, 0x56, 0x46, 0xad, 0xab, 0xee, 0x65, 0x1a, 0xa9, 0x11, 0x7a, 0x1b, 0x16, 0x9f, 0x40,
+	0x77, 0x49, 0x3f, 0xf4, 0xb4, 0xe0, 0xa1, 0x6d, 0xe0, 0x4f, 0x3b, 0x1f, 0xee, 0x10, 0x3a, 0x6f,
+	0xc8, 0x8e, 0xd8, 0x6d, 0xb5, 0x17, 0xbf, 0xfb, 0x02, 0x7a, 0xaf, 0xad, 0xa9, 0xac, 0xe8, 0x1c,
+	0xaa, 0x66, 0x8e, 0x95, 0x1d, 0x30, 0x61, 0x49, 0xd3, 0x79, 0x13, 0x87, 0xff, 0xd2, 0x79, 0x0e,
+	0xbd, 0x6b, 0x8a, 0x48, 0xd3, 0x9f, 0x8c, 0xf5, 0x01, 0xb3, 0xa2, 0xcd, 0x7d, 0xdc, 0xa7, 0x80,
+	0x13, 0xf2, 0xe5, 0x6c, 0x9e, 0x8b, 0xaa, 0x0f, 0xb5, 0xdb, 0x15, 0xc9, 0xb5, 0x6d, 0x77, 0xbc,
+	0x4d, 0xe1, 0xbe, 0x84, 0xff, 0x73, 0xda, 0xbf, 0x3a, 0xf1, 0xd5, 0xaf, 0x0a, 0xb4, 0x4c, 0x3d,
+	0x21, 0x79, 0xc7, 0x67, 0x84, 0x6f, 0xc1, 0x49, 0xe2, 0xc2, 0xd3, 0x4c, 0xd3, 0xfe, 0x1f, 0x67,
+	0xf0, 0xe8, 0x30, 0xb9, 0xdd, 0xe0, 0x04, 0x9f, 0x43, 0x63, 0x1b, 0x0a, 0x3e, 0xcc, 0x48, 0xf3,
+	0x41, 0x0d, 0xf6, 0x7d, 0xb9, 0x27, 0xf8, 0x0a, 0x20, 0xcd, 0x0a, 0xb3, 0x63, 0x0a, 0x11, 0x1e,
+	0x69, 0x4f, 0x03, 0xcb, 0xb5, 0x17, 0x72, 0x3c, 0xd4, 0xfe, 0x1e, 0x20, 0x0d, 0x24, 0xd7, 0x5e,
+	0x08, 0x73, 0x70, 0x76, 0x84, 0x4d, 0x6e, 0xf0, 0x11, 0x5a, 0x99, 0x6c, 0x30, 0xab, 0x2f, 0xe6,
+	0x3b, 0x78, 0x7c, 0x8c, 0xde, 0xbd, 0x37, 0x6e, 0x7f, 0x69, 0x25, 0x92, 0x38, 0x08, 0xea, 0xf6,
+	0xfb, 0xf7, 0xec, 0xf7, 0x00, 0x85, 0x43, 0xdc, 0xef, 0x12, 0x05, 0x00, 0x00,
+}
@@ -0,0 +1,9 @@
+#!/bin/bash -e
+#
+# Copyright 2018 Google Inc. All rights reserved.
+# Use of this source code is governed by the Apache 2.0
+# license that can be found in the LICENSE file.
+
+PATH=$PATH:$GOPATH/bin
+
+protoc --go_out=plugins=grpc:. bookshelf.proto
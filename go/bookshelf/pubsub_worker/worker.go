@@ -7,83 +7,48 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"flag"
+	"html/template"
 	"log"
 	"net/http"
 	"os"
-	"strings"
-	"sync"
-
-	books "google.golang.org/api/books/v1"
-
-	"cloud.google.com/go/pubsub"
 
 	"golang.org/x/net/context"
 
 	"github.com/census-ecosystem/opencensus-experiments/go/bookshelf"
-	"go.opencensus.io/trace"
-)
-
-const subName = "book-worker-sub"
-
-var (
-	countMu sync.Mutex
-	count   int
-
-	booksClient  *books.Service
-	subscription *pubsub.Subscription
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/zpages"
 )
 
 func main() {
-	ctx := context.Background()
-
-	if bookshelf.PubsubClient == nil {
-		log.Fatal("You must configure the Pub/Sub client in config.go before running pubsub_worker.")
-	}
-
-	var err error
-	booksClient, err = books.New(http.DefaultClient)
+	cfg, err := bookshelf.LoadConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		log.Fatalf("could not access Google Books API: %v", err)
+		log.Fatal(err)
 	}
-
-	// [START pubsub_create_topic]
-	// Create pubsub topic if it does not yet exist.
-	topic := bookshelf.PubsubClient.Topic(bookshelf.PubsubTopicID)
-	exists, err := topic.Exists(ctx)
-	if err != nil {
-		log.Fatalf("Error checking for topic: %v", err)
+	// The worker never serves reads, so caching them is wasted; its
+	// changes are published to the processes that do.
+	cfg.CacheSize = "0"
+	if err := bookshelf.Configure(cfg); err != nil {
+		log.Fatal(err)
 	}
-	if !exists {
-		if _, err := bookshelf.PubsubClient.CreateTopic(ctx, bookshelf.PubsubTopicID); err != nil {
-			log.Fatalf("Failed to create topic: %v", err)
-		}
+	if cfg.Queue != "pubsub" {
+		log.Fatal("pubsub_worker needs -queue=pubsub; with -queue=memory the app runs the worker itself.")
 	}
 
-	// Create topic subscription if it does not yet exist.
-	subscription = bookshelf.PubsubClient.Subscription(subName)
-	exists, err = subscription.Exists(ctx)
+	worker, err := bookshelf.NewWorker(bookshelf.DB, bookshelf.Updates)
 	if err != nil {
-		log.Fatalf("Error checking for subscription: %v", err)
-	}
-	if !exists {
-		if _, err = bookshelf.PubsubClient.CreateSubscription(ctx, subName, pubsub.SubscriptionConfig{Topic: topic}); err != nil {
-			log.Fatalf("Failed to create subscription: %v", err)
-		}
+		log.Fatal(err)
 	}
-	// [END pubsub_create_topic]
 
 	// Start worker goroutine.
-	go subscribe()
+	go func() {
+		log.Fatal(worker.Run(context.Background()))
+	}()
 
 	// [START http]
-	// Publish a count of processed requests to the server homepage.
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		countMu.Lock()
-		defer countMu.Unlock()
-		fmt.Fprintf(w, "This worker has processed %d books.", count)
-	})
+	// Publish the worker's status to the server homepage.
+	http.Handle("/", &ochttp.Handler{Handler: http.HandlerFunc(statusHandler)})
+	http.Handle("/debug/zpages/", http.StripPrefix("/debug/zpages", zpages.Handler))
 
 	port := "8080"
 	if p := os.Getenv("PORT"); p != "" {
@@ -93,73 +58,33 @@ func main() {
 	// [END http]
 }
 
-func subscribe() {
-	ctx := context.Background()
-	err := subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		ctx, span := trace.StartSpan(ctx, "worker/subscribe.Receive")
-		defer span.End()
-		var id int64
-		if err := json.Unmarshal(msg.Data, &id); err != nil {
-			log.Printf("could not decode message data: %#v", msg)
-			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
-			msg.Ack()
-			return
-		}
-
-		span.AddAttributes(trace.Int64Attribute("id", id))
-
-		log.Printf("[ID %d] Processing.", id)
-		if err := update(ctx, id); err != nil {
-			log.Printf("[ID %d] could not update: %v", id, err)
-			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
-			msg.Nack()
-			return
-		}
-
-		span.Annotatef(nil, "Acquiring lock")
-		countMu.Lock()
-		span.Annotatef(nil, "Lock acquired")
-		count++
-		countMu.Unlock()
-		span.Annotatef(nil, "Lock released")
-
-		msg.Ack()
-		log.Printf("[ID %d] ACK", id)
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-// update retrieves the book with the given ID, finds metata from the Books
-// server and updates the database with the book's details.
-func update(ctx context.Context, bookID int64) error {
-	book, err := bookshelf.DB.GetBook(ctx, bookID)
-	if err != nil {
-		return err
+var statusTmpl = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Bookshelf worker</title></head>
+<body>
+<h1>Bookshelf worker</h1>
+<p>This worker has processed {{.Processed}} book updates.</p>
+<table>
+<tr><td>Succeeded</td><td>{{.Succeeded}}</td></tr>
+<tr><td>Skipped (no volumes found)</td><td>{{.Skipped}}</td></tr>
+<tr><td>Failed</td><td>{{.Failed}}</td></tr>
+<tr><td>&hellip; of which dead-lettered</td><td>{{.DeadLettered}}</td></tr>
+<tr><td>&hellip; of which dropped (book deleted)</td><td>{{.Dropped}}</td></tr>
+<tr><td>Mean lag</td><td>{{.MeanLag}}</td></tr>
+<tr><td>Mean Books API latency</td><td>{{.MeanBooksAPILatency}}</td></tr>
+</table>
+<p><a href="/debug/zpages/tracez">Traces</a> &middot; <a href="/debug/zpages/rpcz">RPCs</a></p>
+</body>
+</html>
+`))
+
+// statusHandler renders the worker's status.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
 	}
-
-	vols, err := booksClient.Volumes.List(book.Title).Do()
-	if err != nil {
-		return err
-	}
-
-	if len(vols.Items) == 0 {
-		return nil
+	if err := statusTmpl.Execute(w, bookshelf.ReadWorkerStatus()); err != nil {
+		log.Printf("could not render worker status: %v", err)
 	}
-
-	info := vols.Items[0].VolumeInfo
-	book.Title = info.Title
-	book.Author = strings.Join(info.Authors, ", ")
-	book.PublishedDate = info.PublishedDate
-	if book.Description == "" {
-		book.Description = info.Description
-	}
-	if book.ImageURL == "" && info.ImageLinks != nil {
-		url := info.ImageLinks.Thumbnail
-		// Replace http with https to prevent Content Security errors on the page.
-		book.ImageURL = strings.Replace(url, "http://", "https://", 1)
-	}
-
-	return bookshelf.DB.UpdateBook(ctx, book)
 }
@@ -0,0 +1,106 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
+	"golang.org/x/net/context"
+)
+
+// UpdateMessage notifies a subscriber that a book has been added or
+// modified.
+type UpdateMessage struct {
+	BookID int64
+	// DeliveryAttempt is 1 for the first delivery of the notification, and
+	// is incremented each time it is retried.
+	DeliveryAttempt int
+	// Attributes carry the trace context and tags of the publisher.
+	Attributes map[string]string
+}
+
+// Message attributes holding the publisher's span context and tags, in the
+// OpenCensus binary formats encoded as base64.
+const (
+	traceContextAttribute = "opencensus-trace-context"
+	tagsAttribute         = "opencensus-tags"
+)
+
+// publishTimeAttribute holds the time the update was first published, in
+// nanoseconds since the Unix epoch. Retries keep the original time, so that
+// the worker can measure the lag of the whole pipeline.
+const publishTimeAttribute = "publish-time"
+
+// updateAttributes returns the message attributes propagating the publish
+// time, and the span context and tags of ctx, to the subscriber.
+func updateAttributes(ctx context.Context) map[string]string {
+	attrs := map[string]string{
+		publishTimeAttribute: strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		attrs[traceContextAttribute] = base64.StdEncoding.EncodeToString(propagation.Binary(span.SpanContext()))
+	}
+	if m := tag.FromContext(ctx); m != nil {
+		attrs[tagsAttribute] = base64.StdEncoding.EncodeToString(tag.Encode(m))
+	}
+	return attrs
+}
+
+// publisherContext returns ctx with the tags of the publisher of m, and the
+// publisher's span context if m has one. Malformed attributes are ignored,
+// since they only affect observability.
+func (m *UpdateMessage) publisherContext(ctx context.Context) (context.Context, trace.SpanContext, bool) {
+	if b, err := base64.StdEncoding.DecodeString(m.Attributes[tagsAttribute]); err == nil && len(b) > 0 {
+		if tags, err := tag.Decode(b); err == nil {
+			ctx = tag.NewContext(ctx, tags)
+		}
+	}
+	b, err := base64.StdEncoding.DecodeString(m.Attributes[traceContextAttribute])
+	if err != nil {
+		return ctx, trace.SpanContext{}, false
+	}
+	sc, ok := propagation.FromBinary(b)
+	return ctx, sc, ok
+}
+
+// UpdateQueue carries book update notifications from the app to the worker,
+// which fills in the details of the book.
+type UpdateQueue interface {
+	// Publish notifies subscribers that the book with the given ID has been
+	// added or modified. The span context and tags of ctx are propagated to
+	// the subscribers.
+	Publish(ctx context.Context, bookID int64) error
+
+	// Receive calls f for each notification, concurrently, until ctx is done
+	// or an unrecoverable error occurs. If f returns an error, the
+	// notification is delivered again later, as the same attempt.
+	Receive(ctx context.Context, f func(context.Context, *UpdateMessage) error) error
+
+	// Retry delivers msg again after delay, as its next attempt. It is
+	// called by the receiver of msg, which then returns nil from f.
+	Retry(ctx context.Context, msg *UpdateMessage, delay time.Duration) error
+
+	// DeadLetter sets msg aside for inspection, because it has failed
+	// repeatedly with the given error. It is called by the receiver of msg,
+	// which then returns nil from f.
+	DeadLetter(ctx context.Context, msg *UpdateMessage, reason error) error
+
+	// Close releases the resources used by the queue.
+	Close() error
+}
+
+// publishTime returns the time m was first published, if it is known.
+func (m *UpdateMessage) publishTime() (time.Time, bool) {
+	ns, err := strconv.ParseInt(m.Attributes[publishTimeAttribute], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// memoryQueue is an in-process UpdateQueue backed by a channel, so that the
+// app and the worker can run in a single binary for local development and
+// tests. Pending notifications are lost when the process exits.
+type memoryQueue struct {
+	ch         chan *UpdateMessage
+	retryDelay time.Duration // delay before a failed notification is delivered again.
+
+	mu          sync.Mutex
+	deadLetters []*DeadLetter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// DeadLetter is a notification that was set aside after failing
+// repeatedly.
+type DeadLetter struct {
+	Message *UpdateMessage
+	Reason  string
+	Time    time.Time
+}
+
+// Ensure memoryQueue conforms to the UpdateQueue interface.
+var _ UpdateQueue = &memoryQueue{}
+
+var errQueueClosed = errors.New("memoryqueue: queue is closed")
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		ch:         make(chan *UpdateMessage, 100),
+		retryDelay: 10 * time.Second,
+		closed:     make(chan struct{}),
+	}
+}
+
+// Close stops the delivery of notifications. Pending notifications are
+// dropped.
+func (q *memoryQueue) Close() error {
+	q.closeOnce.Do(func() { close(q.closed) })
+	return nil
+}
+
+// Publish queues a notification, blocking while the queue is full.
+func (q *memoryQueue) Publish(ctx context.Context, bookID int64) error {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/memoryqueue.Publish")
+	defer span.End()
+	span.AddAttributes(trace.Int64Attribute("id", bookID))
+
+	err := q.send(ctx, &UpdateMessage{
+		BookID:          bookID,
+		DeliveryAttempt: 1,
+		Attributes:      updateAttributes(ctx),
+	})
+	if err != nil {
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+	}
+	return err
+}
+
+func (q *memoryQueue) send(ctx context.Context, msg *UpdateMessage) error {
+	select {
+	case q.ch <- msg:
+		return nil
+	case <-q.closed:
+		return errQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive calls f for each notification until ctx is done or the queue is
+// closed. Failed notifications are queued again after q.retryDelay.
+func (q *memoryQueue) Receive(ctx context.Context, f func(context.Context, *UpdateMessage) error) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case msg := <-q.ch:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := f(ctx, msg); err != nil {
+					go q.redeliver(msg, q.retryDelay)
+				}
+			}()
+		case <-q.closed:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Retry queues the next attempt of msg after delay.
+func (q *memoryQueue) Retry(_ context.Context, msg *UpdateMessage, delay time.Duration) error {
+	next := *msg
+	next.DeliveryAttempt++
+	go q.redeliver(&next, delay)
+	return nil
+}
+
+// redeliver queues msg again after delay, unless the queue is closed first.
+func (q *memoryQueue) redeliver(msg *UpdateMessage, delay time.Duration) {
+	select {
+	case <-time.After(delay):
+		q.send(context.Background(), msg)
+	case <-q.closed:
+	}
+}
+
+// DeadLetter adds msg to the queue's dead letters.
+func (q *memoryQueue) DeadLetter(_ context.Context, msg *UpdateMessage, reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadLetters = append(q.deadLetters, &DeadLetter{
+		Message: msg,
+		Reason:  reason.Error(),
+		Time:    time.Now(),
+	})
+	return nil
+}
+
+// DeadLetters returns the notifications set aside by DeadLetter, oldest
+// first.
+func (q *memoryQueue) DeadLetters() []*DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*DeadLetter(nil), q.deadLetters...)
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+const (
+	// PubsubTopicID is the topic book updates are published to.
+	PubsubTopicID = "fill-book-details"
+	// PubsubSubscriptionID is the subscription the worker receives book
+	// updates from.
+	PubsubSubscriptionID = "book-worker-sub"
+	// PubsubDeadLetterTopicID is the topic book updates that failed
+	// repeatedly are published to.
+	PubsubDeadLetterTopicID = "fill-book-details-dead-letter"
+)
+
+// Message attributes of retried and dead-lettered updates.
+const (
+	deliveryAttemptAttribute  = "delivery-attempt"
+	deadLetterReasonAttribute = "dead-letter-reason"
+)
+
+// pubsubQueue is an UpdateQueue using Cloud Pub/Sub, so that the app and the
+// worker can run in different processes.
+type pubsubQueue struct {
+	client     *pubsub.Client
+	topic      *pubsub.Topic
+	deadLetter *pubsub.Topic
+}
+
+// Ensure pubsubQueue conforms to the UpdateQueue interface.
+var _ UpdateQueue = &pubsubQueue{}
+
+// newPubsubQueue returns an UpdateQueue that publishes to PubsubTopicID in
+// the given project, creating the topics if they don't exist.
+func newPubsubQueue(projectID string) (*pubsubQueue, error) {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: could not create client: %v", err)
+	}
+
+	topic, err := ensureTopic(ctx, client, PubsubTopicID)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	deadLetter, err := ensureTopic(ctx, client, PubsubDeadLetterTopicID)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &pubsubQueue{client: client, topic: topic, deadLetter: deadLetter}, nil
+}
+
+// [START pubsub_create_topic]
+
+// ensureTopic returns the topic with the given ID, creating it if it doesn't
+// exist.
+func ensureTopic(ctx context.Context, client *pubsub.Client, id string) (*pubsub.Topic, error) {
+	topic := client.Topic(id)
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: could not check for topic %s: %v", id, err)
+	}
+	if !exists {
+		if topic, err = client.CreateTopic(ctx, id); err != nil {
+			return nil, fmt.Errorf("pubsub: could not create topic %s: %v", id, err)
+		}
+	}
+	return topic, nil
+}
+
+// [END pubsub_create_topic]
+
+// Close closes the Pub/Sub client.
+func (q *pubsubQueue) Close() error {
+	q.topic.Stop()
+	q.deadLetter.Stop()
+	return q.client.Close()
+}
+
+// Probe checks that the topic exists.
+func (q *pubsubQueue) Probe(ctx context.Context) error {
+	ok, err := q.topic.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("pubsub: could not check topic %s: %v", q.topic.ID(), err)
+	}
+	if !ok {
+		return fmt.Errorf("pubsub: topic %s does not exist", q.topic.ID())
+	}
+	return nil
+}
+
+// Publish publishes the book ID to the topic, and waits for it to be
+// accepted.
+func (q *pubsubQueue) Publish(ctx context.Context, bookID int64) (err error) {
+	ctx, span := trace.StartSpan(ctx, "bookshelf/pubsub.Publish")
+	span.AddAttributes(trace.Int64Attribute("id", bookID))
+	defer func() {
+		if err != nil {
+			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		}
+		span.End()
+	}()
+
+	return q.publish(ctx, q.topic, &UpdateMessage{
+		BookID:          bookID,
+		DeliveryAttempt: 1,
+		Attributes:      updateAttributes(ctx),
+	}, nil)
+}
+
+// publish publishes msg to topic, with its delivery attempt and the given
+// extra attributes added to its attributes.
+func (q *pubsubQueue) publish(ctx context.Context, topic *pubsub.Topic, msg *UpdateMessage, extra map[string]string) error {
+	b, err := json.Marshal(msg.BookID)
+	if err != nil {
+		return fmt.Errorf("pubsub: could not encode message: %v", err)
+	}
+	attrs := make(map[string]string)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+	for k, v := range extra {
+		attrs[k] = v
+	}
+	attrs[deliveryAttemptAttribute] = strconv.Itoa(msg.DeliveryAttempt)
+
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: b, Attributes: attrs}).Get(ctx); err != nil {
+		return fmt.Errorf("pubsub: could not publish update for book %d to %s: %v", msg.BookID, topic.ID(), err)
+	}
+	return nil
+}
+
+// Retry waits for delay, then publishes the next attempt of msg to the
+// topic. The received message should only be acknowledged once Retry
+// returns, so that it is redelivered if the process stops while waiting.
+func (q *pubsubQueue) Retry(ctx context.Context, msg *UpdateMessage, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	next := *msg
+	next.DeliveryAttempt++
+	return q.publish(ctx, q.topic, &next, nil)
+}
+
+// DeadLetter publishes msg to the dead-letter topic, with the reason it
+// failed in its attributes.
+func (q *pubsubQueue) DeadLetter(ctx context.Context, msg *UpdateMessage, reason error) error {
+	return q.publish(ctx, q.deadLetter, msg, map[string]string{
+		deadLetterReasonAttribute: reason.Error(),
+	})
+}
+
+// Receive receives book updates from PubsubSubscriptionID, creating the
+// subscription if it doesn't exist. Messages are acknowledged once f
+// succeeds, or if they cannot be decoded.
+func (q *pubsubQueue) Receive(ctx context.Context, f func(context.Context, *UpdateMessage) error) error {
+	// Create the topic subscription if it doesn't exist.
+	sub := q.client.Subscription(PubsubSubscriptionID)
+	exists, err := sub.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("pubsub: could not check for subscription: %v", err)
+	}
+	if !exists {
+		if sub, err = q.client.CreateSubscription(ctx, PubsubSubscriptionID, pubsub.SubscriptionConfig{Topic: q.topic}); err != nil {
+			return fmt.Errorf("pubsub: could not create subscription: %v", err)
+		}
+	}
+
+	err = sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		var id int64
+		if err := json.Unmarshal(msg.Data, &id); err != nil {
+			log.Printf("could not decode message data: %#v", msg)
+			msg.Ack()
+			return
+		}
+		// Updates published before delivery attempts were recorded are
+		// first attempts.
+		attempt, err := strconv.Atoi(msg.Attributes[deliveryAttemptAttribute])
+		if err != nil || attempt < 1 {
+			attempt = 1
+		}
+		if err := f(ctx, &UpdateMessage{BookID: id, DeliveryAttempt: attempt, Attributes: msg.Attributes}); err != nil {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return fmt.Errorf("pubsub: could not receive: %v", err)
+	}
+	return nil
+}
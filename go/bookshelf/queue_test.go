@@ -0,0 +1,93 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := newMemoryQueue()
+	q.retryDelay = time.Millisecond
+	defer q.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan int64, 10)
+	done := make(chan error)
+	go func() {
+		failed := false
+		done <- q.Receive(ctx, func(_ context.Context, msg *UpdateMessage) error {
+			received <- msg.BookID
+			// Fail the first delivery of book 2.
+			if msg.BookID == 2 && !failed {
+				failed = true
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}()
+
+	for _, id := range []int64{1, 2} {
+		if err := q.Publish(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := make(map[int64]int)
+	for i := 0; i < 3; i++ {
+		select {
+		case id := <-received:
+			counts[id]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for delivery; got %v", counts)
+		}
+	}
+	if counts[1] != 1 || counts[2] != 2 {
+		t.Errorf("deliveries = %v, want book 1 once and book 2 twice", counts)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Receive returned %v after its context was cancelled", err)
+	}
+}
+
+func TestUpdatePropagation(t *testing.T) {
+	q := newMemoryQueue()
+	defer q.Close()
+
+	key := mustNewKey("bookshelf_test")
+	ctx, err := tag.New(context.Background(), tag.Upsert(key, "value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := trace.StartSpan(ctx, "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+	if err := q.Publish(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-q.ch
+	got, parent, ok := msg.publisherContext(context.Background())
+	if !ok {
+		t.Fatal("message has no span context")
+	}
+	if parent.TraceID != span.SpanContext().TraceID {
+		t.Errorf("trace ID = %v, want the publisher's %v", parent.TraceID, span.SpanContext().TraceID)
+	}
+	if !parent.IsSampled() {
+		t.Error("span context is not sampled")
+	}
+	if v, _ := tag.FromContext(got).Value(key); v != "value" {
+		t.Errorf("tag value = %q, want %q", v, "value")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/context"
+)
+
+// MaxSearchResults is the largest number of books SearchBooks returns.
+const MaxSearchResults = 100
+
+// searchTerms splits a search query into lowercase keywords.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesSearch reports whether every term is a prefix of a word in the
+// book's title, author or description. Backends may use a looser native
+// query and filter its results with matchesSearch, so that search behaves
+// the same everywhere.
+func matchesSearch(b *Book, terms []string) bool {
+	words := searchTerms(b.Title + " " + b.Author + " " + b.Description)
+	for _, term := range terms {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// filterSearch returns the books matching terms, ordered by title and
+// limited to MaxSearchResults.
+func filterSearch(books []*Book, terms []string) []*Book {
+	matches := make([]*Book, 0)
+	for _, b := range books {
+		if matchesSearch(b, terms) {
+			matches = append(matches, b)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Title != matches[j].Title {
+			return matches[i].Title < matches[j].Title
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > MaxSearchResults {
+		matches = matches[:MaxSearchResults]
+	}
+	return matches
+}
+
+// searchByScan implements SearchBooks for backends without a native way to
+// match keywords, by filtering every book in db.
+func searchByScan(ctx context.Context, db BookDatabase, query string) ([]*Book, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return make([]*Book, 0), nil
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterSearch(books, terms), nil
+}
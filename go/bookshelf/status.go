@@ -0,0 +1,32 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "time"
+
+// meanDuration accumulates durations to report their mean.
+//
+// It is used by the status pages of the worker and the cache, ReadWorkerStatus
+// and BookCache.Status. They report totals kept alongside the views recorded
+// by the worker and the cache, rather than data read from the views: in this
+// version of OpenCensus, view.RetrieveData returns the views' live
+// aggregation data, which cannot be read while measurements are recorded.
+type meanDuration struct {
+	sum   time.Duration
+	count int64
+}
+
+func (m *meanDuration) add(d time.Duration) {
+	m.sum += d
+	m.count++
+}
+
+// mean returns the mean of the durations, or 0 if there are none.
+func (m *meanDuration) mean() time.Duration {
+	if m.count == 0 {
+		return 0
+	}
+	return m.sum / time.Duration(m.count)
+}
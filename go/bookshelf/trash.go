@@ -0,0 +1,76 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"log"
+	"time"
+
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+// DefaultTrashRetention is how long deleted books stay in the trash, unless
+// configured otherwise.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgerActor is the actor of purges in the audit log.
+var trashPurgerActor = Actor{ID: "system", Name: "Trash purger"}
+
+// TrashPurger periodically purges the books that have been in the trash for
+// longer than the retention period. Purging is idempotent, so every instance
+// of the app can run one.
+type TrashPurger struct {
+	// Retention is how long books stay in the trash.
+	Retention time.Duration
+	// Interval is the time between purges.
+	Interval time.Duration
+
+	db BookDatabase
+}
+
+// NewTrashPurger returns a TrashPurger of the books in db, which purges
+// every hour.
+func NewTrashPurger(db BookDatabase, retention time.Duration) *TrashPurger {
+	return &TrashPurger{
+		Retention: retention,
+		Interval:  time.Hour,
+		db:        db,
+	}
+}
+
+// Run purges the trash once, and then every Interval until ctx is done.
+// Failed purges are logged, and retried at the next interval.
+func (p *TrashPurger) Run(ctx context.Context) error {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	for {
+		if _, err := p.Purge(ctx); err != nil {
+			log.Printf("Could not purge the trash: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Purge permanently removes the books that have been in the trash for longer
+// than Retention, and returns their IDs.
+func (p *TrashPurger) Purge(ctx context.Context) ([]int64, error) {
+	ctx, span := trace.StartSpan(WithActor(ctx, trashPurgerActor), "bookshelf/trash.Purge")
+	defer span.End()
+
+	ids, err := p.db.PurgeTrash(ctx, time.Now().Add(-p.Retention))
+	span.AddAttributes(trace.Int64Attribute("purged", int64(len(ids))))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d books from the trash.", len(ids))
+	}
+	return ids, err
+}
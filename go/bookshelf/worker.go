@@ -0,0 +1,343 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+)
+
+var (
+	workerLatency   = stats.Float64("bookshelf/worker/latency", "Latency of processing a book update", stats.UnitMilliseconds)
+	workerAttempts  = stats.Int64("bookshelf/worker/delivery_attempts", "Delivery attempt of each processed book update", stats.UnitNone)
+	workerLag       = stats.Float64("bookshelf/worker/lag", "Time from publishing a book update to processing it", stats.UnitMilliseconds)
+	booksAPILatency = stats.Float64("bookshelf/worker/books_api_latency", "Latency of Google Books API calls", stats.UnitMilliseconds)
+
+	keyWorkerOutcome   = mustNewKey("bookshelf_worker_outcome")
+	keyBooksAPIOutcome = mustNewKey("bookshelf_books_api_outcome")
+)
+
+// Outcomes of processing a book update.
+const (
+	outcomeOK         = "ok"          // the book was updated.
+	outcomeSkipped    = "skipped"     // no volumes were found for the book.
+	outcomeRetry      = "retry"       // the update failed, and will be retried.
+	outcomeDeadLetter = "dead_letter" // the update failed too many times.
+	outcomeDropped    = "dropped"     // the update failed, and would fail again.
+	outcomeError      = "error"       // the update failed, and could not be retried.
+)
+
+var (
+	// WorkerLatencyView is the distribution of book update processing
+	// latencies, by outcome ("ok", "skipped", "retry", "dead_letter",
+	// "dropped" or "error").
+	WorkerLatencyView = &view.View{
+		Name:        "bookshelf/worker/latency",
+		Description: "Latency distribution of processing book updates, by outcome",
+		Measure:     workerLatency,
+		TagKeys:     []tag.Key{keyWorkerOutcome},
+		Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000),
+	}
+
+	// WorkerUpdatesView counts processed book updates, by outcome.
+	WorkerUpdatesView = &view.View{
+		Name:        "bookshelf/worker/updates",
+		Description: "Count of processed book updates, by outcome",
+		Measure:     workerLatency,
+		TagKeys:     []tag.Key{keyWorkerOutcome},
+		Aggregation: view.Count(),
+	}
+
+	// WorkerDeliveryAttemptsView is the distribution of the delivery
+	// attempts of processed book updates, by outcome.
+	WorkerDeliveryAttemptsView = &view.View{
+		Name:        "bookshelf/worker/delivery_attempts",
+		Description: "Distribution of the delivery attempts of processed book updates, by outcome",
+		Measure:     workerAttempts,
+		TagKeys:     []tag.Key{keyWorkerOutcome},
+		Aggregation: view.Distribution(1, 2, 3, 4, 5, 6, 8, 10),
+	}
+
+	// WorkerLagView is the distribution of the time from publishing a book
+	// update to processing it, including retries, by outcome.
+	WorkerLagView = &view.View{
+		Name:        "bookshelf/worker/lag",
+		Description: "Distribution of the time from publishing book updates to processing them, by outcome",
+		Measure:     workerLag,
+		TagKeys:     []tag.Key{keyWorkerOutcome},
+		Aggregation: view.Distribution(0, 10, 50, 100, 500, 1000, 5000, 10000, 30000, 60000, 300000, 600000, 3600000),
+	}
+
+	// BooksAPILatencyView is the distribution of Google Books API call
+	// latencies, by outcome ("ok" or "error").
+	BooksAPILatencyView = &view.View{
+		Name:        "bookshelf/worker/books_api_latency",
+		Description: "Latency distribution of Google Books API calls, by outcome",
+		Measure:     booksAPILatency,
+		TagKeys:     []tag.Key{keyBooksAPIOutcome},
+		Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000),
+	}
+
+	// DefaultWorkerViews are the views recorded by Worker.
+	DefaultWorkerViews = []*view.View{
+		WorkerLatencyView,
+		WorkerUpdatesView,
+		WorkerDeliveryAttemptsView,
+		WorkerLagView,
+		BooksAPILatencyView,
+	}
+)
+
+// RetryPolicy decides when failed book updates are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of delivery attempts after which an update
+	// is dead-lettered.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt. It doubles
+	// with each attempt, up to MaxBackoff.
+	InitialBackoff, MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of new Workers.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// Backoff returns the delay before the attempt after the given one.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Worker fills in the details of the books it is notified about through an
+// UpdateQueue, using a MetadataProvider.
+type Worker struct {
+	// Retry decides when failed updates are retried. It must not be changed
+	// once the worker runs.
+	Retry RetryPolicy
+	// Metadata finds the details of books. It must not be changed once the
+	// worker runs.
+	Metadata MetadataProvider
+
+	db    BookDatabase
+	queue UpdateQueue
+	// processed, if set, is called with the outcome of each notification
+	// once it has been recorded, so that tests can wait for the worker.
+	processed func(msg *UpdateMessage, outcome string)
+}
+
+// NewWorker returns a Worker that receives notifications from queue and
+// updates the books in db with metadata from the Google Books API.
+func NewWorker(db BookDatabase, queue UpdateQueue) (*Worker, error) {
+	metadata, err := NewGoogleBooksProvider()
+	if err != nil {
+		return nil, fmt.Errorf("worker: %v", err)
+	}
+	return &Worker{
+		Retry:    DefaultRetryPolicy,
+		Metadata: metadata,
+		db:       db,
+		queue:    queue,
+	}, nil
+}
+
+// Run processes notifications until ctx is done or receiving fails.
+func (w *Worker) Run(ctx context.Context) error {
+	return w.queue.Receive(ctx, w.process)
+}
+
+// process handles a single notification, continuing the publisher's trace.
+func (w *Worker) process(ctx context.Context, msg *UpdateMessage) error {
+	ctx, parent, ok := msg.publisherContext(ctx)
+	var span *trace.Span
+	if ok {
+		ctx, span = trace.StartSpanWithRemoteParent(ctx, "worker/subscribe.Receive", parent)
+	} else {
+		ctx, span = trace.StartSpan(ctx, "worker/subscribe.Receive")
+	}
+	defer span.End()
+	id := msg.BookID
+	span.AddAttributes(
+		trace.Int64Attribute("id", id),
+		trace.Int64Attribute("delivery_attempt", int64(msg.DeliveryAttempt)))
+
+	start := time.Now()
+	outcome := outcomeOK
+	defer func() {
+		span.AddAttributes(trace.StringAttribute("outcome", outcome))
+		ctx, _ := tag.New(ctx, tag.Upsert(keyWorkerOutcome, outcome))
+		ms := []stats.Measurement{
+			workerLatency.M(sinceMillis(start)),
+			workerAttempts.M(int64(msg.DeliveryAttempt)),
+		}
+		published, hasLag := msg.publishTime()
+		if hasLag {
+			ms = append(ms, workerLag.M(sinceMillis(published)))
+		}
+		stats.Record(ctx, ms...)
+		recordWorkerOutcome(outcome, time.Since(published), hasLag)
+		if w.processed != nil {
+			w.processed(msg, outcome)
+		}
+	}()
+
+	log.Printf("[ID %d] Processing attempt %d.", id, msg.DeliveryAttempt)
+	updated, err := w.update(ctx, id)
+	if err != nil {
+		log.Printf("[ID %d] could not update: %v", id, err)
+		span.SetStatus(trace.Status{Code: KindOf(err).TraceCode(), Message: err.Error()})
+		if !retryable(err) {
+			log.Printf("[ID %d] dropped, since retrying would not help.", id)
+			outcome = outcomeDropped
+			return nil
+		}
+		outcome, err = w.fail(ctx, msg, err)
+		return err
+	}
+	if !updated {
+		log.Printf("[ID %d] no matching metadata found, skipped.", id)
+		outcome = outcomeSkipped
+	}
+
+	log.Printf("[ID %d] ACK", id)
+	return nil
+}
+
+// sinceMillis returns the time elapsed since t, in milliseconds.
+func sinceMillis(t time.Time) float64 {
+	return float64(time.Since(t)) / float64(time.Millisecond)
+}
+
+// retryable reports whether an update that failed with err may succeed if
+// it is retried. Updates of books that have been deleted since they were
+// published, for instance, never will.
+func retryable(err error) bool {
+	switch KindOf(err) {
+	case KindNotFound, KindInvalidArgument, KindUnauthorized:
+		return false
+	}
+	return true
+}
+
+// fail retries msg after its update failed with err, or dead-letters it
+// after too many attempts. It returns the outcome, and an error if msg could
+// be neither retried nor dead-lettered, so that the queue redelivers it.
+func (w *Worker) fail(ctx context.Context, msg *UpdateMessage, err error) (string, error) {
+	if msg.DeliveryAttempt >= w.Retry.MaxAttempts {
+		log.Printf("[ID %d] giving up after %d attempts.", msg.BookID, msg.DeliveryAttempt)
+		if err := w.queue.DeadLetter(ctx, msg, err); err != nil {
+			log.Printf("[ID %d] could not dead-letter: %v", msg.BookID, err)
+			return outcomeError, err
+		}
+		return outcomeDeadLetter, nil
+	}
+
+	delay := w.Retry.Backoff(msg.DeliveryAttempt)
+	trace.FromContext(ctx).Annotatef(nil, "Retrying in %v", delay)
+	if err := w.queue.Retry(ctx, msg, delay); err != nil {
+		log.Printf("[ID %d] could not retry: %v", msg.BookID, err)
+		return outcomeError, err
+	}
+	return outcomeRetry, nil
+}
+
+// update retrieves the book with the given ID, finds its metadata and
+// updates the database with the book's details. Books with an ISBN are
+// looked up by ISBN, and others by title and author. It reports whether
+// matching metadata was found.
+func (w *Worker) update(ctx context.Context, bookID int64) (bool, error) {
+	// The worker's changes are not made on behalf of a user.
+	ctx = WithActor(ctx, SystemActor)
+	// The book has just been changed, possibly by another process, so it is
+	// not read from a cache. It is still updated through w.db, which
+	// invalidates the cache.
+	book, err := uncached(w.db).GetBook(ctx, bookID)
+	if err != nil {
+		return false, err
+	}
+
+	m, score, err := w.findMetadata(ctx, book)
+	if err != nil {
+		return false, err
+	}
+	trace.FromContext(ctx).AddAttributes(
+		trace.StringAttribute("match_score", strconv.FormatFloat(score, 'f', 2, 64)))
+	if m == nil || score < minMatchScore {
+		return false, nil
+	}
+
+	// Only replace what the user entered when the match is confident, so
+	// that books are not renamed after unrelated volumes.
+	if score >= confidentMatchScore {
+		book.Title = m.Title
+		book.Author = strings.Join(m.Authors, ", ")
+		book.PublishedDate = m.PublishedDate
+		if book.ISBN == "" && len(m.ISBNs) > 0 {
+			book.ISBN = m.ISBNs[0]
+		}
+	}
+	if book.Author == "" {
+		book.Author = strings.Join(m.Authors, ", ")
+	}
+	if book.PublishedDate == "" {
+		book.PublishedDate = m.PublishedDate
+	}
+	if book.Description == "" {
+		book.Description = m.Description
+	}
+	if book.ImageURL == "" {
+		book.ImageURL = m.ImageURL
+	}
+
+	// If the book was edited since it was read, the update fails with a
+	// conflict and is retried on top of the edit.
+	return true, w.db.UpdateBook(ctx, book)
+}
+
+// findMetadata returns the metadata best matching book and its matchScore,
+// or nil if none was found.
+func (w *Worker) findMetadata(ctx context.Context, book *Book) (*BookMetadata, float64, error) {
+	if book.ISBN != "" {
+		m, err := w.Metadata.LookupISBN(ctx, book.ISBN)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The lookup may find a volume with another ISBN, so it is
+		// scored like any other match.
+		if m != nil {
+			return m, matchScore(book, m), nil
+		}
+	}
+
+	candidates, err := w.Metadata.SearchByTitle(ctx, book.Title, book.Author)
+	if err != nil {
+		return nil, 0, err
+	}
+	var best *BookMetadata
+	var bestScore float64
+	for _, m := range candidates {
+		if score := matchScore(book, m); best == nil || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best, bestScore, nil
+}
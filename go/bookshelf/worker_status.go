@@ -0,0 +1,92 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerStatus summarizes the book updates processed by the workers in this
+// process.
+type WorkerStatus struct {
+	// Processed counts every processing attempt. It is the sum of
+	// Succeeded, Skipped and Failed.
+	Processed int64
+	// Succeeded counts the updates that filled in a book's details.
+	Succeeded int64
+	// Skipped counts the updates for which no volumes were found.
+	Skipped int64
+	// Failed counts the attempts that failed, including those that were
+	// then retried, and those counted in DeadLettered and Dropped.
+	Failed int64
+	// DeadLettered counts the failed updates set aside after failing
+	// repeatedly.
+	DeadLettered int64
+	// Dropped counts the failed updates that retrying would not fix, e.g.
+	// because the book was deleted.
+	Dropped int64
+
+	// MeanLag is the mean time from publishing an update to processing it.
+	MeanLag time.Duration
+	// MeanBooksAPILatency is the mean latency of Google Books API calls.
+	MeanBooksAPILatency time.Duration
+}
+
+// workerTotals are the counts behind WorkerStatus, kept alongside
+// DefaultWorkerViews; see meanDuration.
+var workerTotals = struct {
+	mu              sync.Mutex
+	outcomes        map[string]int64
+	lag             meanDuration
+	booksAPILatency meanDuration
+}{outcomes: make(map[string]int64)}
+
+// recordWorkerOutcome counts an update processed with the given outcome,
+// and its lag if it is known.
+func recordWorkerOutcome(outcome string, lag time.Duration, hasLag bool) {
+	workerTotals.mu.Lock()
+	defer workerTotals.mu.Unlock()
+	workerTotals.outcomes[outcome]++
+	if hasLag {
+		workerTotals.lag.add(lag)
+	}
+}
+
+// recordBooksAPILatency counts a Google Books API call.
+func recordBooksAPILatency(d time.Duration) {
+	workerTotals.mu.Lock()
+	defer workerTotals.mu.Unlock()
+	workerTotals.booksAPILatency.add(d)
+}
+
+// ReadWorkerStatus returns the WorkerStatus of the workers in this process.
+func ReadWorkerStatus() *WorkerStatus {
+	workerTotals.mu.Lock()
+	defer workerTotals.mu.Unlock()
+
+	s := &WorkerStatus{
+		MeanLag:             workerTotals.lag.mean(),
+		MeanBooksAPILatency: workerTotals.booksAPILatency.mean(),
+	}
+	for outcome, n := range workerTotals.outcomes {
+		s.Processed += n
+		switch outcome {
+		case outcomeOK:
+			s.Succeeded += n
+		case outcomeSkipped:
+			s.Skipped += n
+		case outcomeDeadLetter:
+			s.DeadLettered += n
+			s.Failed += n
+		case outcomeDropped:
+			s.Dropped += n
+			s.Failed += n
+		default:
+			s.Failed += n
+		}
+	}
+	return s
+}
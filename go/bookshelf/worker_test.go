@@ -0,0 +1,104 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"golang.org/x/net/context"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{1: time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if got := p.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestWorkerDeadLetter(t *testing.T) {
+	if err := view.Register(DefaultWorkerViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(DefaultWorkerViews...)
+
+	q := newMemoryQueue()
+	defer q.Close()
+	db := newMemoryDB()
+	w, err := NewWorker(db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	// The Books API is down, so updates fail until they are dead-lettered.
+	w.Metadata = &fakeMetadataProvider{err: Errorf(KindUnavailable, "books API unavailable")}
+	outcomes := make(chan string, 10)
+	w.processed = func(_ *UpdateMessage, outcome string) { outcomes <- outcome }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := ReadWorkerStatus()
+	go w.Run(ctx)
+
+	// wait returns once an update has the given final outcome. The views
+	// are only read once the worker is done recording: the view data is not
+	// a copy, so reading it while recording is a data race.
+	wait := func(final string) {
+		for {
+			select {
+			case outcome := <-outcomes:
+				if outcome == final {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for an update to be %s", final)
+			}
+		}
+	}
+
+	// Updates of a book that does not exist are dropped without retries.
+	if err := q.Publish(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	wait(outcomeDropped)
+
+	id, err := db.AddBook(ctx, &Book{Title: "Title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Publish(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	wait(outcomeDeadLetter)
+
+	rows, err := view.RetrieveData(WorkerUpdatesView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(map[string]int64)
+	for _, row := range rows {
+		updates[row.Tags[0].Value] = row.Data.(*view.CountData).Value
+	}
+	if updates[outcomeDropped] != 1 || updates[outcomeRetry] != 2 || updates[outcomeDeadLetter] != 1 {
+		t.Errorf("updates by outcome = %v, want 1 dropped, 2 retries and 1 dead letter", updates)
+	}
+	if dl := q.DeadLetters(); len(dl) != 1 || dl[0].Message.BookID != id || dl[0].Message.DeliveryAttempt != 3 {
+		t.Errorf("dead letters = %v, want attempt 3 of the update of book %d", dl, id)
+	}
+
+	status := ReadWorkerStatus()
+	if got := status.Processed - before.Processed; got != 4 || status.Failed-before.Failed != 4 || status.DeadLettered-before.DeadLettered != 1 || status.Dropped-before.Dropped != 1 || status.Succeeded != before.Succeeded {
+		t.Errorf("ReadWorkerStatus() = %+v, was %+v; want 4 more failed attempts, 1 dead-lettered and 1 dropped", status, before)
+	}
+	if status.MeanLag <= 0 {
+		t.Errorf("MeanLag = %v, want > 0", status.MeanLag)
+	}
+}
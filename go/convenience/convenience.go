@@ -16,53 +16,51 @@ package convenience
 
 import (
 	"fmt"
-	"go.opencensus.io/stats"
-	"log"
 	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
 )
 
 type Int64Recorder func(int64) stats.Measurement
 
-func NewCounter(prefix, name, desc string) (Int64Recorder, *stats.View) {
+func NewCounter(prefix, name, desc string) (Int64Recorder, *view.View) {
 	fullname := fmt.Sprintf("%s/%s", prefix, name)
-	m, err := stats.NewMeasureInt64(fullname, desc, "")
-	if err != nil {
-		log.Panic("unable to create measure", err)
-	}
-	v, err := stats.NewView(fullname, desc, nil, m, stats.SumAggregation{}, stats.Cumulative{})
-	if err != nil {
-		log.Panic("unable to create view", err)
+	m := stats.Int64(fullname, desc, stats.UnitNone)
+	v := &view.View{
+		Name:        fullname,
+		Description: desc,
+		Measure:     m,
+		Aggregation: view.Sum(),
 	}
 	return m.M, v
 }
 
-func NewGauge(prefix, name, desc string) (Int64Recorder, *stats.View) {
+func NewGauge(prefix, name, desc string) (Int64Recorder, *view.View) {
 	fullname := fmt.Sprintf("%s/%s", prefix, name)
-	m, err := stats.NewMeasureInt64(fullname, desc, "")
-	if err != nil {
-		log.Panic("unable to create measure", err)
-	}
-	// TODO: change aggregation function to Latest
-	v, err := stats.NewView(fullname, desc, nil, m, stats.SumAggregation{}, stats.Cumulative{})
-	if err != nil {
-		log.Panic("unable to create view", err)
+	m := stats.Int64(fullname, desc, stats.UnitNone)
+	v := &view.View{
+		Name:        fullname,
+		Description: desc,
+		Measure:     m,
+		Aggregation: view.LastValue(),
 	}
 	return m.M, v
 }
 
 type Stopwatch struct {
-	m            *stats.MeasureFloat64
-	Distribution *stats.View
+	m            *stats.Float64Measure
+	Distribution *view.View
 }
 
 func NewTimer(prefix, desc string) Stopwatch {
-	m, err := stats.NewMeasureFloat64(fmt.Sprintf("%s/%s", prefix, "time"), desc, "")
-	if err != nil {
-		log.Fatal("unable to create measure", err)
-	}
-	v, err := stats.NewView(fmt.Sprintf("%s/%s", prefix, "time"), desc, nil, m, defaultTimeDistribution(), stats.Cumulative{})
-	if err != nil {
-		log.Fatal("unable to create view", err)
+	fullname := fmt.Sprintf("%s/%s", prefix, "time")
+	m := stats.Float64(fullname, desc, "us")
+	v := &view.View{
+		Name:        fullname,
+		Description: desc,
+		Measure:     m,
+		Aggregation: defaultTimeDistribution(),
 	}
 	return Stopwatch{m: m, Distribution: v}
 }
@@ -77,6 +75,6 @@ func (sw Stopwatch) Start() Stopper {
 	}
 }
 
-func defaultTimeDistribution() stats.DistributionAggregation {
-	return stats.DistributionAggregation{0.0, 0.5, 1.0, 0.5e1, 1e1, 0.5e2, 1e2, 0.5e3, 1e3, 1.5e3, 1e4, 1.5e4, 1e5, 1.5e5, 1e6, 1e7, 1e8}
+func defaultTimeDistribution() *view.Aggregation {
+	return view.Distribution(0.0, 0.5, 1.0, 0.5e1, 1e1, 0.5e2, 1e2, 0.5e3, 1e3, 1.5e3, 1e4, 1.5e4, 1e5, 1.5e5, 1e6, 1e7, 1e8)
 }
@@ -16,10 +16,11 @@ package dbtrace
 
 import (
 	"context"
-	"go.opencensus.io/trace"
-	"go.opencensus.io/stats"
 	"database/sql"
-	"github.com/census-instrumentation/opencensus-experiments/go/convenience"
+
+	"github.com/census-ecosystem/opencensus-experiments/go/convenience"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/trace"
 )
 
 const (
@@ -45,8 +46,8 @@ type Query struct {
 }
 
 func StartQuery(ctx context.Context, query string) *Query {
-	ctx, span := trace.StartSpan(ctx, queryOperation)
-	span.SetAttributes(trace.StringAttribute{Key: "query", Value: query})
+	_, span := trace.StartSpan(ctx, queryOperation)
+	span.AddAttributes(trace.StringAttribute("query", query))
 	return &Query{stop: QueryTime.Start(), Span: span, Query: query}
 }
 
@@ -79,12 +80,12 @@ type Exec struct {
 	Query  string
 	Result sql.Result
 	Err    error
-	stop   func()stats.Measurement
+	stop   func() stats.Measurement
 }
 
 func StartExec(ctx context.Context, stmt string) *Exec {
-	ctx, span := trace.StartSpan(ctx, execOperation)
-	span.SetAttributes(trace.StringAttribute{Key: "query", Value: stmt})
+	_, span := trace.StartSpan(ctx, execOperation)
+	span.AddAttributes(trace.StringAttribute("query", stmt))
 	return &Exec{stop: ExecTime.Start(), Query: stmt, Span: span}
 }
 
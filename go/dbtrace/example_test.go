@@ -12,67 +12,65 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// TODO: High-level file comment.
-
 package dbtrace_test
 
 import (
 	"context"
 	"database/sql"
 	"log"
-	"time"
 
-	"github.com/census-instrumentation/opencensus-experiments/go/dbtrace"
-	"github.com/census-instrumentation/opencensus-experiments/go/testing/mockstats"
-	"github.com/census-instrumentation/opencensus-experiments/go/testing/mocktrace"
-	"go.opencensus.io/stats"
+	"github.com/census-ecosystem/opencensus-experiments/go/dbtrace"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 )
 
-func init() {
-	mocktrace.RegisterExporter()
-	mockstats.RegisterExporter()
+// printExporter logs the spans of the example.
+type printExporter struct{}
+
+func (printExporter) ExportSpan(s *trace.SpanData) {
+	log.Printf("span %s: %v, %v", s.Name, s.Attributes, s.Status)
 }
 
-func ExampleDbtrace() {
-	dbtrace.ExecTime.Distribution.Subscribe()
-	dbtrace.QueryTime.Distribution.Subscribe()
-	dbtrace.RowsAffected.Subscribe()
+func Example() {
+	if err := view.Register(
+		dbtrace.ExecTime.Distribution,
+		dbtrace.QueryTime.Distribution,
+		dbtrace.RowsPerQuery,
+		dbtrace.RowsAffected,
+	); err != nil {
+		log.Fatal(err)
+	}
+	trace.RegisterExporter(printExporter{})
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
 
 	ctx := context.Background()
 
-	db, err := sql.Open("", "")
+	// The driver must be registered, e.g. by importing
+	// github.com/go-sql-driver/mysql.
+	db, err := sql.Open("mysql", "user:password@/dbname")
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer db.Close()
 
-	ps, _ := db.PrepareContext(ctx, "")
-
-	_ = ps
-
-	exec := dbtrace.StartExec(ctx, "CREATE TABLE")
+	exec := dbtrace.StartExec(ctx, "CREATE TABLE books (title VARCHAR(255))")
 	exec.Result, exec.Err = db.ExecContext(ctx, exec.Query)
 	exec.End(ctx)
-
-	spans := mocktrace.Spans("opencensus.io/db/exec")
-	if len(spans) != 1 {
-		log.Fatalf("expected a single span: %#v", spans)
-	}
-	span := spans[0]
-	if span.Attributes["query"] != "CREATE TABLE" {
-		log.Fatalf("expected query attribute: %#v", span)
+	if exec.Err != nil {
+		log.Fatal(exec.Err)
 	}
 
-	q := dbtrace.StartQuery(ctx, "")
+	q := dbtrace.StartQuery(ctx, "SELECT title FROM books")
 	q.Rows, q.Err = db.QueryContext(ctx, q.Query)
-
-	if q.Err != nil {
+	if q.Err == nil {
 		for q.NextRow() {
-			q.Rows.Scan()
+			var title string
+			if err := q.Rows.Scan(&title); err != nil {
+				log.Fatal(err)
+			}
+			log.Println(title)
 		}
+		q.Rows.Close()
 	}
-
 	q.End(ctx)
-
-	stats.SetReportingPeriod(100 * time.Millisecond)
-	time.Sleep(150 * time.Millisecond)
 }